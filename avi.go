@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+	"time"
+)
+
+// Posiciones de los campos que se completan al cerrar el AVI. La cabecera
+// tiene tamaño fijo: RIFF, LIST hdrl (avih, LIST strl con strh y strf) y
+// después LIST movi con los frames.
+const (
+	aviOffsetRIFFSize     = 4
+	aviOffsetMaxBytes     = 36
+	aviOffsetTotalFrames  = 48
+	aviOffsetAvihBuffer   = 60
+	aviOffsetStrhLength   = 140
+	aviOffsetStrhBuffer   = 144
+	aviOffsetMoviSize     = 216
+	aviOffsetMoviFourCC   = 220
+	aviFlagHasIndex       = 0x10
+	aviIndexFlagKeyframe  = 0x10
+	aviHeaderListSize     = 192
+	aviMainHeaderSize     = 56
+	aviStreamHeaderSize   = 56
+	aviBitmapInfoSize     = 40
+	aviStreamListSize     = 4 + 8 + aviStreamHeaderSize + 8 + aviBitmapInfoSize
+	aviChunkVideoFourCC   = "00dc"
+	aviCompressionMJPEG   = "MJPG"
+	aviStreamTypeVideo    = "vids"
+	aviBitmapPlanesAndBPP = 1 | 24<<16
+	// aviIndexEntrySize es lo que ocupa cada frame en idx1
+	aviIndexEntrySize = 16
+	// aviMaxSize es el mayor tamaño RIFF que caben en los campos de 32 bits
+	aviMaxSize = 1<<32 - 1
+)
+
+// errAVIFull indica que el frame no cabe sin pasar de aviMaxSize; el
+// archivo sigue siendo válido si se cierra sin él
+var errAVIFull = errors.New("avi size limit reached")
+
+// aviWriter escribe un AVI con una pista de video MJPEG: cada frame es un
+// JPEG tal cual, sin recodificar
+type aviWriter struct {
+	file    *os.File
+	width   int
+	height  int
+	fps     int
+	offset  uint32
+	index   bytes.Buffer
+	frames  uint32
+	maxSize uint32
+	// limit es el tamaño RIFF máximo; aviMaxSize salvo en las pruebas
+	limit uint64
+}
+
+// newAVIWriter crea el archivo; las dimensiones salen del primer JPEG
+func newAVIWriter(path string, firstFrame []byte, fps int) (*aviWriter, error) {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(firstFrame))
+	if err != nil {
+		return nil, fmt.Errorf("invalid first frame: %v", err)
+	}
+	if fps < 1 {
+		fps = 1
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &aviWriter{
+		file:   file,
+		width:  config.Width,
+		height: config.Height,
+		fps:    fps,
+		offset: 4,
+		limit:  aviMaxSize,
+	}
+	if _, err := file.Write(w.header()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// header construye la cabecera con los contadores a cero
+func (w *aviWriter) header() []byte {
+	var b bytes.Buffer
+	le := func(v uint32) { binary.Write(&b, binary.LittleEndian, v) }
+
+	b.WriteString("RIFF")
+	le(0)
+	b.WriteString("AVI ")
+
+	b.WriteString("LIST")
+	le(aviHeaderListSize)
+	b.WriteString("hdrl")
+
+	b.WriteString("avih")
+	le(aviMainHeaderSize)
+	le(uint32(time.Second / time.Microsecond / time.Duration(w.fps))) // microsegundos por frame
+	le(0)                                                             // bytes por segundo máximos
+	le(0)                                                             // granularidad de relleno
+	le(aviFlagHasIndex)
+	le(0) // frames totales
+	le(0) // frames iniciales
+	le(1) // pistas
+	le(0) // buffer sugerido
+	le(uint32(w.width))
+	le(uint32(w.height))
+	le(0)
+	le(0)
+	le(0)
+	le(0)
+
+	b.WriteString("LIST")
+	le(aviStreamListSize)
+	b.WriteString("strl")
+
+	b.WriteString("strh")
+	le(aviStreamHeaderSize)
+	b.WriteString(aviStreamTypeVideo)
+	b.WriteString(aviCompressionMJPEG)
+	le(0) // flags
+	le(0) // prioridad e idioma
+	le(0) // frames iniciales
+	le(1) // escala
+	le(uint32(w.fps))
+	le(0)          // inicio
+	le(0)          // longitud en frames
+	le(0)          // buffer sugerido
+	le(0xFFFFFFFF) // calidad por defecto
+	le(0)          // tamaño de muestra
+	binary.Write(&b, binary.LittleEndian, [4]uint16{0, 0, uint16(w.width), uint16(w.height)})
+
+	b.WriteString("strf")
+	le(aviBitmapInfoSize)
+	le(aviBitmapInfoSize)
+	le(uint32(w.width))
+	le(uint32(w.height))
+	le(aviBitmapPlanesAndBPP)
+	b.WriteString(aviCompressionMJPEG)
+	le(uint32(w.width * w.height * 3))
+	le(0)
+	le(0)
+	le(0)
+	le(0)
+
+	b.WriteString("LIST")
+	le(0)
+	b.WriteString("movi")
+	return b.Bytes()
+}
+
+// WriteFrame añade un JPEG como frame de video
+func (w *aviWriter) WriteFrame(data []byte) error {
+	// Tamaño RIFF final si se añade este frame, contando su entrada de índice
+	chunkSize := 8 + uint64(len(data)) + uint64(len(data)%2)
+	final := aviRIFFSize(uint64(w.offset)+chunkSize, uint64(w.index.Len())+aviIndexEntrySize)
+	if final > w.limit {
+		return errAVIFull
+	}
+
+	size := uint32(len(data))
+	var chunk [8]byte
+	copy(chunk[:4], aviChunkVideoFourCC)
+	binary.LittleEndian.PutUint32(chunk[4:], size)
+	if _, err := w.file.Write(chunk[:]); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(data); err != nil {
+		return err
+	}
+	// Los chunks RIFF se alinean a 2 bytes
+	if size%2 == 1 {
+		if _, err := w.file.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	w.index.WriteString(aviChunkVideoFourCC)
+	binary.Write(&w.index, binary.LittleEndian, [3]uint32{aviIndexFlagKeyframe, w.offset, size})
+	w.offset += 8 + size + size%2
+	w.frames++
+	if size > w.maxSize {
+		w.maxSize = size
+	}
+	return nil
+}
+
+// aviRIFFSize calcula el tamaño RIFF: "AVI " + LIST hdrl + LIST movi + idx1
+func aviRIFFSize(moviSize, indexSize uint64) uint64 {
+	return aviOffsetMoviFourCC - 8 + moviSize + 8 + indexSize
+}
+
+// Close escribe el índice y completa los tamaños y contadores de la cabecera
+func (w *aviWriter) Close() error {
+	moviSize := w.offset
+	indexSize := uint32(w.index.Len())
+	var chunk [8]byte
+	copy(chunk[:4], "idx1")
+	binary.LittleEndian.PutUint32(chunk[4:], indexSize)
+	if _, err := w.file.Write(chunk[:]); err != nil {
+		w.file.Close()
+		return err
+	}
+	if _, err := w.index.WriteTo(w.file); err != nil {
+		w.file.Close()
+		return err
+	}
+
+	riffSize := uint32(aviRIFFSize(uint64(moviSize), uint64(indexSize)))
+	patches := []struct {
+		offset int64
+		value  uint32
+	}{
+		{aviOffsetRIFFSize, riffSize},
+		{aviOffsetMaxBytes, w.maxSize * uint32(w.fps)},
+		{aviOffsetTotalFrames, w.frames},
+		{aviOffsetAvihBuffer, w.maxSize},
+		{aviOffsetStrhLength, w.frames},
+		{aviOffsetStrhBuffer, w.maxSize},
+		{aviOffsetMoviSize, moviSize},
+	}
+	for _, patch := range patches {
+		if _, err := w.file.Seek(patch.offset, io.SeekStart); err != nil {
+			w.file.Close()
+			return err
+		}
+		if err := binary.Write(w.file, binary.LittleEndian, patch.value); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
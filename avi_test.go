@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testJPEG(t *testing.T, width, height int, shade uint8) []byte {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = shade + uint8(i%7)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAVIWriterLayout(t *testing.T) {
+	frames := [][]byte{testJPEG(t, 64, 48, 10), testJPEG(t, 64, 48, 90), testJPEG(t, 64, 48, 200)}
+	// Un frame de tamaño impar comprueba el relleno a 2 bytes
+	if len(frames[1])%2 == 0 {
+		frames[1] = append(frames[1], 0)
+	}
+
+	path := filepath.Join(t.TempDir(), "video.avi")
+	w, err := newAVIWriter(path, frames[0], 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range frames {
+		if err := w.WriteFrame(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u32 := func(offset int) uint32 { return binary.LittleEndian.Uint32(data[offset:]) }
+
+	fourCCs := []struct {
+		offset int
+		want   string
+	}{
+		{0, "RIFF"}, {8, "AVI "}, {12, "LIST"}, {20, "hdrl"}, {24, "avih"},
+		{88, "LIST"}, {96, "strl"}, {100, "strh"}, {108, aviStreamTypeVideo},
+		{112, aviCompressionMJPEG}, {164, "strf"}, {aviOffsetMoviSize - 4, "LIST"},
+		{aviOffsetMoviFourCC, "movi"},
+	}
+	for _, f := range fourCCs {
+		if got := string(data[f.offset : f.offset+4]); got != f.want {
+			t.Errorf("fourcc at %d = %q, want %q", f.offset, got, f.want)
+		}
+	}
+	if got := u32(16); got != aviHeaderListSize || 20+int(got) != aviOffsetMoviSize-4 {
+		t.Errorf("hdrl size = %d, want %d", got, aviHeaderListSize)
+	}
+	if got := u32(92); 96+int(got) != aviOffsetMoviSize-4 {
+		t.Errorf("strl size = %d does not end at movi list", got)
+	}
+
+	headers := []struct {
+		name   string
+		offset int
+		want   uint32
+	}{
+		{"riff size", aviOffsetRIFFSize, uint32(len(data) - 8)},
+		{"microseconds per frame", 32, 200000},
+		{"total frames", aviOffsetTotalFrames, 3},
+		{"width", 64, 64},
+		{"height", 68, 48},
+		{"strh rate", 132, 5},
+		{"strh length", aviOffsetStrhLength, 3},
+	}
+	for _, h := range headers {
+		if got := u32(h.offset); got != h.want {
+			t.Errorf("%s = %d, want %d", h.name, got, h.want)
+		}
+	}
+	maxSize := uint32(0)
+	for _, frame := range frames {
+		if uint32(len(frame)) > maxSize {
+			maxSize = uint32(len(frame))
+		}
+	}
+	if u32(aviOffsetAvihBuffer) != maxSize || u32(aviOffsetStrhBuffer) != maxSize {
+		t.Errorf("suggested buffer = %d/%d, want %d", u32(aviOffsetAvihBuffer), u32(aviOffsetStrhBuffer), maxSize)
+	}
+
+	// idx1 va justo después de movi y sus offsets cuentan desde "movi"
+	idx := aviOffsetMoviFourCC + int(u32(aviOffsetMoviSize))
+	if string(data[idx:idx+4]) != "idx1" || int(u32(idx+4)) != len(frames)*aviIndexEntrySize {
+		t.Fatalf("idx1 not found at %d", idx)
+	}
+	if idx+8+len(frames)*aviIndexEntrySize != len(data) {
+		t.Errorf("file has %d bytes after idx1", len(data)-idx-8-len(frames)*aviIndexEntrySize)
+	}
+	for i, frame := range frames {
+		entry := idx + 8 + i*aviIndexEntrySize
+		offset, size := int(u32(entry+8)), u32(entry+12)
+		if string(data[entry:entry+4]) != aviChunkVideoFourCC || u32(entry+4) != aviIndexFlagKeyframe {
+			t.Errorf("index entry %d: bad chunk id or flags", i)
+		}
+		chunk := aviOffsetMoviFourCC + offset
+		if string(data[chunk:chunk+4]) != aviChunkVideoFourCC || u32(chunk+4) != size || int(size) != len(frame) {
+			t.Errorf("index entry %d points to offset %d with size %d", i, offset, size)
+			continue
+		}
+		if !bytes.Equal(data[chunk+8:chunk+8+int(size)], frame) {
+			t.Errorf("frame %d data mismatch", i)
+		}
+	}
+}
+
+func TestAVIWriterLimit(t *testing.T) {
+	frame := testJPEG(t, 32, 32, 50)
+	path := filepath.Join(t.TempDir(), "video.avi")
+	w, err := newAVIWriter(path, frame, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Caben justo dos frames con su índice
+	chunk := uint64(8 + len(frame) + len(frame)%2)
+	w.limit = aviRIFFSize(4+2*chunk, 2*aviIndexEntrySize)
+
+	for i := 0; i < 2; i++ {
+		if err := w.WriteFrame(frame); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+	}
+	if err := w.WriteFrame(frame); err != errAVIFull {
+		t.Fatalf("third frame: err = %v, want errAVIFull", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(info.Size()-8) != w.limit {
+		t.Errorf("riff size = %d, want %d", info.Size()-8, w.limit)
+	}
+	data, _ := os.ReadFile(path)
+	if got := binary.LittleEndian.Uint32(data[aviOffsetTotalFrames:]); got != 2 {
+		t.Errorf("total frames = %d, want 2", got)
+	}
+}
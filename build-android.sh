@@ -29,7 +29,7 @@ if [ "$TERMUX" != "" ]; then
     
     # Compilar para la arquitectura actual
     echo "🔨 Compilando para $(go env GOARCH)..."
-    go build -o alien-cam main.go
+    go build -o alien-cam .
     
     if [ $? -eq 0 ]; then
         echo "✅ Compilación exitosa"
@@ -41,5 +41,6 @@ if [ "$TERMUX" != "" ]; then
     fi
 else
     echo "⚠️  Este script está diseñado para Termux/Android"
-    echo "💻 Para compilar en otros sistemas, usa: go build -o alien-cam main.go"
+    echo "💻 Para compilar en este equipo (carta de ajuste): go build -o alien-cam ."
+    echo "📱 Para compilar para el teléfono: CGO_ENABLED=0 GOOS=android GOARCH=arm64 go build -o alien-cam ."
 fi
\ No newline at end of file
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CameraSource abstrae el backend que produce las imágenes de la cámara.
+// Los handlers HTTP solo hablan con esta interfaz, así el servidor puede
+// funcionar con Termux, otros backends o fuentes falsas en pruebas.
+type CameraSource interface {
+	// Open prepara el backend y verifica que la cámara esté disponible
+	Open() error
+	// CaptureFrame devuelve un frame codificado como JPEG
+	CaptureFrame() ([]byte, error)
+	// Capabilities describe la cámara y los formatos que ofrece
+	Capabilities() CameraCapabilities
+	// Close libera los recursos del backend
+	Close() error
+}
+
+// VideoSource es implementada por las fuentes que además entregan video
+// ya codificado (H.264 o VP8) listo para reenviar o grabar
+type VideoSource interface {
+	VideoFrames() *FrameBroker
+}
+
+// CameraCapabilities describe lo que puede hacer una fuente de cámara
+type CameraCapabilities struct {
+	// ID identifica la cámara de forma estable aunque Name cambie al abrirla
+	// o con cada publicador; con él se guardan las zonas de movimiento
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Backend     string   `json:"backend"`
+	Resolution  string   `json:"resolution"`
+	Resolutions []string `json:"resolutions,omitempty"`
+	Formats     []string `json:"formats"`
+	MaxFPS      int      `json:"maxFps,omitempty"`
+}
+
+// newCameraSource crea la fuente de cámara indicada por nombre.
+// Sin nombre se usa Termux en Android y el patrón de prueba en otros sistemas.
+func newCameraSource(name string) (CameraSource, error) {
+	if name == "" && !isAndroidEnvironment() {
+		log.Println("💡 Entorno no Android: usando patrón de prueba (ALIEN_CAM_SOURCE=termux para forzar Termux)")
+		name = "testpattern"
+	}
+
+	// Una URL RTSP convierte alien-cam en pasarela de otra cámara
+	if strings.HasPrefix(strings.ToLower(name), "rtsp://") {
+		return NewRTSPCameraSource(name)
+	}
+
+	switch strings.ToLower(name) {
+	case "", "termux":
+		return NewTermuxCameraSource("0"), nil
+	case "testpattern", "test":
+		width, height := 640, 480
+		if value := os.Getenv("ALIEN_CAM_RESOLUTION"); value != "" {
+			w, h, err := parseResolution(value)
+			if err != nil {
+				return nil, err
+			}
+			width, height = w, h
+		}
+		fps := 10
+		if value := os.Getenv("ALIEN_CAM_FPS"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid fps: %s", value)
+			}
+			fps = n
+		}
+		return NewTestPatternSource(width, height, fps)
+	case "webrtc":
+		return NewWebRTCIngestSource(), nil
+	default:
+		return nil, fmt.Errorf("unknown camera source: %s", name)
+	}
+}
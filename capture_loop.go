@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// captureRetryDelay es la espera tras una captura fallida antes de reintentar
+	captureRetryDelay = time.Second
+	// captureOfflineAfter es cuánto tiempo fallando marca la cámara como
+	// desconectada; cada intento puede tardar varios segundos, así que se
+	// mide el tiempo y no el número de fallos
+	captureOfflineAfter = 10 * time.Second
+)
+
+// startCaptureLoop lanza la goroutine única que captura frames y los publica
+// en el broker. No hace nada si el bucle ya está corriendo.
+func (cs *CameraServer) startCaptureLoop() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if cs.captureStop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	cs.captureStop = stop
+	cs.captureDone = done
+	cs.running = true
+
+	go cs.captureLoop(stop, done)
+	log.Println("🔁 Bucle de captura iniciado")
+
+	// Las fuentes que solo producen JPEG se codifican a H.264 si hay ffmpeg
+	if _, ok := cs.source.(VideoSource); !ok && isFFmpegAvailable() {
+		fps := cs.source.Capabilities().MaxFPS
+		cs.encoder = NewVideoEncoder(cs.frames, fps)
+		if err := cs.encoder.Start(); err != nil {
+			log.Printf("⚠️  No se pudo iniciar el codificador H.264: %v", err)
+			cs.encoder = nil
+		}
+	}
+}
+
+// stopCaptureLoop detiene el bucle de captura y espera a que termine
+func (cs *CameraServer) stopCaptureLoop() {
+	cs.mutex.Lock()
+	stop := cs.captureStop
+	done := cs.captureDone
+	encoder := cs.encoder
+	cs.captureStop = nil
+	cs.captureDone = nil
+	cs.encoder = nil
+	cs.running = false
+	cs.mutex.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+	if encoder != nil {
+		encoder.Stop()
+	}
+	cs.frames.Reset()
+	log.Println("⏹️  Bucle de captura detenido")
+}
+
+func (cs *CameraServer) captureLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	// Solo se publica el primer fallo seguido, el paso a desconectada y la
+	// recuperación, no cada reintento
+	failures := 0
+	var failedSince time.Time
+	offline := false
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		imgData, err := cs.captureImage()
+		if err != nil {
+			log.Printf("❌ Error en bucle de captura: %v", err)
+			if failures == 0 {
+				failedSince = time.Now()
+				cs.events.Publish(eventCaptureFailed, gin.H{"camera": cs.cameraName(), "error": err.Error()})
+			}
+			failures++
+			if !offline && time.Since(failedSince) >= captureOfflineAfter {
+				offline = true
+				log.Printf("📴 Cámara sin imagen desde hace %v", time.Since(failedSince).Round(time.Second))
+				cs.events.Publish(eventCameraOffline, gin.H{"camera": cs.cameraName(), "error": err.Error(), "failures": failures})
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(captureRetryDelay):
+			}
+			continue
+		}
+
+		if failures > 0 {
+			cs.events.Publish(eventCaptureRecovered, gin.H{"camera": cs.cameraName(), "failures": failures})
+			failures = 0
+			offline = false
+		}
+		cs.frames.Publish(&Frame{Data: imgData, Codec: codecJPEG, Keyframe: true, Timestamp: time.Now()})
+	}
+}
+
+// isRunning indica si el bucle de captura está activo
+func (cs *CameraServer) isRunning() bool {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	return cs.running
+}
+
+// captureStopped devuelve un canal que se cierra al detener el bucle de
+// captura, o nil si no está corriendo
+func (cs *CameraServer) captureStopped() <-chan struct{} {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.captureStop == nil {
+		return nil
+	}
+	return cs.captureStop
+}
+
+// snapshot devuelve un JPEG actual: con el bucle de captura activo se usa
+// el último frame compartido; si no, se captura directamente de la fuente
+func (cs *CameraServer) snapshot() ([]byte, error) {
+	if cs.isRunning() {
+		if frame := cs.frames.WaitFrame(time.Second, 5*time.Second); frame != nil {
+			return frame.Data, nil
+		}
+		return nil, fmt.Errorf("no frames available")
+	}
+	return cs.captureImage()
+}
+
+// videoFrames devuelve el broker de video codificado de la fuente activa o
+// del codificador H.264, o nil si no hay video codificado disponible
+func (cs *CameraServer) videoFrames() *FrameBroker {
+	if video, ok := cs.source.(VideoSource); ok {
+		return video.VideoFrames()
+	}
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+	if cs.encoder != nil {
+		return cs.encoder.VideoFrames()
+	}
+	return nil
+}
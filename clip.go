@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// clipDefaultPreRoll es cuánto video reciente se guarda en memoria
+	clipDefaultPreRoll = 10 * time.Second
+	// clipDefaultBufferMB limita la memoria del buffer de pre-evento
+	clipDefaultBufferMB = 32
+	// clipDefaultPostRoll es lo que se graba tras la petición si no se indica
+	clipDefaultPostRoll = 10 * time.Second
+	// clipMaxPostRoll limita la duración de la parte posterior de un clip
+	clipMaxPostRoll = 5 * time.Minute
+	clipBuffer      = 120
+)
+
+// ClipRecorder mantiene un buffer circular con los últimos segundos de
+// video (H.264 de la ingesta o del codificador, o los JPEG del bucle de
+// captura) para que un clip pedido incluya lo ocurrido antes de la petición.
+type ClipRecorder struct {
+	dir      string
+	video    func() *FrameBroker
+	frames   *FrameBroker
+	camera   func() string
+	events   *EventBus
+	preRoll  time.Duration
+	maxBytes int
+
+	mutex   sync.Mutex
+	buffer  []*Frame
+	bytes   int
+	pending map[*pendingClip]struct{}
+}
+
+// pendingClip es un clip que sigue recibiendo su parte posterior
+type pendingClip struct {
+	path string
+	// file se reserva al pedir el clip para que dos clips del mismo
+	// segundo no compartan nombre
+	file   *os.File
+	codec  string
+	camera string
+	event  string
+	until  time.Time
+	pre    []*Frame
+	writer clipWriter
+	first  time.Time
+	last   time.Time
+}
+
+// clipWriter escribe los frames de un clip en su contenedor
+type clipWriter interface {
+	writeFrame(frame *Frame) error
+	Close() error
+}
+
+// ClipRequest es el cuerpo opcional de POST /api/clip (en segundos)
+type ClipRequest struct {
+	Pre  *float64 `json:"pre"`
+	Post *float64 `json:"post"`
+}
+
+// NewClipRecorder crea el buffer de pre-evento con la configuración de
+// ALIEN_CAM_PREROLL y ALIEN_CAM_PREROLL_MB
+func NewClipRecorder(dir string, video func() *FrameBroker, frames *FrameBroker) *ClipRecorder {
+	r := &ClipRecorder{
+		dir:      dir,
+		video:    video,
+		frames:   frames,
+		preRoll:  clipDefaultPreRoll,
+		maxBytes: clipDefaultBufferMB * 1024 * 1024,
+		pending:  make(map[*pendingClip]struct{}),
+	}
+
+	if value := os.Getenv("ALIEN_CAM_PREROLL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			r.preRoll = d
+		} else {
+			log.Printf("⚠️  ALIEN_CAM_PREROLL inválido: %s", value)
+		}
+	}
+	if value := os.Getenv("ALIEN_CAM_PREROLL_MB"); value != "" {
+		if mb, err := strconv.Atoi(value); err == nil && mb > 0 {
+			r.maxBytes = mb * 1024 * 1024
+		} else {
+			log.Printf("⚠️  ALIEN_CAM_PREROLL_MB inválido: %s", value)
+		}
+	}
+
+	go r.run()
+	return r
+}
+
+// source devuelve el video codificado si lo hay o, si no, los JPEG
+func (r *ClipRecorder) source() *FrameBroker {
+	if broker := r.video(); broker != nil {
+		return broker
+	}
+	return r.frames
+}
+
+func (r *ClipRecorder) run() {
+	for {
+		r.consume(r.source())
+	}
+}
+
+// consume llena el buffer con los frames del broker hasta que cambia la
+// fuente; los clips pendientes se cierran con lo que tengan
+func (r *ClipRecorder) consume(broker *FrameBroker) {
+	sub := broker.Subscribe(clipBuffer)
+	defer sub.Close()
+	defer r.reset()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame := <-sub.C:
+			r.add(frame)
+		case <-ticker.C:
+			if r.source() != broker {
+				return
+			}
+			r.expire()
+		}
+	}
+}
+
+// add guarda el frame en el buffer y lo entrega a los clips pendientes
+func (r *ClipRecorder) add(frame *Frame) {
+	r.mutex.Lock()
+	if len(r.buffer) > 0 && r.buffer[0].Codec != frame.Codec {
+		r.buffer = nil
+		r.bytes = 0
+	}
+	r.buffer = append(r.buffer, frame)
+	r.bytes += len(frame.Data)
+
+	// Recortar por tiempo y por memoria, dejando al menos el último frame
+	trim := 0
+	for trim < len(r.buffer)-1 {
+		oldest := r.buffer[trim]
+		if frame.Timestamp.Sub(oldest.Timestamp) <= r.preRoll && r.bytes <= r.maxBytes {
+			break
+		}
+		r.bytes -= len(oldest.Data)
+		r.buffer[trim] = nil
+		trim++
+	}
+	r.buffer = r.buffer[trim:]
+
+	clips := make([]*pendingClip, 0, len(r.pending))
+	for clip := range r.pending {
+		clips = append(clips, clip)
+	}
+	r.mutex.Unlock()
+
+	for _, clip := range clips {
+		if frame.Timestamp.After(clip.until) {
+			r.finish(clip)
+			continue
+		}
+		if err := clip.write(frame); err != nil {
+			log.Printf("❌ Error grabando clip %s: %v", filepath.Base(clip.path), err)
+			r.finish(clip)
+		}
+	}
+}
+
+// expire cierra los clips cuyo final ya pasó aunque no lleguen frames
+func (r *ClipRecorder) expire() {
+	now := time.Now()
+	r.mutex.Lock()
+	var done []*pendingClip
+	for clip := range r.pending {
+		if now.After(clip.until) {
+			done = append(done, clip)
+		}
+	}
+	r.mutex.Unlock()
+
+	for _, clip := range done {
+		r.finish(clip)
+	}
+}
+
+// reset vacía el buffer y cierra los clips pendientes
+func (r *ClipRecorder) reset() {
+	r.mutex.Lock()
+	r.buffer = nil
+	r.bytes = 0
+	var done []*pendingClip
+	for clip := range r.pending {
+		done = append(done, clip)
+	}
+	r.mutex.Unlock()
+
+	for _, clip := range done {
+		r.finish(clip)
+	}
+}
+
+// Save pide un clip con pre segundos previos y post posteriores. El
+// archivo se termina de escribir en segundo plano cuando pasa post.
+func (r *ClipRecorder) Save(event string, pre, post time.Duration) (string, error) {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create clips dir: %w", err)
+	}
+
+	now := time.Now()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.buffer) == 0 {
+		return "", fmt.Errorf("no video available")
+	}
+	codec := r.buffer[len(r.buffer)-1].Codec
+	var ext string
+	switch codec {
+	case codecH264:
+		ext = ".ts"
+	case codecJPEG:
+		ext = ".mjpeg"
+	default:
+		return "", fmt.Errorf("unsupported clip codec: %s", codec)
+	}
+
+	clip := &pendingClip{
+		codec:  codec,
+		camera: r.camera(),
+		event:  event,
+		until:  now.Add(post),
+	}
+	first := len(r.buffer)
+	for i, frame := range r.buffer {
+		if now.Sub(frame.Timestamp) <= pre {
+			first = i
+			break
+		}
+	}
+	// Con H.264 se retrocede hasta el keyframe anterior para no perder el GOP
+	for i := first; i >= 0 && i < len(r.buffer); i-- {
+		if r.buffer[i].Keyframe {
+			first = i
+			break
+		}
+	}
+	clip.pre = append(clip.pre, r.buffer[first:]...)
+	file, err := createRecordingFile(filepath.Join(r.dir, "clip_"+now.Format(recordingTimeLayout)), ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to create clip: %w", err)
+	}
+	clip.file = file
+	clip.path = file.Name()
+	r.pending[clip] = struct{}{}
+	start := now
+	if len(clip.pre) > 0 {
+		start = clip.pre[0].Timestamp
+	}
+	r.events.Publish(eventRecordingStarted, recordingEventData(clip.path, clip.camera, event, start, time.Time{}))
+
+	log.Printf("🎬 Clip solicitado: %s (%v antes, %v después)", filepath.Base(clip.path), pre, post)
+	return filepath.Base(clip.path), nil
+}
+
+// isRecording indica si path es un clip que aún recibe frames
+func (r *ClipRecorder) isRecording(path string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for clip := range r.pending {
+		if clip.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// write abre el clip con su parte previa la primera vez y añade el frame
+func (c *pendingClip) write(frame *Frame) error {
+	if c.writer == nil {
+		c.writer = newClipWriter(c.file, c.codec)
+		writeRecordingMeta(c.path, c.camera, c.event, c.codec, time.Time{}, time.Time{})
+		for _, pre := range c.pre {
+			if err := c.writeFrame(pre); err != nil {
+				return err
+			}
+		}
+		c.pre = nil
+	}
+	if frame.Codec != c.codec {
+		return nil
+	}
+	return c.writeFrame(frame)
+}
+
+// writeFrame escribe el frame y registra el intervalo que cubre el clip
+func (c *pendingClip) writeFrame(frame *Frame) error {
+	if c.first.IsZero() {
+		c.first = frame.Timestamp
+	}
+	c.last = frame.Timestamp
+	return c.writer.writeFrame(frame)
+}
+
+// finish da de baja el clip y cierra su archivo
+func (r *ClipRecorder) finish(clip *pendingClip) {
+	r.mutex.Lock()
+	if _, ok := r.pending[clip]; !ok {
+		r.mutex.Unlock()
+		return
+	}
+	delete(r.pending, clip)
+	r.mutex.Unlock()
+
+	// Sin frames posteriores el clip solo tiene la parte previa
+	if clip.writer == nil && len(clip.pre) > 0 {
+		last := clip.pre[len(clip.pre)-1]
+		clip.pre = clip.pre[:len(clip.pre)-1]
+		if err := clip.write(last); err != nil {
+			log.Printf("❌ Error grabando clip %s: %v", filepath.Base(clip.path), err)
+		}
+	}
+	if clip.writer == nil {
+		// No llegó ningún frame: se libera el nombre reservado
+		clip.file.Close()
+		os.Remove(clip.path)
+		return
+	}
+	if err := clip.writer.Close(); err != nil {
+		log.Printf("⚠️  Error cerrando clip %s: %v", filepath.Base(clip.path), err)
+		return
+	}
+	writeRecordingMeta(clip.path, clip.camera, clip.event, clip.codec, clip.first, clip.last)
+	r.events.Publish(eventRecordingFinished, recordingEventData(clip.path, clip.camera, clip.event, clip.first, clip.last))
+	log.Printf("💾 Clip guardado: %s", clip.path)
+}
+
+// newClipWriter escribe en el archivo reservado del clip: MPEG-TS para H.264 y MJPEG
+// (JPEG concatenados) para el bucle de captura
+func newClipWriter(file *os.File, codec string) clipWriter {
+	switch codec {
+	case codecH264:
+		return newTSClipWriter(file)
+	default:
+		return &mjpegClipWriter{file: file, writer: bufio.NewWriter(file)}
+	}
+}
+
+// tsClipWriter escribe H.264 en MPEG-TS empezando en el primer keyframe
+type tsClipWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	muxer  *tsMuxer
+	start  time.Time
+}
+
+func newTSClipWriter(file *os.File) *tsClipWriter {
+	w := &tsClipWriter{file: file, writer: bufio.NewWriter(file)}
+	w.muxer = newTSMuxer(w.writer)
+	return w
+}
+
+func (w *tsClipWriter) writeFrame(frame *Frame) error {
+	if w.start.IsZero() {
+		if !frame.Keyframe {
+			return nil
+		}
+		w.start = frame.Timestamp
+		if err := w.muxer.writeTables(); err != nil {
+			return err
+		}
+	}
+	return w.muxer.writeVideo(frame.Timestamp.Sub(w.start), frame.Keyframe, frame.Data)
+}
+
+func (w *tsClipWriter) Close() error {
+	return closeBuffered(w.writer, w.file)
+}
+
+// mjpegClipWriter concatena los JPEG (reproducible con ffplay o VLC)
+type mjpegClipWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func (w *mjpegClipWriter) writeFrame(frame *Frame) error {
+	_, err := w.writer.Write(frame.Data)
+	return err
+}
+
+func (w *mjpegClipWriter) Close() error {
+	return closeBuffered(w.writer, w.file)
+}
+
+// closeBuffered vuelca el buffer y cierra el archivo
+func closeBuffered(writer *bufio.Writer, file io.Closer) error {
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// handleClip guarda un clip con los segundos anteriores y posteriores
+func (r *ClipRecorder) handleClip(c *gin.Context) {
+	var req ClipRequest
+	if c.Request.ContentLength != 0 {
+		if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil && err != io.EOF {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": fmt.Sprintf("JSON inválido: %v", err)})
+			return
+		}
+	}
+
+	pre, post := r.preRoll, clipDefaultPostRoll
+	if req.Pre != nil {
+		pre = time.Duration(*req.Pre * float64(time.Second))
+	}
+	if req.Post != nil {
+		post = time.Duration(*req.Post * float64(time.Second))
+	}
+	if pre < 0 || pre > r.preRoll || post < 0 || post > clipMaxPostRoll {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "error",
+			"message": fmt.Sprintf("pre debe estar entre 0 y %v y post entre 0 y %v", r.preRoll, clipMaxPostRoll),
+		})
+		return
+	}
+
+	name, err := r.Save(recordingEventClip, pre, post)
+	if err != nil {
+		log.Printf("❌ No se puede guardar el clip: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "error",
+			"message": fmt.Sprintf("Error al guardar clip: %v", err),
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "recording",
+		"clip":    name,
+		"pre":     pre.Seconds(),
+		"post":    post.Seconds(),
+		"readyAt": time.Now().Add(post),
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var mask uint64
+		for _, v := range values {
+			mask |= 1 << uint(v)
+		}
+		return mask
+	}
+	tests := []struct {
+		field   string
+		low     int
+		high    int
+		want    uint64
+		wantErr bool
+	}{
+		{field: "*", low: 0, high: 6, want: bits(0, 1, 2, 3, 4, 5, 6)},
+		{field: "5", low: 0, high: 59, want: bits(5)},
+		{field: "1,3,5", low: 0, high: 59, want: bits(1, 3, 5)},
+		{field: "8-11", low: 0, high: 23, want: bits(8, 9, 10, 11)},
+		{field: "*/15", low: 0, high: 59, want: bits(0, 15, 30, 45)},
+		{field: "10-20/5", low: 0, high: 59, want: bits(10, 15, 20)},
+		{field: "50/5", low: 0, high: 59, want: bits(50, 55)},
+		{field: "1-3,*/10", low: 0, high: 30, want: bits(0, 1, 2, 3, 10, 20, 30)},
+		{field: "60", low: 0, high: 59, wantErr: true},
+		{field: "0", low: 1, high: 31, wantErr: true},
+		{field: "5-1", low: 0, high: 59, wantErr: true},
+		{field: "*/0", low: 0, high: 59, wantErr: true},
+		{field: "a", low: 0, high: 59, wantErr: true},
+		{field: "1-", low: 0, high: 59, wantErr: true},
+		{field: "", low: 0, high: 59, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseCronField(tt.field, tt.low, tt.high)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCronField(%q) error = %v, wantErr %v", tt.field, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCronField(%q) = %b, want %b", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "* * * * * *", "61 * * * *", "* 24 * * *", "* * 32 * *", "* * * 13 *", "* * * * 8"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// 2024-01-01 es lunes
+	base := time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 8-20 * * 1-5", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 6 * * *", time.Date(2024, 1, 2, 6, 30, 0, 0, time.UTC)},
+		// El domingo vale como 0 y como 7
+		{"0 12 * * 0", time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, 1, 7, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 3 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Con día del mes y de la semana restringidos basta con uno
+		{"0 9 15 * 3", time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC)},
+		{"0 9 2 * 5", time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)},
+		// El 30 de febrero no existe: no hay siguiente ejecución
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := schedule.next(base); !got.Equal(tt.want) {
+			t.Errorf("next(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+		if !tt.want.IsZero() && !schedule.matches(tt.want) {
+			t.Errorf("%q does not match its own next time %v", tt.expr, tt.want)
+		}
+	}
+}
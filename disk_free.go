@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package main
+
+import "syscall"
+
+// diskFree devuelve los bytes libres para el usuario en el sistema de
+// archivos de dir. Solo se compila donde syscall.Statfs tiene estos campos
+// (la etiqueta unix incluiría openbsd, netbsd y solaris, que no los tienen).
+func diskFree(dir string) (int64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, false
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), true
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package main
+
+// diskFree no sabe consultar el espacio libre en esta plataforma
+func diskFree(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tipos de evento que publican los subsistemas
+const (
+	eventCameraStarted     = "camera.started"
+	eventCameraStopped     = "camera.stopped"
+	eventCameraFailed      = "camera.failed"
+	eventCameraOffline     = "camera.offline"
+	eventCaptureFailed     = "capture.failed"
+	eventCaptureRecovered  = "capture.recovered"
+	eventPeerConnected     = "peer.connected"
+	eventPeerDisconnected  = "peer.disconnected"
+	eventPeerState         = "peer.state"
+	eventMotionStart       = "motion.start"
+	eventMotionEnd         = "motion.end"
+	eventRecordingStarted  = "recording.started"
+	eventRecordingFinished = "recording.finished"
+	eventSnapshotTaken     = "snapshot.taken"
+	eventTimelapseFinished = "timelapse.finished"
+	eventRTMPConnected     = "rtmp.connected"
+	eventRTMPFailed        = "rtmp.failed"
+)
+
+const (
+	// eventDefaultHistory es cuántos eventos se guardan para /api/events
+	eventDefaultHistory = 500
+	eventDefaultLimit   = 100
+)
+
+// Event es un cambio de estado con un número creciente y datos propios del tipo
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// EventBus reparte los eventos a los suscriptores y guarda un historial
+// acotado. Como FrameBroker, Publish nunca bloquea: un suscriptor lento
+// pierde eventos en lugar de frenar al que publica.
+type EventBus struct {
+	mutex       sync.Mutex
+	seq         uint64
+	history     []Event
+	limit       int
+	subscribers map[*EventSubscriber]struct{}
+}
+
+// EventSubscriber recibe por C los eventos de los tipos elegidos
+type EventSubscriber struct {
+	C       chan Event
+	bus     *EventBus
+	types   []string
+	dropped uint64
+	once    sync.Once
+}
+
+// NewEventBus crea el bus; ALIEN_CAM_EVENT_HISTORY cambia el tamaño del historial
+func NewEventBus() *EventBus {
+	limit := eventDefaultHistory
+	if value := os.Getenv("ALIEN_CAM_EVENT_HISTORY"); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			limit = n
+		} else {
+			log.Printf("⚠️  ALIEN_CAM_EVENT_HISTORY inválido: %s", value)
+		}
+	}
+	return &EventBus{
+		limit:       limit,
+		subscribers: make(map[*EventSubscriber]struct{}),
+	}
+}
+
+// Publish numera el evento, lo guarda y lo entrega. Un bus nil no hace
+// nada para que los subsistemas funcionen sin él.
+func (b *EventBus) Publish(kind string, data interface{}) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	event := Event{ID: b.seq, Type: kind, Time: time.Now(), Data: data}
+	b.history = append(b.history, event)
+	if len(b.history) > b.limit {
+		b.history = b.history[len(b.history)-b.limit:]
+	}
+
+	for sub := range b.subscribers {
+		if !matchEventType(sub.types, kind) {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// Subscribe registra un suscriptor; sin tipos recibe todos los eventos
+func (b *EventBus) Subscribe(buffer int, types ...string) *EventSubscriber {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &EventSubscriber{
+		C:     make(chan Event, buffer),
+		bus:   b,
+		types: types,
+	}
+	b.mutex.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mutex.Unlock()
+	return sub
+}
+
+// Close da de baja al suscriptor
+func (s *EventSubscriber) Close() {
+	s.once.Do(func() {
+		s.bus.mutex.Lock()
+		delete(s.bus.subscribers, s)
+		s.bus.mutex.Unlock()
+	})
+}
+
+// Dropped devuelve cuántos eventos se perdieron por lentitud
+func (s *EventSubscriber) Dropped() uint64 {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	return s.dropped
+}
+
+// History devuelve en orden los eventos posteriores a since y el número
+// desde el que seguir. Sin since da los últimos limit; con since da los
+// primeros limit para que quien consulta periódicamente no se salte ninguno.
+func (b *EventBus) History(since uint64, types []string, limit int) ([]Event, uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	events := make([]Event, 0)
+	for _, event := range b.history {
+		if event.ID > since && matchEventType(types, event.Type) {
+			events = append(events, event)
+		}
+	}
+	if limit > 0 && len(events) > limit {
+		if since == 0 {
+			return events[len(events)-limit:], b.seq
+		}
+		events = events[:limit]
+		return events, events[limit-1].ID
+	}
+	return events, b.seq
+}
+
+// matchEventType acepta el tipo exacto o una categoría: "motion" incluye
+// "motion.start" y "motion.end". Sin filtros acepta todo.
+func matchEventType(types []string, kind string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if kind == t || strings.HasPrefix(kind, t+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEventTypes lee una lista separada por comas como "motion,camera.started"
+func parseEventTypes(value string) []string {
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// recordingEventData describe una grabación igual que la biblioteca
+func recordingEventData(path, camera, event string, start, end time.Time) gin.H {
+	kind, name := filepath.Base(filepath.Dir(path)), filepath.Base(path)
+	data := gin.H{
+		"id":     kind + "/" + name,
+		"kind":   kind,
+		"name":   name,
+		"camera": camera,
+		"event":  event,
+		"start":  start,
+		"url":    "/api/recordings/" + kind + "/" + name + "/file",
+	}
+	if !end.IsZero() {
+		data["end"] = end
+		data["duration"] = end.Sub(start).Seconds()
+	}
+	return data
+}
+
+// handleEvents devuelve el historial: ?type=motion,camera&since=<id>&limit=100
+func (b *EventBus) handleEvents(c *gin.Context) {
+	var since uint64
+	if value := c.Query("since"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since: " + value})
+			return
+		}
+		since = n
+	}
+	limit := eventDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + value})
+			return
+		}
+		limit = n
+	}
+
+	events, last := b.History(since, parseEventTypes(c.Query("type")), limit)
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"count":  len(events),
+		"lastId": last,
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchEventType(t *testing.T) {
+	tests := []struct {
+		types []string
+		kind  string
+		want  bool
+	}{
+		{nil, "motion.start", true},
+		{[]string{}, "camera.started", true},
+		{[]string{"motion"}, "motion.start", true},
+		{[]string{"motion"}, "motion.end", true},
+		{[]string{"motion.start"}, "motion.start", true},
+		{[]string{"motion.start"}, "motion.end", false},
+		{[]string{"camera", "motion"}, "camera.failed", true},
+		{[]string{"camera", "motion"}, "peer.connected", false},
+		// Una categoría no acepta tipos que solo compartan el prefijo
+		{[]string{"motion"}, "motionless.start", false},
+		{[]string{"rec"}, "recording.started", false},
+		{[]string{"motion.start.extra"}, "motion.start", false},
+	}
+	for _, tt := range tests {
+		if got := matchEventType(tt.types, tt.kind); got != tt.want {
+			t.Errorf("matchEventType(%v, %q) = %v, want %v", tt.types, tt.kind, got, tt.want)
+		}
+	}
+}
+
+func TestParseEventTypes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{"motion", []string{"motion"}},
+		{" motion , camera.started ,,", []string{"motion", "camera.started"}},
+	}
+	for _, tt := range tests {
+		got := parseEventTypes(tt.value)
+		if len(got) != len(tt.want) {
+			t.Errorf("parseEventTypes(%q) = %q, want %q", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseEventTypes(%q) = %q, want %q", tt.value, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestEventBusHistory(t *testing.T) {
+	bus := &EventBus{limit: 5, subscribers: make(map[*EventSubscriber]struct{})}
+	for _, kind := range []string{eventCameraStarted, eventMotionStart, eventMotionEnd, eventMotionStart, eventMotionEnd, eventCameraStopped, eventMotionStart} {
+		bus.Publish(kind, nil)
+	}
+
+	// Solo quedan los 5 últimos (ids 3 a 7)
+	events, last := bus.History(0, nil, 0)
+	if len(events) != 5 || events[0].ID != 3 || last != 7 {
+		t.Fatalf("History(0) = %d events from %d, last %d", len(events), events[0].ID, last)
+	}
+
+	// Sin since da los más recientes
+	events, last = bus.History(0, []string{"motion"}, 2)
+	if len(events) != 2 || events[0].ID != 5 || events[1].ID != 7 || last != 7 {
+		t.Errorf("History(0, motion, 2) = %+v, last %d", events, last)
+	}
+
+	// Con since da los primeros y el id desde el que seguir
+	events, last = bus.History(3, nil, 2)
+	if len(events) != 2 || events[0].ID != 4 || events[1].ID != 5 || last != 5 {
+		t.Errorf("History(3, nil, 2) = %+v, last %d", events, last)
+	}
+	events, last = bus.History(last, nil, 2)
+	if len(events) != 2 || events[0].ID != 6 || last != 7 {
+		t.Errorf("History(5, nil, 2) = %+v, last %d", events, last)
+	}
+	events, last = bus.History(7, nil, 2)
+	if len(events) != 0 || last != 7 {
+		t.Errorf("History(7) = %+v, last %d", events, last)
+	}
+}
+
+func TestEventBusSubscribe(t *testing.T) {
+	bus := NewEventBus()
+	motion := bus.Subscribe(1, "motion")
+	all := bus.Subscribe(10)
+	defer all.Close()
+
+	bus.Publish(eventCameraStarted, nil)
+	bus.Publish(eventMotionStart, nil)
+	bus.Publish(eventMotionEnd, nil)
+
+	if event := <-motion.C; event.Type != eventMotionStart {
+		t.Errorf("filtered subscriber got %s", event.Type)
+	}
+	// El buffer de 1 se llenó y motion.end se descartó sin bloquear
+	if dropped := motion.Dropped(); dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+	if n := len(all.C); n != 3 {
+		t.Errorf("unfiltered subscriber has %d events, want 3", n)
+	}
+
+	motion.Close()
+	motion.Close()
+	bus.Publish(eventMotionStart, nil)
+	if n := len(motion.C); n != 0 {
+		t.Errorf("closed subscriber received %d events", n)
+	}
+
+	var nilBus *EventBus
+	nilBus.Publish(eventMotionStart, nil)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// isFFmpegAvailable indica si ffmpeg está instalado (pkg install ffmpeg en Termux)
+func isFFmpegAvailable() bool {
+	return isCommandAvailable("ffmpeg")
+}
+
+// decodeH264Snapshot convierte un access unit H.264 con keyframe (Annex-B,
+// incluyendo SPS/PPS) en una imagen JPEG usando ffmpeg
+func decodeH264Snapshot(annexB []byte) ([]byte, error) {
+	if !isFFmpegAvailable() {
+		return nil, fmt.Errorf("ffmpeg not available")
+	}
+
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "h264", "-i", "pipe:0",
+		"-frames:v", "1",
+		"-f", "image2", "-c:v", "mjpeg", "-q:v", "4",
+		"pipe:1",
+	)
+	cmd.Stdin = bytes.NewReader(annexB)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("h264 decode failed: %v: %s", err, stderr.String())
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("h264 decode produced no image")
+	}
+	return output, nil
+}
+
+// extractVideoThumbnail decodifica el primer frame de un archivo de video
+// (MPEG-TS o H.264 Annex-B) y lo devuelve como JPEG del ancho dado
+func extractVideoThumbnail(path string, width int) ([]byte, error) {
+	if !isFFmpegAvailable() {
+		return nil, fmt.Errorf("ffmpeg not available")
+	}
+
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-i", path,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", width),
+		"-f", "image2", "-c:v", "mjpeg", "-q:v", "5",
+		"pipe:1",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("thumbnail extraction failed: %v: %s", err, stderr.String())
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("thumbnail extraction produced no image")
+	}
+	return output, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Códecs de los frames que circulan por los brokers
+const (
+	codecJPEG = "image/jpeg"
+	codecH264 = "video/H264"
+	codecVP8  = "video/VP8"
+)
+
+// Frame es una imagen JPEG del bucle de captura o una unidad de video
+// codificado (H.264 en Annex-B o un frame VP8)
+type Frame struct {
+	Data      []byte
+	Codec     string
+	Keyframe  bool
+	Seq       uint64
+	Timestamp time.Time
+	Duration  time.Duration
+}
+
+// FrameBroker reparte cada frame publicado a todos los suscriptores.
+// Publish nunca bloquea: si un suscriptor va lento se descartan sus frames
+// más viejos para que la cámara no se detenga. Con video codificado, tras
+// un descarte el suscriptor espera al siguiente keyframe para no corromper
+// la decodificación.
+type FrameBroker struct {
+	mutex       sync.RWMutex
+	subscribers map[*FrameSubscriber]struct{}
+	latest      *Frame
+	seq         uint64
+}
+
+// FrameSubscriber recibe frames del broker por su canal C
+type FrameSubscriber struct {
+	C            chan *Frame
+	broker       *FrameBroker
+	dropped      uint64
+	waitKeyframe bool
+	once         sync.Once
+}
+
+func NewFrameBroker() *FrameBroker {
+	return &FrameBroker{
+		subscribers: make(map[*FrameSubscriber]struct{}),
+	}
+}
+
+// Publish asigna número de secuencia al frame y lo entrega a los suscriptores
+func (b *FrameBroker) Publish(frame *Frame) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.seq++
+	frame.Seq = b.seq
+	if frame.Timestamp.IsZero() {
+		frame.Timestamp = time.Now()
+	}
+	b.latest = frame
+
+	for sub := range b.subscribers {
+		sub.deliver(frame)
+	}
+}
+
+// deliver intenta encolar el frame descartando el más viejo si el buffer está lleno.
+// Los frames que no son keyframe se descartan ellos mismos cuando no caben.
+func (s *FrameSubscriber) deliver(frame *Frame) {
+	if s.waitKeyframe && !frame.Keyframe {
+		s.dropped++
+		return
+	}
+	s.waitKeyframe = false
+
+	if !frame.Keyframe {
+		select {
+		case s.C <- frame:
+		default:
+			s.dropped++
+			s.waitKeyframe = true
+		}
+		return
+	}
+
+	for {
+		select {
+		case s.C <- frame:
+			return
+		default:
+		}
+
+		select {
+		case <-s.C:
+			s.dropped++
+		default:
+		}
+	}
+}
+
+// Subscribe registra un suscriptor con un buffer de tamaño dado (mínimo 1)
+func (b *FrameBroker) Subscribe(buffer int) *FrameSubscriber {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &FrameSubscriber{
+		C:            make(chan *Frame, buffer),
+		broker:       b,
+		waitKeyframe: true,
+	}
+
+	b.mutex.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mutex.Unlock()
+
+	return sub
+}
+
+// Close da de baja al suscriptor del broker
+func (s *FrameSubscriber) Close() {
+	s.once.Do(func() {
+		s.broker.mutex.Lock()
+		delete(s.broker.subscribers, s)
+		s.broker.mutex.Unlock()
+	})
+}
+
+// Dropped devuelve cuántos frames se descartaron por lentitud
+func (s *FrameSubscriber) Dropped() uint64 {
+	s.broker.mutex.RLock()
+	defer s.broker.mutex.RUnlock()
+	return s.dropped
+}
+
+// Latest devuelve el último frame publicado o nil si no hay ninguno
+func (b *FrameBroker) Latest() *Frame {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.latest
+}
+
+// Reset olvida el último frame, por ejemplo al detener la cámara
+func (b *FrameBroker) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.latest = nil
+}
+
+// SubscriberCount devuelve el número de suscriptores activos
+func (b *FrameBroker) SubscriberCount() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.subscribers)
+}
+
+// WaitFrame devuelve un frame más nuevo que maxAge, esperando al siguiente
+// si hace falta, o nil si no llega ninguno antes del timeout
+func (b *FrameBroker) WaitFrame(maxAge, timeout time.Duration) *Frame {
+	if latest := b.Latest(); latest != nil && time.Since(latest.Timestamp) <= maxAge {
+		return latest
+	}
+
+	sub := b.Subscribe(1)
+	defer sub.Close()
+
+	select {
+	case frame := <-sub.C:
+		return frame
+	case <-time.After(timeout):
+		return b.Latest()
+	}
+}
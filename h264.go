@@ -0,0 +1,125 @@
+package main
+
+// Tipos de unidad NAL de H.264 que nos interesan
+const (
+	h264NALSlice = 1
+	h264NALIDR   = 5
+	h264NALSEI   = 6
+	h264NALSPS   = 7
+	h264NALPPS   = 8
+	h264NALAUD   = 9
+)
+
+// splitAnnexB separa un buffer Annex-B en unidades NAL sin los códigos de inicio
+func splitAnnexB(data []byte) [][]byte {
+	var nalus [][]byte
+	start := -1
+	i := 0
+	for i+2 < len(data) {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				end := i
+				// El código de inicio de 4 bytes deja un cero extra al final
+				if end > start && data[end-1] == 0 {
+					end--
+				}
+				if end > start {
+					nalus = append(nalus, data[start:end])
+				}
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	} else if start < 0 && len(data) > 0 {
+		// Sin códigos de inicio: tratar todo el buffer como una sola NAL
+		nalus = append(nalus, data)
+	}
+	return nalus
+}
+
+// joinAnnexB une unidades NAL anteponiendo códigos de inicio de 4 bytes
+func joinAnnexB(nalus [][]byte) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += 4 + len(nalu)
+	}
+	out := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		out = append(out, 0, 0, 0, 1)
+		out = append(out, nalu...)
+	}
+	return out
+}
+
+// h264NALType devuelve el tipo de una unidad NAL
+func h264NALType(nalu []byte) byte {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return nalu[0] & 0x1F
+}
+
+// isH264Keyframe indica si el access unit contiene un slice IDR
+func isH264Keyframe(annexB []byte) bool {
+	for _, nalu := range splitAnnexB(annexB) {
+		if h264NALType(nalu) == h264NALIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// isVP8Keyframe lee el bit de tipo de frame de la cabecera VP8
+func isVP8Keyframe(frame []byte) bool {
+	return len(frame) > 0 && frame[0]&0x01 == 0
+}
+
+// h264ParameterSets guarda los últimos SPS/PPS vistos en un stream para
+// poder anteponerlos a los keyframes que llegan sin ellos
+type h264ParameterSets struct {
+	sps []byte
+	pps []byte
+}
+
+// update registra los SPS/PPS presentes en el access unit
+func (p *h264ParameterSets) update(nalus [][]byte) {
+	for _, nalu := range nalus {
+		switch h264NALType(nalu) {
+		case h264NALSPS:
+			p.sps = append([]byte(nil), nalu...)
+		case h264NALPPS:
+			p.pps = append([]byte(nil), nalu...)
+		}
+	}
+}
+
+// ready indica si ya se conocen SPS y PPS
+func (p *h264ParameterSets) ready() bool {
+	return p.sps != nil && p.pps != nil
+}
+
+// complete devuelve el access unit en Annex-B con SPS/PPS delante de los
+// slices IDR cuando faltan
+func (p *h264ParameterSets) complete(annexB []byte) []byte {
+	nalus := splitAnnexB(annexB)
+	p.update(nalus)
+
+	hasIDR, hasSPS := false, false
+	for _, nalu := range nalus {
+		switch h264NALType(nalu) {
+		case h264NALIDR:
+			hasIDR = true
+		case h264NALSPS:
+			hasSPS = true
+		}
+	}
+	if !hasIDR || hasSPS || !p.ready() {
+		return joinAnnexB(nalus)
+	}
+	return joinAnnexB(append([][]byte{p.sps, p.pps}, nalus...))
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// hlsTargetDuration es la duración buscada de cada segmento
+	hlsTargetDuration = 2 * time.Second
+	// hlsRoundingMargin es lo que puede pasarse un segmento del
+	// EXT-X-TARGETDURATION: el EXTINF redondeado no debe superarlo, así que
+	// se queda algo por debajo del medio segundo
+	hlsRoundingMargin = 450 * time.Millisecond
+	// hlsWindow es cuántos segmentos aparecen en la playlist
+	hlsWindow = 6
+	// hlsKeep es cuántos segmentos se guardan en memoria (algo más que la
+	// ventana para los clientes que van atrasados)
+	hlsKeep = hlsWindow + 3
+	// hlsIdleTimeout detiene el empaquetado si nadie pide la playlist
+	hlsIdleTimeout = 30 * time.Second
+	// hlsStartTimeout es lo que espera la primera petición al primer segmento
+	hlsStartTimeout = 10 * time.Second
+	// hlsBuffer es cuántos frames puede acumular el empaquetador
+	hlsBuffer = 60
+	// hlsMaxGap es el corte de video a partir del cual se empieza una nueva
+	// línea temporal marcada como discontinuidad
+	hlsMaxGap = 3 * time.Second
+	// hlsPartTarget es la duración máxima de las partes LL-HLS
+	hlsPartTarget = 300 * time.Millisecond
+	// hlsPartSegments es de cuántos segmentos terminados se listan las partes
+	hlsPartSegments = 2
+	// hlsVersion es la versión de protocolo de LL-HLS (EXT-X-PART,
+	// EXT-X-PRELOAD-HINT y EXT-X-SERVER-CONTROL). No se ofrece CAN-SKIP-UNTIL:
+	// la ventana es de solo 6 segmentos y con el mínimo de 6 veces la
+	// duración objetivo una actualización delta no ahorraría nada.
+	hlsVersion = 9
+)
+
+// HLSPackager convierte el video H.264 de la cámara en segmentos MPEG-TS
+// con una playlist deslizante. Cada segmento se publica además en partes
+// para LL-HLS. Solo trabaja mientras hay clientes pidiendo la playlist.
+type HLSPackager struct {
+	source          func() *FrameBroker
+	requestKeyframe func()
+
+	mutex           sync.Mutex
+	segments        []*hlsSegment
+	current         *hlsSegment
+	muxer           *tsMuxer
+	streamStart     time.Time
+	lastFrame       time.Time
+	nextSeq         uint64
+	discontinuities uint64
+	discontinuity   bool
+	targetDuration  time.Duration
+	lastRequest     time.Time
+	updated         chan struct{}
+}
+
+// hlsSegment es un segmento MPEG-TS en memoria; sus partes son trozos
+// consecutivos del mismo buffer
+type hlsSegment struct {
+	seq           uint64
+	start         time.Duration
+	duration      time.Duration
+	discontinuity bool
+	buf           bytes.Buffer
+	data          []byte
+
+	parts           []*hlsPart
+	partStart       time.Duration
+	partOffset      int
+	partIndependent bool
+}
+
+// hlsPart es una parte LL-HLS ya terminada
+type hlsPart struct {
+	duration    time.Duration
+	independent bool
+	data        []byte
+}
+
+func NewHLSPackager(source func() *FrameBroker) *HLSPackager {
+	p := &HLSPackager{
+		source:         source,
+		targetDuration: hlsPlaylistTarget(hlsTargetDuration, encoderKeyframeInterval),
+		updated:        make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// hlsPlaylistTarget es el EXT-X-TARGETDURATION: el mayor entre la duración
+// de segmento y el intervalo de keyframes, en segundos enteros hacia arriba.
+// No puede cambiar durante la emisión, así que se fija una vez.
+func hlsPlaylistTarget(segment, keyframeInterval time.Duration) time.Duration {
+	return time.Duration(math.Ceil(max(segment, keyframeInterval).Seconds())) * time.Second
+}
+
+// run espera a que haya video y clientes y empaqueta hasta que falte alguno
+func (p *HLSPackager) run() {
+	for {
+		broker := p.source()
+		if broker == nil || !p.active() {
+			time.Sleep(time.Second)
+			continue
+		}
+		p.consume(broker)
+	}
+}
+
+// active indica si algún cliente pidió la playlist recientemente
+func (p *HLSPackager) active() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return time.Since(p.lastRequest) < hlsIdleTimeout
+}
+
+// touch registra la petición de un cliente
+func (p *HLSPackager) touch() {
+	p.mutex.Lock()
+	p.lastRequest = time.Now()
+	p.mutex.Unlock()
+}
+
+// consume empaqueta los frames del broker hasta que cambia la fuente de
+// video o deja de haber clientes
+func (p *HLSPackager) consume(broker *FrameBroker) {
+	sub := broker.Subscribe(hlsBuffer)
+	defer sub.Close()
+	defer p.reset()
+
+	log.Println("📼 Empaquetado HLS iniciado")
+	if p.requestKeyframe != nil {
+		p.requestKeyframe()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case frame := <-sub.C:
+			if frame.Codec != codecH264 {
+				if !warned {
+					log.Printf("⚠️  HLS solo admite H.264, el video es %s", frame.Codec)
+					warned = true
+				}
+				continue
+			}
+			if err := p.writeFrame(frame); err != nil {
+				log.Printf("❌ Error empaquetando HLS: %v", err)
+				return
+			}
+		case <-ticker.C:
+			if p.source() != broker || !p.active() {
+				log.Printf("⏹️  Empaquetado HLS detenido (%d frames descartados)", sub.Dropped())
+				return
+			}
+		}
+	}
+}
+
+// writeFrame añade un access unit al segmento en curso, cerrándolo y
+// empezando otro en cada keyframe una vez alcanzada la duración objetivo
+func (p *HLSPackager) writeFrame(frame *Frame) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var interval time.Duration
+	if !p.streamStart.IsZero() {
+		interval = frame.Timestamp.Sub(p.lastFrame)
+	}
+
+	// El publicador se cortó o reconectó: cerrar lo que hubiera
+	if interval > hlsMaxGap {
+		if p.current != nil {
+			p.finishSegmentLocked(p.lastFrame.Sub(p.streamStart))
+		}
+		p.streamStart = time.Time{}
+		p.discontinuity = true
+	}
+
+	if p.streamStart.IsZero() {
+		if !frame.Keyframe {
+			return nil
+		}
+		p.streamStart = frame.Timestamp
+		p.muxer = newTSMuxer(nil)
+	}
+	pts := frame.Timestamp.Sub(p.streamStart)
+	p.lastFrame = frame.Timestamp
+
+	if p.current != nil && pts-p.current.start >= hlsTargetDuration {
+		if frame.Keyframe {
+			p.finishSegmentLocked(pts)
+		} else {
+			// Sin keyframe a tiempo se corta igualmente: el segmento no puede
+			// pasar de la duración anunciada en la playlist
+			if pts+interval-p.current.start >= p.targetDuration+hlsRoundingMargin {
+				p.finishSegmentLocked(pts)
+			}
+			// Las fuentes WebRTC solo mandan keyframes cuando se les piden
+			if p.requestKeyframe != nil {
+				go p.requestKeyframe()
+			}
+		}
+	}
+
+	// Las partes se cortan en cualquier frame, antes de que el siguiente
+	// haga pasar la parte de hlsPartTarget
+	if p.current != nil && pts+interval-p.current.partStart > hlsPartTarget {
+		p.finishPartLocked(pts)
+		p.current.partIndependent = frame.Keyframe
+	}
+
+	if p.current == nil {
+		p.current = &hlsSegment{
+			seq:             p.nextSeq,
+			start:           pts,
+			discontinuity:   p.discontinuity,
+			partStart:       pts,
+			partIndependent: frame.Keyframe,
+		}
+		p.nextSeq++
+		p.discontinuity = false
+		p.muxer.w = &p.current.buf
+		if err := p.muxer.writeTables(); err != nil {
+			return err
+		}
+	}
+
+	return p.muxer.writeVideo(pts, frame.Keyframe, frame.Data)
+}
+
+// finishPartLocked publica lo escrito desde la última parte como una parte
+// nueva del segmento en curso. Debe llamarse con el mutex tomado.
+func (p *HLSPackager) finishPartLocked(end time.Duration) {
+	segment := p.current
+	data := segment.buf.Bytes()[segment.partOffset:]
+	if len(data) == 0 {
+		return
+	}
+
+	segment.parts = append(segment.parts, &hlsPart{
+		duration:    end - segment.partStart,
+		independent: segment.partIndependent,
+		data:        data,
+	})
+	segment.partStart = end
+	segment.partOffset = segment.buf.Len()
+	p.notifyLocked()
+}
+
+// finishSegmentLocked publica el segmento en curso. Debe llamarse con el
+// mutex tomado.
+func (p *HLSPackager) finishSegmentLocked(end time.Duration) {
+	p.finishPartLocked(end)
+	segment := p.current
+	p.current = nil
+
+	segment.duration = end - segment.start
+	segment.data = segment.buf.Bytes()
+
+	p.segments = append(p.segments, segment)
+	if len(p.segments) > hlsKeep {
+		if p.segments[0].discontinuity {
+			p.discontinuities++
+		}
+		p.segments = p.segments[1:]
+	}
+	p.notifyLocked()
+}
+
+// notifyLocked despierta a las peticiones bloqueadas esperando la playlist
+func (p *HLSPackager) notifyLocked() {
+	close(p.updated)
+	p.updated = make(chan struct{})
+}
+
+// reset descarta los segmentos; la numeración continúa para que los
+// clientes detecten la discontinuidad
+func (p *HLSPackager) reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, segment := range p.segments {
+		if segment.discontinuity {
+			p.discontinuities++
+		}
+	}
+	p.segments = nil
+	p.current = nil
+	p.muxer = nil
+	p.streamStart = time.Time{}
+	p.discontinuity = p.nextSeq > 0
+}
+
+// playlist genera la media playlist con los últimos hlsWindow segmentos,
+// las partes de los más recientes y la del segmento en curso
+func (p *HLSPackager) playlist() (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	segments := p.segments
+	if len(segments) == 0 {
+		return "", false
+	}
+	discontinuities := p.discontinuities
+	if len(segments) > hlsWindow {
+		for _, segment := range segments[:len(segments)-hlsWindow] {
+			if segment.discontinuity {
+				discontinuities++
+			}
+		}
+		segments = segments[len(segments)-hlsWindow:]
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", hlsVersion)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(p.targetDuration/time.Second))
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", (3 * hlsPartTarget).Seconds())
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", hlsPartTarget.Seconds())
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].seq)
+	if discontinuities > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuities)
+	}
+	for i, segment := range segments {
+		if segment.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if i >= len(segments)-hlsPartSegments {
+			writeHLSParts(&b, segment)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n", segment.duration.Seconds())
+		fmt.Fprintf(&b, "segment%d.ts\n", segment.seq)
+	}
+
+	if current := p.current; current != nil {
+		if current.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		writeHLSParts(&b, current)
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"segment%d.%d.ts\"\n", current.seq, len(current.parts))
+	}
+	return b.String(), true
+}
+
+// waitSegments espera a que haya al menos un segmento terminado
+func (p *HLSPackager) waitSegments(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		p.mutex.Lock()
+		ready := len(p.segments) > 0
+		updated := p.updated
+		p.mutex.Unlock()
+
+		if ready {
+			return true
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return false
+		}
+		select {
+		case <-updated:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// segment busca un segmento guardado por su número de secuencia
+func (p *HLSPackager) segment(seq uint64) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, segment := range p.segments {
+		if segment.seq == seq {
+			return segment.data
+		}
+	}
+	return nil
+}
+
+// handleHLS sirve la playlist (index.m3u8), los segmentos (segmentN.ts) y
+// las partes LL-HLS (segmentN.M.ts)
+func (p *HLSPackager) handleHLS(c *gin.Context) {
+	c.Header("Access-Control-Allow-Origin", "*")
+	file := c.Param("file")
+
+	if file == "index.m3u8" {
+		p.touch()
+		if p.source() == nil {
+			c.String(http.StatusServiceUnavailable, "no encoded video available (start the camera; JPEG sources need ffmpeg)")
+			return
+		}
+
+		// Recarga bloqueante de LL-HLS (_HLS_msn y _HLS_part)
+		msn, part, blocking, err := parseHLSBlockingReload(c)
+		if err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if blocking {
+			if !p.validBlockingReload(msn) {
+				c.String(http.StatusBadRequest, "_HLS_msn is too far in the future")
+				return
+			}
+			// Si el segmento o parte no llega a tiempo la especificación pide
+			// un error en lugar de la playlist antigua
+			if !p.waitPlaylist(msn, part, hlsBlockTimeout) {
+				c.String(http.StatusServiceUnavailable, "requested segment or part not available in time")
+				return
+			}
+		}
+
+		playlist, ok := "", p.waitSegments(hlsStartTimeout)
+		if ok {
+			playlist, ok = p.playlist()
+		}
+		if !ok {
+			c.Header("Retry-After", "2")
+			c.String(http.StatusServiceUnavailable, "no H.264 video received yet")
+			return
+		}
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+		return
+	}
+
+	if strings.HasPrefix(file, "segment") && strings.HasSuffix(file, ".ts") {
+		name := strings.TrimSuffix(strings.TrimPrefix(file, "segment"), ".ts")
+		if seqText, partText, isPart := strings.Cut(name, "."); isPart {
+			seq, seqErr := strconv.ParseUint(seqText, 10, 64)
+			index, partErr := strconv.Atoi(partText)
+			if seqErr == nil && partErr == nil && index >= 0 {
+				// La parte anunciada en el preload hint se sirve en cuanto exista
+				if data := p.part(seq, index, hlsBlockTimeout); data != nil {
+					p.touch()
+					c.Header("Cache-Control", "max-age=60")
+					c.Data(http.StatusOK, "video/mp2t", data)
+					return
+				}
+			}
+		} else if seq, err := strconv.ParseUint(name, 10, 64); err == nil {
+			if data := p.segment(seq); data != nil {
+				p.touch()
+				c.Header("Cache-Control", "max-age=60")
+				c.Data(http.StatusOK, "video/mp2t", data)
+				return
+			}
+		}
+	}
+
+	c.String(http.StatusNotFound, "not found")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHLSPlaylistTarget(t *testing.T) {
+	tests := []struct {
+		segment, keyframes time.Duration
+		want               time.Duration
+	}{
+		{2 * time.Second, 2 * time.Second, 2 * time.Second},
+		{2 * time.Second, time.Second, 2 * time.Second},
+		{2 * time.Second, 2500 * time.Millisecond, 3 * time.Second},
+		{1500 * time.Millisecond, 0, 2 * time.Second},
+		{2 * time.Second, 4 * time.Second, 4 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := hlsPlaylistTarget(tt.segment, tt.keyframes); got != tt.want {
+			t.Errorf("hlsPlaylistTarget(%v, %v) = %v, want %v", tt.segment, tt.keyframes, got, tt.want)
+		}
+	}
+}
+
+var (
+	hlsTargetPattern = regexp.MustCompile(`#EXT-X-TARGETDURATION:(\d+)`)
+	hlsExtinfPattern = regexp.MustCompile(`#EXTINF:([\d.]+),`)
+)
+
+func TestHLSSegmentDurations(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyframes time.Duration
+	}{
+		{"aligned keyframes", 2 * time.Second},
+		{"late keyframes", 3500 * time.Millisecond},
+		{"single keyframe", time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &HLSPackager{
+				targetDuration: hlsPlaylistTarget(hlsTargetDuration, encoderKeyframeInterval),
+				updated:        make(chan struct{}),
+			}
+			start := time.Now()
+			frameInterval := time.Second / 30
+			for elapsed := time.Duration(0); elapsed < 20*time.Second; elapsed += frameInterval {
+				frame := &Frame{
+					Data:      []byte{0, 0, 0, 1, 0x65, 0x88},
+					Codec:     codecH264,
+					Keyframe:  elapsed%tt.keyframes < frameInterval,
+					Timestamp: start.Add(elapsed),
+				}
+				if err := p.writeFrame(frame); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			playlist, ok := p.playlist()
+			if !ok {
+				t.Fatal("no playlist")
+			}
+			if !strings.Contains(playlist, "#EXT-X-VERSION:9\n") || strings.Contains(playlist, "CAN-SKIP-UNTIL") {
+				t.Errorf("playlist header does not match LL-HLS features:\n%s", playlist[:200])
+			}
+			match := hlsTargetPattern.FindStringSubmatch(playlist)
+			if match == nil || match[1] != "2" {
+				t.Fatalf("target duration changed: %v", match)
+			}
+			target, _ := strconv.Atoi(match[1])
+			extinfs := hlsExtinfPattern.FindAllStringSubmatch(playlist, -1)
+			if len(extinfs) == 0 {
+				t.Fatal("playlist has no segments")
+			}
+			for _, extinf := range extinfs {
+				duration, _ := strconv.ParseFloat(extinf[1], 64)
+				if int(math.Round(duration)) > target {
+					t.Errorf("segment of %ss exceeds target duration %d", extinf[1], target)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hlsBlockTimeout limita cuánto se retiene una recarga bloqueante o la
+// petición de una parte que todavía no existe
+const hlsBlockTimeout = 3 * hlsTargetDuration
+
+// writeHLSParts escribe las líneas EXT-X-PART de un segmento
+func writeHLSParts(b *strings.Builder, segment *hlsSegment) {
+	for i, part := range segment.parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"segment%d.%d.ts\"", part.duration.Seconds(), segment.seq, i)
+		if part.independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// parseHLSBlockingReload lee _HLS_msn y _HLS_part; part vale -1 si no se
+// pide una parte concreta
+func parseHLSBlockingReload(c *gin.Context) (uint64, int, bool, error) {
+	msnText := c.Query("_HLS_msn")
+	partText := c.Query("_HLS_part")
+	if msnText == "" {
+		if partText != "" {
+			return 0, 0, false, fmt.Errorf("_HLS_part requires _HLS_msn")
+		}
+		return 0, 0, false, nil
+	}
+
+	msn, err := strconv.ParseUint(msnText, 10, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid _HLS_msn: %s", msnText)
+	}
+	part := -1
+	if partText != "" {
+		part, err = strconv.Atoi(partText)
+		if err != nil || part < 0 {
+			return 0, 0, false, fmt.Errorf("invalid _HLS_part: %s", partText)
+		}
+	}
+	return msn, part, true, nil
+}
+
+// validBlockingReload rechaza esperas de más de dos segmentos por delante
+// del que se está generando
+func (p *HLSPackager) validBlockingReload(msn uint64) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return msn <= p.nextSeq+1
+}
+
+// waitPlaylist espera a que la playlist contenga el segmento msn terminado
+// o, si part >= 0, esa parte del segmento
+func (p *HLSPackager) waitPlaylist(msn uint64, part int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		p.mutex.Lock()
+		ready := p.hasPartLocked(msn, part)
+		updated := p.updated
+		p.mutex.Unlock()
+
+		if ready {
+			return true
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return false
+		}
+		select {
+		case <-updated:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// hasPartLocked indica si ya existe el segmento msn (o la parte pedida).
+// Debe llamarse con el mutex tomado.
+func (p *HLSPackager) hasPartLocked(msn uint64, part int) bool {
+	if n := len(p.segments); n > 0 && p.segments[n-1].seq >= msn {
+		return true
+	}
+	if p.current == nil || part < 0 {
+		return false
+	}
+	return p.current.seq > msn || (p.current.seq == msn && len(p.current.parts) > part)
+}
+
+// part devuelve una parte LL-HLS, esperando por ella si es la siguiente
+// que se va a generar
+func (p *HLSPackager) part(seq uint64, index int, timeout time.Duration) []byte {
+	deadline := time.Now().Add(timeout)
+	for {
+		p.mutex.Lock()
+		data, pending := p.findPartLocked(seq, index)
+		updated := p.updated
+		p.mutex.Unlock()
+
+		if data != nil {
+			return data
+		}
+		wait := time.Until(deadline)
+		if !pending || wait <= 0 {
+			return nil
+		}
+		select {
+		case <-updated:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// findPartLocked busca una parte guardada; pending indica que aún no existe
+// pero es la próxima del segmento en curso o la primera del siguiente.
+// Debe llamarse con el mutex tomado.
+func (p *HLSPackager) findPartLocked(seq uint64, index int) (data []byte, pending bool) {
+	segments := p.segments
+	if p.current != nil {
+		segments = append(segments[:len(segments):len(segments)], p.current)
+	}
+	for _, segment := range segments {
+		if segment.seq == seq && index < len(segment.parts) {
+			return segment.parts[index].data, false
+		}
+	}
+
+	current := p.current
+	if current == nil {
+		return nil, false
+	}
+	pending = (seq == current.seq && index == len(current.parts)) || (seq == current.seq+1 && index == 0)
+	return nil, pending
+}
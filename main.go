@@ -1,5 +1,3 @@
-//go:build android
-
 package main
 
 import (
@@ -10,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -17,19 +16,45 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 )
 
 type CameraServer struct {
-	port    string
-	running bool
-	webrtc  *WebRTCManager
+	port     string
+	running  bool
+	webrtc   *WebRTCManager
+	source   CameraSource
+	frames   *FrameBroker
+	encoder  *VideoEncoder
+	hls      *HLSPackager
+	rtsp     *RTSPServer
+	rtmp     *RTMPPublisher
+	recorder *Recorder
+	segments *SegmentRecorder
+	clips    *ClipRecorder
+	library  *RecordingStore
+	lapses   *TimelapseManager
+	archive  *SnapshotArchive
+	motion   *MotionDetector
+	events   *EventBus
+	webhooks *WebhookManager
+
+	mutex       sync.Mutex
+	captureStop chan struct{}
+	captureDone chan struct{}
 }
 
 type WebRTCManager struct {
 	peerConnections map[string]*webrtc.PeerConnection
+	sessions        map[string]*peerSession
 	mutex           sync.RWMutex
 	upgrader        websocket.Upgrader
+	ingest          *WebRTCIngestSource
+	video           func() *FrameBroker
+	relay           *trackRelay
+	recorder        *Recorder
+	events          *EventBus
 }
 
 type SignalingMessage struct {
@@ -39,14 +64,17 @@ type SignalingMessage struct {
 }
 
 func NewWebRTCManager() *WebRTCManager {
-	return &WebRTCManager{
+	w := &WebRTCManager{
 		peerConnections: make(map[string]*webrtc.PeerConnection),
+		sessions:        make(map[string]*peerSession),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Permitir todos los orígenes para desarrollo
 			},
 		},
 	}
+	go w.expireSessions()
+	return w
 }
 
 func (w *WebRTCManager) createPeerConnection(peerID string) (*webrtc.PeerConnection, error) {
@@ -70,15 +98,56 @@ func (w *WebRTCManager) createPeerConnection(peerID string) (*webrtc.PeerConnect
 	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		log.Printf("📥 Track recibido: %s", track.Codec().MimeType)
 
-		// Aquí se procesaría el video de la cámara
-		// Por ahora, solo logueamos que recibimos el track
-		rtpBuf := make([]byte, 1500)
+		requestKeyframe := func() {
+			err := peerConnection.WriteRTCP([]rtcp.Packet{
+				&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())},
+			})
+			if err != nil {
+				log.Printf("⚠️  Error enviando PLI a peer %s: %v", peerID, err)
+			}
+		}
+
+		// El video se reenvía a los visores suscritos al publicador
+		var relay *trackRelay
+		if track.Kind() == webrtc.RTPCodecTypeVideo {
+			relay = w.setPublisher(peerID, track, requestKeyframe)
+			defer w.clearPublisher(relay)
+		}
+
+		// y se entrega a la ingesta si la fuente activa es WebRTC
+		var ingest *trackIngest
+		if w.ingest != nil && track.Kind() == webrtc.RTPCodecTypeVideo {
+			var err error
+			ingest, err = w.ingest.newTrackIngest(peerID, track.Codec(), requestKeyframe)
+			if err != nil {
+				log.Printf("⚠️  Track no ingerible: %v", err)
+			} else {
+				defer ingest.close()
+			}
+		}
+
+		// Audio y video se graban en disco mientras la grabación esté activa
+		var recording *trackRecorder
+		if w.recorder != nil {
+			recording = w.recorder.addTrack(peerID, track.Codec(), requestKeyframe)
+			defer recording.close()
+		}
+
 		for {
-			_, _, readErr := track.Read(rtpBuf)
+			packet, _, readErr := track.ReadRTP()
 			if readErr != nil {
 				log.Printf("❌ Error leyendo track: %v", readErr)
 				return
 			}
+			if relay != nil {
+				relay.forward(packet)
+			}
+			if ingest != nil {
+				ingest.push(packet)
+			}
+			if recording != nil {
+				recording.write(packet)
+			}
 		}
 	})
 
@@ -92,13 +161,25 @@ func (w *WebRTCManager) createPeerConnection(peerID string) (*webrtc.PeerConnect
 
 	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		log.Printf("🔄 Estado de conexión peer %s: %s", peerID, state.String())
+		w.updateSessionState(peerID, state)
+		w.events.Publish(eventPeerState, gin.H{"peerId": peerID, "state": state.String()})
+		if state == webrtc.PeerConnectionStateConnected {
+			w.events.Publish(eventPeerConnected, gin.H{"peerId": peerID})
+		}
 		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
 			w.removePeerConnection(peerID)
 		}
 	})
 
+	now := time.Now()
 	w.mutex.Lock()
 	w.peerConnections[peerID] = peerConnection
+	w.sessions[peerID] = &peerSession{
+		ID:             peerID,
+		CreatedAt:      now,
+		State:          webrtc.PeerConnectionStateNew.String(),
+		StateChangedAt: now,
+	}
 	w.mutex.Unlock()
 
 	return peerConnection, nil
@@ -108,9 +189,15 @@ func (w *WebRTCManager) removePeerConnection(peerID string) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
+	if w.relay != nil {
+		w.relay.removeSubscriber(peerID)
+	}
+
 	if pc, exists := w.peerConnections[peerID]; exists {
 		pc.Close()
 		delete(w.peerConnections, peerID)
+		delete(w.sessions, peerID)
+		w.events.Publish(eventPeerDisconnected, gin.H{"peerId": peerID})
 		log.Printf("🗑️  Peer connection %s eliminada", peerID)
 	}
 }
@@ -142,6 +229,8 @@ func (w *WebRTCManager) handleWebSocket(c *gin.Context) {
 			w.handleAnswer(conn, msg)
 		case "ice-candidate":
 			w.handleICECandidate(conn, msg)
+		case "watch":
+			w.handleWatch(conn, msg)
 		}
 	}
 }
@@ -197,8 +286,27 @@ func (w *WebRTCManager) handleOffer(conn *websocket.Conn, msg SignalingMessage)
 }
 
 func (w *WebRTCManager) handleAnswer(conn *websocket.Conn, msg SignalingMessage) {
-	// Implementar si el servidor inicia la conexión
 	log.Printf("📋 Answer recibido para peer %s", msg.PeerID)
+
+	w.mutex.RLock()
+	pc, exists := w.peerConnections[msg.PeerID]
+	w.mutex.RUnlock()
+
+	if !exists {
+		log.Printf("❌ Peer connection %s no encontrada", msg.PeerID)
+		return
+	}
+
+	answerData, _ := json.Marshal(msg.Payload)
+	answer := webrtc.SessionDescription{}
+	if err := json.Unmarshal(answerData, &answer); err != nil {
+		log.Printf("❌ Error parseando answer: %v", err)
+		return
+	}
+
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		log.Printf("❌ Error estableciendo remote description: %v", err)
+	}
 }
 
 func (w *WebRTCManager) handleICECandidate(conn *websocket.Conn, msg SignalingMessage) {
@@ -223,38 +331,99 @@ func (w *WebRTCManager) handleICECandidate(conn *websocket.Conn, msg SignalingMe
 	}
 }
 
-func (w *WebRTCManager) startVideoCapture(peerID string) {
-	// Aquí implementaríamos la captura de video real
-	// Por ahora, simulamos con un track de video
-	log.Printf("🎥 Iniciando captura de video para peer %s", peerID)
-
-	// En una implementación real:
-	// 1. Usar FFmpeg para capturar video de la cámara Android
-	// 2. Codificar a formato WebRTC (VP8/H264)
-	// 3. Enviar paquetes RTP al peer connection
-}
-
-// Función auxiliar para evitar errores
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
 type StreamInfo struct {
-	Port       string    `json:"port"`
-	Timestamp  time.Time `json:"timestamp"`
-	Camera     string    `json:"camera"`
-	Resolution string    `json:"resolution"`
+	Port       string          `json:"port"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Camera     string          `json:"camera"`
+	CameraID   string          `json:"cameraId"`
+	Resolution string          `json:"resolution"`
+	Running    bool            `json:"running"`
+	Viewers    int             `json:"viewers"`
+	RTSP       int             `json:"rtspClients"`
+	RTMP       RTMPStatus      `json:"rtmp"`
+	Recording  RecordingStatus `json:"recording"`
+	Continuous SegmentStatus   `json:"continuous"`
+	Motion     bool            `json:"motion"`
 }
 
 func main() {
+	// Seleccionar backend de cámara (por defecto Termux)
+	source, err := newCameraSource(os.Getenv("ALIEN_CAM_SOURCE"))
+	if err != nil {
+		log.Fatalf("❌ Error creando fuente de cámara: %v", err)
+	}
+
 	server := &CameraServer{
 		port:   "8080",
 		webrtc: NewWebRTCManager(),
+		source: source,
+		frames: NewFrameBroker(),
+		events: NewEventBus(),
+	}
+
+	server.webrtc.video = server.videoFrames
+	server.hls = NewHLSPackager(server.videoFrames)
+	server.rtsp = NewRTSPServer(server.videoFrames)
+	server.rtmp = NewRTMPPublisher(server.videoFrames)
+	server.recorder = NewRecorder(filepath.Join(dataDir(), "recordings"))
+	server.webrtc.recorder = server.recorder
+	server.segments = NewSegmentRecorder(filepath.Join(dataDir(), "segments"), server.videoFrames)
+	server.segments.camera = server.cameraName
+	server.clips = NewClipRecorder(filepath.Join(dataDir(), "clips"), server.videoFrames, server.frames)
+	server.clips.camera = server.cameraName
+	server.library = NewRecordingStore(dataDir())
+	server.lapses = NewTimelapseManager(filepath.Join(dataDir(), "timelapse"), server.snapshot)
+	server.archive = NewSnapshotArchive(filepath.Join(dataDir(), "snapshots"), server.snapshot)
+	server.motion = NewMotionDetector(server.frames, NewMotionZoneStore(filepath.Join(dataDir(), "motion_zones.json")),
+		server.cameraID, server.handleMotionEvent)
+	server.webrtc.events = server.events
+	server.rtmp.events = server.events
+	server.recorder.events = server.events
+	server.segments.events = server.events
+	server.clips.events = server.events
+	server.lapses.events = server.events
+	server.archive.events = server.events
+	server.webhooks = NewWebhookManager(filepath.Join(dataDir(), "webhooks.json"), server.events, server.snapshot)
+	server.library.inProgress = []func(string) bool{
+		server.recorder.isRecording,
+		server.segments.isRecording,
+		server.clips.isRecording,
+	}
+
+	// Con la fuente WebRTC el video publicado por un navegador es la cámara
+	if ingest, ok := source.(*WebRTCIngestSource); ok {
+		server.webrtc.ingest = ingest
+		server.hls.requestKeyframe = ingest.forceKeyframe
+		server.rtsp.requestKeyframe = ingest.forceKeyframe
+		server.rtmp.requestKeyframe = ingest.forceKeyframe
+		server.segments.requestKeyframe = ingest.forceKeyframe
 	}
 
+	// Grabación continua por segmentos con retención por antigüedad y cuota
+	if continuous := os.Getenv("ALIEN_CAM_CONTINUOUS"); continuous == "1" || continuous == "true" {
+		if err := server.segments.Start(); err != nil {
+			log.Printf("❌ Grabación continua no disponible: %v", err)
+		}
+	}
+
+	// Envío RTMP opcional a un servidor local (nginx-rtmp, MediaMTX)
+	if rtmpURL := os.Getenv("ALIEN_CAM_RTMP_URL"); rtmpURL != "" {
+		if err := server.rtmp.Configure(rtmpURL, true); err != nil {
+			log.Printf("❌ Configuración RTMP inválida: %v", err)
+		}
+	}
+
+	// Servidor RTSP junto al router Gin (NVRs, Home Assistant, Frigate, VLC)
+	rtspPort := os.Getenv("ALIEN_CAM_RTSP_PORT")
+	if rtspPort == "" {
+		rtspPort = "8554"
+	}
+	go func() {
+		if err := server.rtsp.ListenAndServe(":" + rtspPort); err != nil {
+			log.Printf("❌ Servidor RTSP no disponible: %v", err)
+		}
+	}()
+
 	// Crear router Gin para WebRTC
 	router := gin.Default()
 
@@ -264,13 +433,85 @@ func main() {
 	// Endpoints originales
 	router.GET("/", server.handleHomeGin)
 	router.GET("/stream", server.handleStreamGin)
+	router.GET("/mjpeg", server.handleMJPEGGin)
 	router.GET("/api/status", server.handleStatusGin)
 	router.POST("/api/start-camera", server.handleStartCameraGin)
 	router.POST("/api/stop-camera", server.handleStopCameraGin)
+	router.POST("/api/start-recording", server.handleStartRecording)
+	router.POST("/api/stop-recording", server.handleStopRecording)
+	router.POST("/api/clip", server.handleClip)
+	router.GET("/api/continuous", server.handleContinuousStatus)
+	router.POST("/api/continuous", server.handleContinuousConfig)
+
+	// Biblioteca de grabaciones (tracks, grabación continua y clips)
+	router.GET("/api/recordings", server.handleListRecordings)
+	router.GET("/api/recordings/:kind/:name", server.handleGetRecording)
+	router.GET("/api/recordings/:kind/:name/file", server.handleRecordingFile)
+	router.GET("/api/recordings/:kind/:name/thumbnail", server.handleRecordingThumbnail)
+	router.DELETE("/api/recordings/:kind/:name", server.handleDeleteRecording)
+
+	// Time-lapse a partir de capturas periódicas
+	router.POST("/api/timelapse", server.handleStartTimelapse)
+	router.GET("/api/timelapse", server.handleListTimelapses)
+	router.GET("/api/timelapse/:id", server.handleGetTimelapse)
+	router.DELETE("/api/timelapse/:id", server.handleStopTimelapse)
+	router.GET("/api/timelapse/:id/video", server.handleTimelapseVideo)
+
+	// Archivo de fotos programadas
+	router.GET("/api/snapshots", server.handleSnapshotGallery)
+	router.POST("/api/snapshots", server.handleTakeSnapshot)
+	router.GET("/api/snapshots/latest", server.handleLatestSnapshot)
+	router.GET("/api/snapshots/file/:date/:name", server.handleSnapshotFile)
+	router.GET("/api/snapshots/schedule", server.handleSnapshotSchedule)
+	router.POST("/api/snapshots/schedule", server.handleSnapshotScheduleConfig)
+
+	// Historial del bus de eventos y feed en vivo (Server-Sent Events)
+	router.GET("/api/events", server.handleEvents)
+	router.GET("/api/events/stream", server.handleEventStream)
+
+	// Webhooks firmados para integraciones
+	router.GET("/api/webhooks", server.handleListWebhooks)
+	router.POST("/api/webhooks", server.handleCreateWebhook)
+	router.GET("/api/webhooks/deliveries", server.handleWebhookDeliveries)
+	router.GET("/api/webhooks/:id", server.handleGetWebhook)
+	router.PUT("/api/webhooks/:id", server.handleUpdateWebhook)
+	router.DELETE("/api/webhooks/:id", server.handleDeleteWebhook)
+	router.POST("/api/webhooks/:id/test", server.handleTestWebhook)
+	router.GET("/api/webhooks/:id/deliveries", server.handleWebhookDeliveries)
+
+	// Detección de movimiento sobre los frames capturados
+	router.GET("/api/motion", server.handleMotion)
+	router.POST("/api/motion", server.handleMotionSettings)
+	router.GET("/api/motion/zones", server.handleMotionZones)
+	router.PUT("/api/motion/zones", server.handleReplaceMotionZones)
+	router.POST("/api/motion/zones", server.handleAddMotionZone)
+	router.DELETE("/api/motion/zones/:id", server.handleDeleteMotionZone)
+	router.GET("/api/rtmp", server.handleRTMPStatus)
+	router.POST("/api/rtmp", server.handleRTMPConfig)
 
 	// Endpoints WebRTC
 	router.GET("/webrtc", server.handleWebRTC)
+	router.GET("/viewer", server.handleViewer)
 	router.GET("/ws", server.handleWebSocket)
+	router.GET("/ws/stream", server.handleFrameWebSocket)
+
+	// Endpoints WHIP (publicación estándar desde OBS, GStreamer...)
+	router.POST("/whip", server.handleWHIP)
+	router.OPTIONS("/whip", server.handleWHIPOptions)
+	router.PATCH("/whip/:id", server.handleWHIPPatch)
+	router.DELETE("/whip/:id", server.handleWHIPDelete)
+	router.OPTIONS("/whip/:id", server.handleWHIPOptions)
+
+	// Endpoints WHEP (reproducción estándar)
+	router.POST("/whep", server.handleWHEP)
+	router.OPTIONS("/whep", server.handleWHIPOptions)
+	router.PATCH("/whep/:id", server.handleWHEPPatch)
+	router.DELETE("/whep/:id", server.handleWHEPDelete)
+	router.OPTIONS("/whep/:id", server.handleWHIPOptions)
+	router.GET("/api/sessions", server.handleSessions)
+
+	// Salida HLS para reproductores sin WebRTC (smart TVs, Safari)
+	router.GET("/hls/:file", server.handleHLS)
 
 	// Endpoint mejorado
 	router.GET("/enhanced", server.handleEnhanced)
@@ -280,7 +521,13 @@ func main() {
 
 	fmt.Printf("🎥 Alien Cam Server con WebRTC iniciado\n")
 	fmt.Printf("📱 Streaming tradicional: http://localhost:%s\n", server.port)
+	fmt.Printf("📺 Stream MJPEG: http://localhost:%s/mjpeg\n", server.port)
 	fmt.Printf("🚀 WebRTC streaming: http://localhost:%s/webrtc\n", server.port)
+	fmt.Printf("👀 Visor WebRTC: http://localhost:%s/viewer\n", server.port)
+	fmt.Printf("📤 Publicación WHIP: http://localhost:%s/whip\n", server.port)
+	fmt.Printf("📥 Reproducción WHEP: http://localhost:%s/whep\n", server.port)
+	fmt.Printf("📼 Playlist HLS: http://localhost:%s/hls/index.m3u8\n", server.port)
+	fmt.Printf("📹 RTSP: rtsp://localhost:%s%s\n", rtspPort, rtspPath)
 	fmt.Printf("💻 Acceso remoto: http://%s:%s\n", ip, server.port)
 	fmt.Printf("🌐 Presiona Ctrl+C para detener\n\n")
 	fmt.Printf("📋 Si la IP %s no funciona, intenta:\n", ip)
@@ -448,6 +695,23 @@ func (cs *CameraServer) handleHome(w http.ResponseWriter, r *http.Request) {
             margin-bottom: 10px;
         }
         
+        .events {
+            list-style: none;
+            max-height: 220px;
+            overflow-y: auto;
+            font-size: 0.9em;
+        }
+        
+        .events li {
+            padding: 6px 0;
+            border-bottom: 1px solid rgba(255, 255, 255, 0.1);
+        }
+        
+        .events .time {
+            opacity: 0.6;
+            margin-right: 8px;
+        }
+        
         .loading {
             display: inline-block;
             width: 20px;
@@ -519,6 +783,13 @@ func (cs *CameraServer) handleHome(w http.ResponseWriter, r *http.Request) {
                 💡 Para acceder desde otros dispositivos en la misma red, usa la IP local seguida del puerto 8080
             </p>
         </div>
+        
+        <div class="info">
+            <h3>🔔 Eventos <span id="eventsState" style="font-size: 0.7em; opacity: 0.7;">(conectando...)</span></h3>
+            <ul class="events" id="eventList">
+                <li>Sin eventos todavía</li>
+            </ul>
+        </div>
     </div>
 
     <script>
@@ -546,7 +817,7 @@ func (cs *CameraServer) handleHome(w http.ResponseWriter, r *http.Request) {
                 stopBtn.disabled = false;
                 placeholder.style.display = 'none';
                 videoStream.style.display = 'block';
-                videoStream.src = '/stream?' + new Date().getTime();
+                videoStream.src = '/mjpeg';
             } else {
                 indicator.classList.remove('active');
                 startBtn.disabled = false;
@@ -578,10 +849,6 @@ func (cs *CameraServer) handleHome(w http.ResponseWriter, r *http.Request) {
                     if (response.ok) {
                         isStreaming = true;
                         updateStatus(true, 'Cámara activa y transmitiendo');
-                        
-                        // Cargar la primera imagen inmediatamente
-                        const videoStream = document.getElementById('videoStream');
-                        videoStream.src = '/stream?' + new Date().getTime();
                     } else {
                         throw new Error('Error al iniciar la cámara');
                     }
@@ -594,9 +861,6 @@ func (cs *CameraServer) handleHome(w http.ResponseWriter, r *http.Request) {
                     if (response.ok) {
                         isStreaming = true;
                         updateStatus(true, 'Cámara demostración activa (instala Termux:API para acceso real)');
-                        
-                        const videoStream = document.getElementById('videoStream');
-                        videoStream.src = '/stream?' + new Date().getTime();
                     } else {
                         throw new Error('Error al iniciar la cámara');
                     }
@@ -634,36 +898,104 @@ func (cs *CameraServer) handleHome(w http.ResponseWriter, r *http.Request) {
             stopBtn.innerHTML = originalText;
         }
         
-        // Actualizar stream periódicamente con manejo de errores - más rápido para video fluido
-        setInterval(() => {
-            if (isStreaming) {
-                const videoStream = document.getElementById('videoStream');
-                const newSrc = '/stream?' + new Date().getTime();
-                
-                // Precargar siguiente imagen para transición suave
-                const nextImg = new Image();
-                nextImg.onload = function() {
-                    videoStream.src = newSrc;
-                };
-                nextImg.onerror = function() {
-                    console.error('Error al cargar imagen del stream');
-                    // Intentar recargar después de un pequeño delay
-                    setTimeout(() => {
-                        if (isStreaming) {
-                            videoStream.src = '/stream?' + new Date().getTime();
-                        }
-                    }, 500);
-                };
-                nextImg.src = newSrc;
-                
-                // Fallback directo si la precarga falla
-                setTimeout(() => {
-                    if (videoStream.src !== newSrc && isStreaming) {
-                        videoStream.src = newSrc;
-                    }
-                }, 100);
+        // Sincronizar con el estado real del servidor
+        function refreshStatus() {
+            fetch('/api/status')
+                .then(response => response.json())
+                .then(data => setStreaming(data.running, data.running ? 'Cámara activa y transmitiendo' : 'Cámara desactivada'))
+                .catch(() => {});
+        }
+        
+        function setStreaming(active, message) {
+            if (active !== isStreaming) {
+                isStreaming = active;
+                updateStatus(active, message);
+            }
+        }
+        
+        function describeEvent(type, data) {
+            data = data || {};
+            switch (type) {
+                case 'camera.started': return '🎥 Cámara iniciada';
+                case 'camera.stopped': return '⏹️ Cámara detenida';
+                case 'camera.failed': return '❌ No se pudo iniciar la cámara: ' + data.error;
+                case 'camera.offline': return '📴 Cámara sin imagen: ' + data.error;
+                case 'capture.failed': return '❌ Error de captura: ' + data.error;
+                case 'capture.recovered': return '✅ Captura recuperada';
+                case 'peer.connected': return '🔌 Peer conectado: ' + data.peerId;
+                case 'peer.disconnected': return '🔌 Peer desconectado: ' + data.peerId;
+                case 'peer.state': return '🔄 Peer ' + data.peerId + ': ' + data.state;
+                case 'motion.start': return '🚨 Movimiento detectado';
+                case 'motion.end': return '✅ Fin del movimiento';
+                case 'recording.started': return '⏺️ Grabación iniciada: ' + data.name;
+                case 'recording.finished': return '💾 Grabación guardada: ' + data.name;
+                case 'snapshot.taken': return '📸 Foto archivada: ' + data.name;
+                case 'timelapse.finished': return '🎞️ Time-lapse terminado: ' + data.name;
+                case 'rtmp.connected': return '📤 Envío RTMP conectado';
+                case 'rtmp.failed': return '⚠️ Envío RTMP interrumpido: ' + data.error;
+                default: return type;
+            }
+        }
+        
+        function addEvent(event) {
+            const list = document.getElementById('eventList');
+            if (list.dataset.empty !== 'false') {
+                list.innerHTML = '';
+                list.dataset.empty = 'false';
             }
-        }, 100); // Más frecuente para mejor fluidez
+            const item = document.createElement('li');
+            const time = document.createElement('span');
+            time.className = 'time';
+            time.textContent = new Date(event.time).toLocaleTimeString();
+            item.appendChild(time);
+            item.appendChild(document.createTextNode(describeEvent(event.type, event.data)));
+            list.insertBefore(item, list.firstChild);
+            while (list.children.length > 50) {
+                list.removeChild(list.lastChild);
+            }
+        }
+        
+        // Feed de eventos en vivo: el estado cambia al instante sin consultar
+        const eventTypes = ['camera.started', 'camera.stopped', 'camera.failed', 'camera.offline',
+            'capture.failed', 'capture.recovered', 'peer.connected', 'peer.disconnected', 'peer.state', 'motion.start',
+            'motion.end', 'recording.started', 'recording.finished', 'snapshot.taken',
+            'timelapse.finished', 'rtmp.connected', 'rtmp.failed'];
+        const events = new EventSource('/api/events/stream');
+        events.onopen = function() {
+            document.getElementById('eventsState').textContent = '(en vivo)';
+            refreshStatus();
+        };
+        events.onerror = function() {
+            document.getElementById('eventsState').textContent = '(reconectando...)';
+        };
+        eventTypes.forEach(type => {
+            events.addEventListener(type, function(e) {
+                const event = JSON.parse(e.data);
+                addEvent(event);
+                const data = event.data || {};
+                if (type === 'camera.started') {
+                    setStreaming(true, 'Cámara activa y transmitiendo');
+                } else if (type === 'camera.stopped') {
+                    setStreaming(false, 'Cámara desactivada');
+                } else if (type === 'capture.failed') {
+                    document.getElementById('detailedStatus').textContent = 'Error de captura: ' + data.error;
+                } else if (type === 'camera.offline') {
+                    document.getElementById('detailedStatus').textContent = 'Cámara sin imagen: ' + data.error;
+                } else if (type === 'capture.recovered') {
+                    document.getElementById('detailedStatus').textContent = 'Cámara activa y transmitiendo';
+                }
+            });
+        });
+        
+        // Reconectar el stream MJPEG si se corta
+        document.getElementById('videoStream').onerror = function() {
+            console.error('Error en el stream MJPEG');
+            setTimeout(() => {
+                if (isStreaming) {
+                    this.src = '/mjpeg?' + new Date().getTime();
+                }
+            }, 1000);
+        };
     </script>
 </body>
 </html>`
@@ -681,8 +1013,7 @@ func (cs *CameraServer) handleHome(w http.ResponseWriter, r *http.Request) {
 func (cs *CameraServer) handleStream(w http.ResponseWriter, r *http.Request) {
 	log.Println("🎥 Petición de streaming recibida")
 
-	// Intentar capturar imagen usando Termux API
-	imgData, err := cs.captureImage()
+	imgData, err := cs.snapshot()
 	if err != nil {
 		log.Printf("❌ Falló captura de imagen: %v", err)
 
@@ -700,17 +1031,17 @@ func (cs *CameraServer) handleStream(w http.ResponseWriter, r *http.Request) {
 		}
 
 		fmt.Fprintf(w, `<svg width="640" height="480" xmlns="http://www.w3.org/2000/svg">
-			<rect width="640" height="480" fill="%231a1a2e"/>
+			<rect width="640" height="480" fill="#1a1a2e"/>
 			<text x="320" y="200" font-family="Arial" font-size="28" fill="white" text-anchor="middle">
 				📱 %s
 			</text>
-			<text x="320" y="240" font-family="Arial" font-size="18" fill="%23ff6b6b" text-anchor="middle">
+			<text x="320" y="240" font-family="Arial" font-size="18" fill="#ff6b6b" text-anchor="middle">
 				Error: %s
 			</text>
-			<text x="320" y="280" font-family="Arial" font-size="14" fill="%23ccc" text-anchor="middle">
+			<text x="320" y="280" font-family="Arial" font-size="14" fill="#ccc" text-anchor="middle">
 				Revisa la consola para más detalles
 			</text>
-			<text x="320" y="310" font-family="Arial" font-size="12" fill="%23999" text-anchor="middle">
+			<text x="320" y="310" font-family="Arial" font-size="12" fill="#999" text-anchor="middle">
 				Ejecuta: termux-camera-info
 			</text>
 		</svg>`, errorMsg, err.Error())
@@ -728,11 +1059,20 @@ func (cs *CameraServer) handleStream(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cs *CameraServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	caps := cs.source.Capabilities()
 	info := StreamInfo{
 		Port:       cs.port,
 		Timestamp:  time.Now(),
-		Camera:     "Termux Camera",
-		Resolution: "640x480",
+		Camera:     caps.Name,
+		CameraID:   caps.ID,
+		Resolution: caps.Resolution,
+		Running:    cs.isRunning(),
+		Viewers:    cs.frames.SubscriberCount(),
+		RTSP:       cs.rtsp.ClientCount(),
+		RTMP:       cs.rtmp.Status(),
+		Recording:  cs.recorder.Status(),
+		Continuous: cs.segments.Status(),
+		Motion:     cs.motion.Active(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -742,10 +1082,24 @@ func (cs *CameraServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 func (cs *CameraServer) handleStartCamera(w http.ResponseWriter, r *http.Request) {
 	log.Println("🎥 Petición para iniciar cámara recibida")
 
-	// Probar captura de imagen para verificar disponibilidad
-	_, err := cs.captureImage()
+	if cs.isRunning() {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "started",
+			"message": "La cámara ya estaba iniciada",
+		})
+		return
+	}
+
+	// Abrir la fuente y probar captura de imagen para verificar disponibilidad
+	err := cs.source.Open()
+	var imgData []byte
+	if err == nil {
+		imgData, err = cs.captureImage()
+	}
 	if err != nil {
 		log.Printf("❌ No se puede iniciar la cámara: %v", err)
+		cs.events.Publish(eventCameraFailed, gin.H{"camera": cs.cameraName(), "error": err.Error()})
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{
@@ -756,8 +1110,10 @@ func (cs *CameraServer) handleStartCamera(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cs.running = true
+	cs.frames.Publish(&Frame{Data: imgData, Codec: codecJPEG, Keyframe: true, Timestamp: time.Now()})
+	cs.startCaptureLoop()
 	log.Println("✅ Cámara iniciada correctamente")
+	cs.events.Publish(eventCameraStarted, gin.H{"camera": cs.cameraName()})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -767,7 +1123,14 @@ func (cs *CameraServer) handleStartCamera(w http.ResponseWriter, r *http.Request
 }
 
 func (cs *CameraServer) handleStopCamera(w http.ResponseWriter, r *http.Request) {
-	cs.running = false
+	running := cs.isRunning()
+	cs.stopCaptureLoop()
+	if err := cs.source.Close(); err != nil {
+		log.Printf("⚠️  Error cerrando fuente de cámara: %v", err)
+	}
+	if running {
+		cs.events.Publish(eventCameraStopped, gin.H{"camera": cs.cameraName()})
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"status":  "stopped",
@@ -775,6 +1138,51 @@ func (cs *CameraServer) handleStopCamera(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// handleFrameWebSocket envía cada frame del bucle de captura como mensaje binario
+func (cs *CameraServer) handleFrameWebSocket(c *gin.Context) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true // Permitir todos los orígenes para desarrollo
+		},
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("❌ Error WebSocket upgrade: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := cs.frames.Subscribe(1)
+	defer sub.Close()
+
+	log.Printf("🔌 Cliente de frames WebSocket conectado")
+
+	// Detectar cierre del cliente leyendo en segundo plano
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			log.Printf("🔌 Cliente de frames WebSocket desconectado (%d frames descartados)", sub.Dropped())
+			return
+		case frame := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteMessage(websocket.BinaryMessage, frame.Data); err != nil {
+				log.Printf("❌ Error enviando frame por WebSocket: %v", err)
+				return
+			}
+		}
+	}
+}
+
 func (cs *CameraServer) handleWebRTC(c *gin.Context) {
 	// Servir la página WebRTC
 	c.File("webrtc.html")
@@ -784,10 +1192,215 @@ func (cs *CameraServer) handleWebSocket(c *gin.Context) {
 	cs.webrtc.handleWebSocket(c)
 }
 
+func (cs *CameraServer) handleWHIP(c *gin.Context) {
+	cs.webrtc.handleWHIP(c)
+}
+
+func (cs *CameraServer) handleWHIPOptions(c *gin.Context) {
+	cs.webrtc.handleWHIPOptions(c)
+}
+
+func (cs *CameraServer) handleWHIPPatch(c *gin.Context) {
+	cs.webrtc.handleWHIPPatch(c)
+}
+
+func (cs *CameraServer) handleWHIPDelete(c *gin.Context) {
+	cs.webrtc.handleWHIPDelete(c)
+}
+
+func (cs *CameraServer) handleWHEP(c *gin.Context) {
+	cs.webrtc.handleWHEP(c)
+}
+
+func (cs *CameraServer) handleWHEPPatch(c *gin.Context) {
+	cs.webrtc.handleWHEPPatch(c)
+}
+
+func (cs *CameraServer) handleWHEPDelete(c *gin.Context) {
+	cs.webrtc.handleWHEPDelete(c)
+}
+
+func (cs *CameraServer) handleSessions(c *gin.Context) {
+	cs.webrtc.handleSessions(c)
+}
+
+func (cs *CameraServer) handleRTMPStatus(c *gin.Context) {
+	cs.rtmp.handleRTMPStatus(c)
+}
+
+func (cs *CameraServer) handleRTMPConfig(c *gin.Context) {
+	cs.rtmp.handleRTMPConfig(c)
+}
+
+func (cs *CameraServer) handleContinuousStatus(c *gin.Context) {
+	cs.segments.handleContinuousStatus(c)
+}
+
+func (cs *CameraServer) handleContinuousConfig(c *gin.Context) {
+	cs.segments.handleContinuousConfig(c)
+}
+
+func (cs *CameraServer) handleStartRecording(c *gin.Context) {
+	cs.recorder.handleStartRecording(c)
+}
+
+func (cs *CameraServer) handleStopRecording(c *gin.Context) {
+	cs.recorder.handleStopRecording(c)
+}
+
+func (cs *CameraServer) handleClip(c *gin.Context) {
+	cs.clips.handleClip(c)
+}
+
+func (cs *CameraServer) handleListRecordings(c *gin.Context) {
+	cs.library.handleListRecordings(c)
+}
+
+func (cs *CameraServer) handleGetRecording(c *gin.Context) {
+	cs.library.handleGetRecording(c)
+}
+
+func (cs *CameraServer) handleRecordingFile(c *gin.Context) {
+	cs.library.handleRecordingFile(c)
+}
+
+func (cs *CameraServer) handleRecordingThumbnail(c *gin.Context) {
+	cs.library.handleRecordingThumbnail(c)
+}
+
+func (cs *CameraServer) handleDeleteRecording(c *gin.Context) {
+	cs.library.handleDeleteRecording(c)
+}
+
+func (cs *CameraServer) handleStartTimelapse(c *gin.Context) {
+	cs.lapses.handleStartTimelapse(c)
+}
+
+func (cs *CameraServer) handleListTimelapses(c *gin.Context) {
+	cs.lapses.handleListTimelapses(c)
+}
+
+func (cs *CameraServer) handleGetTimelapse(c *gin.Context) {
+	cs.lapses.handleGetTimelapse(c)
+}
+
+func (cs *CameraServer) handleStopTimelapse(c *gin.Context) {
+	cs.lapses.handleStopTimelapse(c)
+}
+
+func (cs *CameraServer) handleTimelapseVideo(c *gin.Context) {
+	cs.lapses.handleTimelapseVideo(c)
+}
+
+func (cs *CameraServer) handleSnapshotGallery(c *gin.Context) {
+	cs.archive.handleSnapshotGallery(c)
+}
+
+func (cs *CameraServer) handleTakeSnapshot(c *gin.Context) {
+	cs.archive.handleTakeSnapshot(c)
+}
+
+func (cs *CameraServer) handleLatestSnapshot(c *gin.Context) {
+	cs.archive.handleLatestSnapshot(c)
+}
+
+func (cs *CameraServer) handleSnapshotFile(c *gin.Context) {
+	cs.archive.handleSnapshotFile(c)
+}
+
+func (cs *CameraServer) handleSnapshotSchedule(c *gin.Context) {
+	cs.archive.handleSnapshotSchedule(c)
+}
+
+func (cs *CameraServer) handleSnapshotScheduleConfig(c *gin.Context) {
+	cs.archive.handleSnapshotScheduleConfig(c)
+}
+
+func (cs *CameraServer) handleEvents(c *gin.Context) {
+	cs.events.handleEvents(c)
+}
+
+func (cs *CameraServer) handleEventStream(c *gin.Context) {
+	cs.events.handleEventStream(c)
+}
+
+func (cs *CameraServer) handleListWebhooks(c *gin.Context) {
+	cs.webhooks.handleListWebhooks(c)
+}
+
+func (cs *CameraServer) handleCreateWebhook(c *gin.Context) {
+	cs.webhooks.handleCreateWebhook(c)
+}
+
+func (cs *CameraServer) handleGetWebhook(c *gin.Context) {
+	cs.webhooks.handleGetWebhook(c)
+}
+
+func (cs *CameraServer) handleUpdateWebhook(c *gin.Context) {
+	cs.webhooks.handleUpdateWebhook(c)
+}
+
+func (cs *CameraServer) handleDeleteWebhook(c *gin.Context) {
+	cs.webhooks.handleDeleteWebhook(c)
+}
+
+func (cs *CameraServer) handleTestWebhook(c *gin.Context) {
+	cs.webhooks.handleTestWebhook(c)
+}
+
+func (cs *CameraServer) handleWebhookDeliveries(c *gin.Context) {
+	cs.webhooks.handleWebhookDeliveries(c)
+}
+
+func (cs *CameraServer) handleMotion(c *gin.Context) {
+	cs.motion.handleMotion(c)
+}
+
+func (cs *CameraServer) handleMotionSettings(c *gin.Context) {
+	cs.motion.handleMotionSettings(c)
+}
+
+func (cs *CameraServer) handleMotionZones(c *gin.Context) {
+	cs.motion.handleMotionZones(c)
+}
+
+func (cs *CameraServer) handleReplaceMotionZones(c *gin.Context) {
+	cs.motion.handleReplaceMotionZones(c)
+}
+
+func (cs *CameraServer) handleAddMotionZone(c *gin.Context) {
+	cs.motion.handleAddMotionZone(c)
+}
+
+func (cs *CameraServer) handleDeleteMotionZone(c *gin.Context) {
+	cs.motion.handleDeleteMotionZone(c)
+}
+
+// handleMotionEvent publica el movimiento en el bus y guarda un clip con
+// el pre-roll al empezar cada uno
+func (cs *CameraServer) handleMotionEvent(kind string, event MotionEvent) {
+	if kind != "start" {
+		cs.events.Publish(eventMotionEnd, event)
+		return
+	}
+	cs.events.Publish(eventMotionStart, event)
+	if _, err := cs.clips.Save(recordingEventMotion, cs.clips.preRoll, clipDefaultPostRoll); err != nil {
+		log.Printf("⚠️  Sin clip para el movimiento %s: %v", event.ID, err)
+	}
+}
+
+func (cs *CameraServer) handleHLS(c *gin.Context) {
+	cs.hls.handleHLS(c)
+}
+
 func (cs *CameraServer) handleEnhanced(c *gin.Context) {
 	c.File("enhanced.html")
 }
 
+func (cs *CameraServer) handleViewer(c *gin.Context) {
+	c.File("viewer.html")
+}
+
 // Wrappers para Gin
 func (cs *CameraServer) handleHomeGin(c *gin.Context) {
 	cs.handleHome(c.Writer, c.Request)
@@ -797,6 +1410,10 @@ func (cs *CameraServer) handleStreamGin(c *gin.Context) {
 	cs.handleStream(c.Writer, c.Request)
 }
 
+func (cs *CameraServer) handleMJPEGGin(c *gin.Context) {
+	cs.handleMJPEG(c.Writer, c.Request)
+}
+
 func (cs *CameraServer) handleStatusGin(c *gin.Context) {
 	cs.handleStatus(c.Writer, c.Request)
 }
@@ -809,79 +1426,19 @@ func (cs *CameraServer) handleStopCameraGin(c *gin.Context) {
 	cs.handleStopCamera(c.Writer, c.Request)
 }
 
+// captureImage obtiene un frame JPEG de la fuente de cámara activa
 func (cs *CameraServer) captureImage() ([]byte, error) {
-	log.Println("🔍 Iniciando captura de imagen...")
-
-	// Verificar si estamos en Android/Termux
-	if !isAndroidEnvironment() {
-		log.Println("❌ No se detectó entorno Android/Termux")
-		return nil, fmt.Errorf("not in android environment")
-	}
-
-	log.Println("✅ Entorno Android/Termux detectado")
-
-	// Obtener directorio temporal seguro para Android
-	tmpDir := getTempDir()
-	tmpFile := tmpDir + "/alien_cam_temp.jpg"
-
-	log.Printf("📁 Directorio temporal: %s", tmpDir)
-
-	// Verificar si Termux:API está disponible
-	if !isCommandAvailable("termux-camera-info") {
-		log.Println("❌ Termux:API no está disponible")
-		log.Println("💡 Solución: pkg install termux-api e instalar Termux:API desde F-Droid")
-		return nil, fmt.Errorf("termux:api not available")
-	}
-
-	log.Println("✅ Termux:API detectado")
-
-	// Probar obtener información de cámaras
-	cmd := exec.Command("termux-camera-info")
-	if output, err := cmd.Output(); err != nil {
-		log.Printf("❌ Error al obtener info de cámaras: %v", err)
-		log.Println("💡 Verifica permisos de cámara en Ajustes > Aplicaciones > Termux")
-		return nil, fmt.Errorf("camera info failed: %v", err)
-	} else {
-		log.Printf("📷 Cámaras detectadas: %s", string(output))
-	}
-
-	// Capturar imagen con Termux:API (sintaxis correcta)
-	log.Println("📸 Capturando imagen...")
-	cmd = exec.Command("termux-camera-photo", tmpFile)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("❌ Error al capturar imagen: %v", err)
-		log.Printf("📄 Salida del comando: %s", string(output))
-		log.Println("💡 Verifica:")
-		log.Println("   - Permisos de cámara concedidos a Termux")
-		log.Println("   - La cámara no está siendo usada por otra app")
-		log.Println("   - El dispositivo tiene cámara funcional")
-		return nil, fmt.Errorf("camera capture failed: %v", err)
-	}
-
-	log.Println("✅ Imagen capturada exitosamente")
-
-	// Verificar si el archivo existe
-	if _, err := os.Stat(tmpFile); os.IsNotExist(err) {
-		log.Printf("❌ El archivo de imagen no existe: %s", tmpFile)
-		return nil, fmt.Errorf("image file not created")
-	}
-
-	// Leer la imagen capturada
-	imgData, err := os.ReadFile(tmpFile)
-	if err != nil {
-		log.Printf("❌ Error al leer archivo de imagen: %v", err)
-		return nil, fmt.Errorf("failed to read image: %v", err)
-	}
+	return cs.source.CaptureFrame()
+}
 
-	// Limpiar archivo temporal
-	if err := os.Remove(tmpFile); err != nil {
-		log.Printf("⚠️  No se pudo eliminar archivo temporal: %v", err)
-	} else {
-		log.Println("🗑️  Archivo temporal eliminado")
-	}
+// cameraName es el nombre de la cámara que se guarda con cada grabación
+func (cs *CameraServer) cameraName() string {
+	return cs.source.Capabilities().Name
+}
 
-	log.Printf("✅ Imagen leída correctamente (%d bytes)", len(imgData))
-	return imgData, nil
+// cameraID identifica la cámara activa aunque cambie su nombre
+func (cs *CameraServer) cameraID() string {
+	return cs.source.Capabilities().ID
 }
 
 // isAndroidEnvironment verifica si estamos corriendo en Android/Termux
@@ -906,6 +1463,15 @@ func getTempDir() string {
 	return "/tmp"
 }
 
+// dataDir devuelve el directorio de datos persistentes (grabaciones).
+// Se puede cambiar con ALIEN_CAM_DATA.
+func dataDir() string {
+	if dir := os.Getenv("ALIEN_CAM_DATA"); dir != "" {
+		return dir
+	}
+	return filepath.Join(getTempDir(), "alien-cam")
+}
+
 // isCommandAvailable verifica si un comando está disponible en el sistema
 func isCommandAvailable(command string) bool {
 	cmd := exec.Command("sh", "-c", "command -v "+command)
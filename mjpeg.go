@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// mjpegBoundary separa las partes del stream multipart
+const mjpegBoundary = "alienframe"
+
+// handleMJPEG sirve el bucle de captura como stream multipart/x-mixed-replace,
+// el formato estándar de las cámaras IP. El parámetro fps limita la tasa por cliente.
+func (cs *CameraServer) handleMJPEG(w http.ResponseWriter, r *http.Request) {
+	var minInterval time.Duration
+	if value := r.URL.Query().Get("fps"); value != "" {
+		fps, err := strconv.ParseFloat(value, 64)
+		if err != nil || fps <= 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"status":  "error",
+				"message": "Parámetro fps inválido",
+			})
+			return
+		}
+		minInterval = time.Duration(float64(time.Second) / fps)
+	}
+
+	// El stream termina cuando se detiene la cámara
+	stopped := cs.captureStopped()
+	if stopped == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "error",
+			"message": "La cámara no está iniciada",
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := cs.frames.Subscribe(1)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("📺 Cliente MJPEG conectado desde %s", r.RemoteAddr)
+
+	// Empezar con el último frame para no dejar la imagen en negro
+	if latest := cs.frames.Latest(); latest != nil {
+		if err := writeMJPEGPart(w, latest); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	var lastSent time.Time
+	for {
+		select {
+		case <-r.Context().Done():
+			log.Printf("📺 Cliente MJPEG desconectado (%d frames descartados)", sub.Dropped())
+			return
+		case <-stopped:
+			log.Printf("📺 Cámara detenida, cerrando stream MJPEG de %s", r.RemoteAddr)
+			return
+		case frame := <-sub.C:
+			if minInterval > 0 && frame.Timestamp.Sub(lastSent) < minInterval {
+				continue
+			}
+			if err := writeMJPEGPart(w, frame); err != nil {
+				log.Printf("❌ Error enviando frame MJPEG: %v", err)
+				return
+			}
+			flusher.Flush()
+			lastSent = frame.Timestamp
+		}
+	}
+}
+
+// writeMJPEGPart escribe un frame como una parte del multipart
+func writeMJPEGPart(w http.ResponseWriter, frame *Frame) error {
+	header := fmt.Sprintf("--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\nX-Timestamp: %d\r\n\r\n",
+		mjpegBoundary, len(frame.Data), frame.Timestamp.UnixMilli())
+	if _, err := w.Write([]byte(header)); err != nil {
+		return err
+	}
+	if _, err := w.Write(frame.Data); err != nil {
+		return err
+	}
+	_, err := w.Write([]byte("\r\n"))
+	return err
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// motionWidth y motionHeight son la resolución reducida que se analiza
+	motionWidth  = 96
+	motionHeight = 72
+	// motionBlock es el lado en píxeles de cada bloque comparado
+	motionBlock   = 8
+	motionColumns = motionWidth / motionBlock
+	motionRows    = motionHeight / motionBlock
+	// motionLearnRate es cuánto se adapta el fondo a cada frame
+	motionLearnRate = 0.05
+	// motionLightChange es la fracción de bloques cambiados a partir de la
+	// cual se asume un cambio de luz y se reinicia el fondo
+	motionLightChange = 0.8
+	// motionStartFrames son los frames seguidos con movimiento para empezar un evento
+	motionStartFrames = 2
+	// motionHistory es el número de eventos recientes que se guardan
+	motionHistory = 100
+	motionBuffer  = 4
+	// motionNotices es cuántos avisos pueden esperar a onEvent
+	motionNotices = 16
+)
+
+// MotionSettings son los ajustes del detector que se cambian por la API
+type MotionSettings struct {
+	Enabled bool `json:"enabled"`
+	// Sensitivity va de 1 a 100: más alto detecta cambios más pequeños
+	Sensitivity int `json:"sensitivity"`
+	// MinArea es el porcentaje mínimo de la imagen que debe cambiar
+	MinArea float64 `json:"minArea"`
+	// FPS es cuántos frames por segundo se analizan como máximo
+	FPS float64 `json:"fps"`
+	// Cooldown son los segundos sin movimiento que cierran un evento
+	Cooldown float64 `json:"cooldown"`
+}
+
+// MotionBox es un rectángulo en coordenadas normalizadas (0 a 1)
+type MotionBox struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+// MotionEvent es un periodo continuo con movimiento
+type MotionEvent struct {
+	ID     string    `json:"id"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end,omitempty"`
+	Active bool      `json:"active"`
+	// Score es el máximo porcentaje de la imagen con movimiento
+	Score float64   `json:"score"`
+	Box   MotionBox `json:"box"`
+	// Frames es el número de frames analizados con movimiento
+	Frames int `json:"frames"`
+}
+
+// MotionDetector compara los JPEG del bucle de captura con un fondo que
+// se actualiza poco a poco y agrupa el movimiento en eventos
+type MotionDetector struct {
+	frames *FrameBroker
+	zones  *MotionZoneStore
+	camera func() string
+	wake   chan struct{}
+	// onEvent recibe "start" y "end" de cada evento, en orden y desde
+	// una única goroutine
+	onEvent func(kind string, event MotionEvent)
+	notices chan motionNotice
+
+	mutex      sync.Mutex
+	settings   MotionSettings
+	background []float64
+	streak     int
+	lastMotion time.Time
+	current    *MotionEvent
+	events     []MotionEvent
+	analyzed   uint64
+	lastScore  float64
+	// pending son los avisos que se envían a notices al soltar el mutex
+	pending []motionNotice
+}
+
+// motionNotice es un aviso pendiente de entregar a onEvent
+type motionNotice struct {
+	kind  string
+	event MotionEvent
+}
+
+// motionResult es el resultado de analizar un frame
+type motionResult struct {
+	// area es el porcentaje de bloques con movimiento dentro de las zonas
+	area float64
+	// global es el porcentaje de bloques cambiados en toda la imagen
+	global float64
+	box    MotionBox
+}
+
+// NewMotionDetector crea el detector; ALIEN_CAM_MOTION=1 lo activa al
+// arrancar. camera da el id de la cámara actual para elegir sus zonas.
+func NewMotionDetector(frames *FrameBroker, zones *MotionZoneStore, camera func() string, onEvent func(kind string, event MotionEvent)) *MotionDetector {
+	d := &MotionDetector{
+		frames:  frames,
+		zones:   zones,
+		camera:  camera,
+		onEvent: onEvent,
+		wake:    make(chan struct{}, 1),
+		notices: make(chan motionNotice, motionNotices),
+		settings: MotionSettings{
+			Sensitivity: 50,
+			MinArea:     2,
+			FPS:         2,
+			Cooldown:    5,
+		},
+	}
+	if value := os.Getenv("ALIEN_CAM_MOTION"); value == "1" || value == "true" {
+		d.settings.Enabled = true
+	}
+	go d.run()
+	go d.deliver()
+	return d
+}
+
+// Settings devuelve los ajustes actuales
+func (d *MotionDetector) Settings() MotionSettings {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.settings
+}
+
+// Configure valida y aplica los ajustes
+func (d *MotionDetector) Configure(settings MotionSettings) error {
+	if settings.Sensitivity < 1 || settings.Sensitivity > 100 {
+		return fmt.Errorf("sensitivity must be between 1 and 100")
+	}
+	if settings.MinArea < 0 || settings.MinArea > 100 {
+		return fmt.Errorf("minArea must be between 0 and 100")
+	}
+	if settings.FPS <= 0 || settings.FPS > 10 {
+		return fmt.Errorf("fps must be between 0 and 10")
+	}
+	if settings.Cooldown < 0 || settings.Cooldown > 300 {
+		return fmt.Errorf("cooldown must be between 0 and 300")
+	}
+
+	d.mutex.Lock()
+	d.settings = settings
+	d.mutex.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	log.Printf("🏃 Detección de movimiento: activa=%v sensibilidad=%d área mínima=%.1f%%",
+		settings.Enabled, settings.Sensitivity, settings.MinArea)
+	return nil
+}
+
+func (d *MotionDetector) run() {
+	for {
+		if !d.Settings().Enabled {
+			<-d.wake
+			continue
+		}
+		d.consume()
+	}
+}
+
+// consume analiza frames mientras el detector esté activo
+func (d *MotionDetector) consume() {
+	sub := d.frames.Subscribe(motionBuffer)
+	defer sub.Close()
+	defer d.reset()
+	log.Println("🏃 Detección de movimiento iniciada")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		select {
+		case frame := <-sub.C:
+			settings := d.Settings()
+			if frame.Codec != codecJPEG || frame.Timestamp.Sub(last) < time.Duration(float64(time.Second)/settings.FPS) {
+				continue
+			}
+			last = frame.Timestamp
+			if err := d.analyze(frame); err != nil {
+				log.Printf("⚠️  Frame no analizable: %v", err)
+			}
+		case <-ticker.C:
+			d.checkCooldown(time.Now())
+		case <-d.wake:
+		}
+		if !d.Settings().Enabled {
+			log.Println("⏹️  Detección de movimiento detenida")
+			return
+		}
+	}
+}
+
+// analyze compara un frame con el fondo y actualiza el evento en curso
+func (d *MotionDetector) analyze(frame *Frame) error {
+	img, err := jpeg.Decode(bytes.NewReader(frame.Data))
+	if err != nil {
+		return err
+	}
+	gray := image.NewGray(image.Rect(0, 0, motionWidth, motionHeight))
+	draw.ApproxBiLinear.Scale(gray, gray.Bounds(), img, img.Bounds(), draw.Src, nil)
+	mask := d.zones.Mask(d.camera())
+
+	d.mutex.Lock()
+	defer d.flushNotices()
+	defer d.mutex.Unlock()
+	d.analyzed++
+
+	if d.background == nil {
+		d.background = make([]float64, len(gray.Pix))
+		for i, v := range gray.Pix {
+			d.background[i] = float64(v)
+		}
+		return nil
+	}
+
+	result := d.compareLocked(gray, mask)
+	d.lastScore = result.area
+
+	// Cambio de luz o de encuadre: se toma el frame como fondo nuevo
+	if result.global >= motionLightChange*100 {
+		for i, v := range gray.Pix {
+			d.background[i] = float64(v)
+		}
+		d.streak = 0
+		return nil
+	}
+
+	for i, v := range gray.Pix {
+		d.background[i] += (float64(v) - d.background[i]) * motionLearnRate
+	}
+
+	if result.area < d.settings.MinArea || result.area == 0 {
+		d.streak = 0
+		return nil
+	}
+
+	d.streak++
+	d.lastMotion = frame.Timestamp
+	if d.current == nil {
+		if d.streak < motionStartFrames {
+			return nil
+		}
+		d.current = &MotionEvent{
+			ID:     newSessionID("motion"),
+			Start:  frame.Timestamp,
+			Active: true,
+			Score:  result.area,
+			Box:    result.box,
+			Frames: 1,
+		}
+		d.emitLocked("start", *d.current)
+		log.Printf("🚨 Movimiento detectado (%.1f%% de la imagen)", result.area)
+		return nil
+	}
+
+	d.current.Frames++
+	if result.area > d.current.Score {
+		d.current.Score = result.area
+	}
+	d.current.Box = unionMotionBox(d.current.Box, result.box)
+	return nil
+}
+
+// motionThreshold convierte la sensibilidad en la diferencia media de
+// luminancia que marca un bloque como cambiado
+func motionThreshold(sensitivity int) float64 {
+	return 8 + float64(100-sensitivity)*0.6
+}
+
+// compareLocked calcula la diferencia media por bloque y devuelve el
+// porcentaje de bloques cambiados y el rectángulo que los contiene. Con
+// máscara, cada bloque solo promedia los píxeles que quedan dentro.
+func (d *MotionDetector) compareLocked(gray *image.Gray, mask []bool) motionResult {
+	threshold := motionThreshold(d.settings.Sensitivity)
+	minX, minY, maxX, maxY := motionColumns, motionRows, -1, -1
+	changed, global := 0, 0
+
+	for by := 0; by < motionRows; by++ {
+		for bx := 0; bx < motionColumns; bx++ {
+			var sum, zoneSum float64
+			zonePixels := 0
+			for y := by * motionBlock; y < (by+1)*motionBlock; y++ {
+				row := y * motionWidth
+				for x := bx * motionBlock; x < (bx+1)*motionBlock; x++ {
+					diff := math.Abs(float64(gray.Pix[row+x]) - d.background[row+x])
+					sum += diff
+					if mask == nil || mask[row+x] {
+						zoneSum += diff
+						zonePixels++
+					}
+				}
+			}
+			if sum/(motionBlock*motionBlock) >= threshold {
+				global++
+			}
+			if zonePixels == 0 || zoneSum/float64(zonePixels) < threshold {
+				continue
+			}
+			changed++
+			minX, minY = min(minX, bx), min(minY, by)
+			maxX, maxY = max(maxX, bx), max(maxY, by)
+		}
+	}
+
+	result := motionResult{
+		area:   float64(changed) * 100 / (motionColumns * motionRows),
+		global: float64(global) * 100 / (motionColumns * motionRows),
+	}
+	if changed > 0 {
+		result.box = MotionBox{
+			X: float64(minX) / motionColumns,
+			Y: float64(minY) / motionRows,
+			W: float64(maxX-minX+1) / motionColumns,
+			H: float64(maxY-minY+1) / motionRows,
+		}
+	}
+	return result
+}
+
+// unionMotionBox devuelve el rectángulo que contiene a ambos
+func unionMotionBox(a, b MotionBox) MotionBox {
+	x0, y0 := math.Min(a.X, b.X), math.Min(a.Y, b.Y)
+	x1, y1 := math.Max(a.X+a.W, b.X+b.W), math.Max(a.Y+a.H, b.Y+b.H)
+	return MotionBox{X: x0, Y: y0, W: x1 - x0, H: y1 - y0}
+}
+
+// checkCooldown cierra el evento tras el tiempo de espera sin movimiento
+func (d *MotionDetector) checkCooldown(now time.Time) {
+	d.mutex.Lock()
+	defer d.flushNotices()
+	defer d.mutex.Unlock()
+	if d.current == nil {
+		return
+	}
+	if now.Sub(d.lastMotion) < time.Duration(d.settings.Cooldown*float64(time.Second)) {
+		return
+	}
+	d.finishLocked(d.lastMotion)
+}
+
+// finishLocked cierra el evento en curso y lo guarda en el historial
+func (d *MotionDetector) finishLocked(end time.Time) {
+	event := *d.current
+	d.current = nil
+	event.End = end
+	event.Active = false
+	d.events = append(d.events, event)
+	if len(d.events) > motionHistory {
+		d.events = d.events[len(d.events)-motionHistory:]
+	}
+	d.emitLocked("end", event)
+	log.Printf("✅ Fin del movimiento (%.1fs, máximo %.1f%%)", end.Sub(event.Start).Seconds(), event.Score)
+}
+
+// emitLocked guarda el aviso para enviarlo al soltar el mutex; deliver los
+// entrega en el mismo orden para que nunca llegue un "end" antes de su "start"
+func (d *MotionDetector) emitLocked(kind string, event MotionEvent) {
+	d.pending = append(d.pending, motionNotice{kind: kind, event: event})
+}
+
+// flushNotices envía los avisos pendientes sin el mutex tomado, para que
+// un onEvent lento frene solo el análisis y no Settings ni la API. Solo
+// la llama la goroutine de consume, así que el orden se conserva.
+func (d *MotionDetector) flushNotices() {
+	d.mutex.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.mutex.Unlock()
+	for _, notice := range pending {
+		d.notices <- notice
+	}
+}
+
+// deliver llama a onEvent con cada aviso fuera del bloqueo del análisis
+func (d *MotionDetector) deliver() {
+	for notice := range d.notices {
+		if d.onEvent != nil {
+			d.onEvent(notice.kind, notice.event)
+		}
+	}
+}
+
+// reset olvida el fondo y cierra el evento en curso al detenerse
+func (d *MotionDetector) reset() {
+	d.mutex.Lock()
+	defer d.flushNotices()
+	defer d.mutex.Unlock()
+	if d.current != nil {
+		d.finishLocked(d.lastMotion)
+	}
+	d.background = nil
+	d.streak = 0
+}
+
+// Active indica si hay un evento de movimiento en curso
+func (d *MotionDetector) Active() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.current != nil
+}
+
+// Events devuelve los eventos recientes, del más nuevo al más viejo,
+// empezando por el que está en curso
+func (d *MotionDetector) Events() []MotionEvent {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	events := make([]MotionEvent, 0, len(d.events)+1)
+	if d.current != nil {
+		events = append(events, *d.current)
+	}
+	for i := len(d.events) - 1; i >= 0; i-- {
+		events = append(events, d.events[i])
+	}
+	return events
+}
+
+// handleMotion devuelve ajustes, estado y eventos recientes
+func (d *MotionDetector) handleMotion(c *gin.Context) {
+	d.mutex.Lock()
+	status := gin.H{
+		"settings": d.settings,
+		"active":   d.current != nil,
+		"analyzed": d.analyzed,
+		"score":    d.lastScore,
+	}
+	d.mutex.Unlock()
+	status["events"] = d.Events()
+	c.JSON(http.StatusOK, status)
+}
+
+// handleMotionSettings cambia los ajustes; los campos omitidos se mantienen
+func (d *MotionDetector) handleMotionSettings(c *gin.Context) {
+	settings := d.Settings()
+	if err := json.NewDecoder(c.Request.Body).Decode(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("JSON inválido: %v", err)})
+		return
+	}
+	if err := d.Configure(settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, d.Settings())
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// flatBackground es un fondo de luminancia constante
+func flatBackground(value float64) []float64 {
+	background := make([]float64, motionWidth*motionHeight)
+	for i := range background {
+		background[i] = value
+	}
+	return background
+}
+
+// grayWithBlock es una imagen reducida con un rectángulo de bloques más claro
+func grayWithBlock(value, bright uint8, bx0, by0, bx1, by1 int) *image.Gray {
+	gray := image.NewGray(image.Rect(0, 0, motionWidth, motionHeight))
+	for y := 0; y < motionHeight; y++ {
+		for x := 0; x < motionWidth; x++ {
+			v := value
+			if x >= bx0*motionBlock && x < bx1*motionBlock && y >= by0*motionBlock && y < by1*motionBlock {
+				v = bright
+			}
+			gray.Pix[y*motionWidth+x] = v
+		}
+	}
+	return gray
+}
+
+func TestMotionCompare(t *testing.T) {
+	blocks := float64(motionColumns * motionRows)
+	leftHalf := make([]bool, motionWidth*motionHeight)
+	for i := range leftHalf {
+		leftHalf[i] = i%motionWidth < motionWidth/2
+	}
+
+	tests := []struct {
+		name   string
+		gray   *image.Gray
+		mask   []bool
+		area   float64
+		global float64
+		box    MotionBox
+	}{
+		{"no change", grayWithBlock(100, 100, 0, 0, 0, 0), nil, 0, 0, MotionBox{}},
+		{"small change below threshold", grayWithBlock(100, 120, 0, 0, motionColumns, motionRows), nil, 0, 0, MotionBox{}},
+		{
+			"one block", grayWithBlock(100, 200, 3, 2, 4, 3), nil,
+			100 / blocks, 100 / blocks,
+			MotionBox{X: 3.0 / motionColumns, Y: 2.0 / motionRows, W: 1.0 / motionColumns, H: 1.0 / motionRows},
+		},
+		{
+			"two by two blocks", grayWithBlock(100, 200, 0, 0, 2, 2), nil,
+			400 / blocks, 400 / blocks,
+			MotionBox{W: 2.0 / motionColumns, H: 2.0 / motionRows},
+		},
+		{"outside mask", grayWithBlock(100, 200, motionColumns-1, 0, motionColumns, 1), leftHalf, 0, 100 / blocks, MotionBox{}},
+		{
+			"inside mask", grayWithBlock(100, 200, 0, 0, 1, 1), leftHalf,
+			100 / blocks, 100 / blocks,
+			MotionBox{W: 1.0 / motionColumns, H: 1.0 / motionRows},
+		},
+		{"whole frame", grayWithBlock(100, 200, 0, 0, motionColumns, motionRows), nil, 100, 100, MotionBox{W: 1, H: 1}},
+	}
+	for _, tt := range tests {
+		d := &MotionDetector{settings: MotionSettings{Sensitivity: 50}, background: flatBackground(100)}
+		got := d.compareLocked(tt.gray, tt.mask)
+		if math.Abs(got.area-tt.area) > 1e-9 || math.Abs(got.global-tt.global) > 1e-9 || got.box != tt.box {
+			t.Errorf("%s: compareLocked = %+v, want area %.3f global %.3f box %+v", tt.name, got, tt.area, tt.global, tt.box)
+		}
+	}
+}
+
+func TestMotionThreshold(t *testing.T) {
+	tests := []struct {
+		sensitivity int
+		want        float64
+	}{
+		{100, 8},
+		{50, 38},
+		{1, 67.4},
+	}
+	for _, tt := range tests {
+		if got := motionThreshold(tt.sensitivity); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("motionThreshold(%d) = %v, want %v", tt.sensitivity, got, tt.want)
+		}
+	}
+}
+
+// motionJPEG codifica un frame gris con un recuadro claro opcional
+func motionJPEG(t *testing.T, bright bool) *Frame {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			v := uint8(60)
+			if bright && x < 160 && y < 120 {
+				v = 230
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return &Frame{Data: buf.Bytes(), Codec: codecJPEG, Keyframe: true, Timestamp: time.Now()}
+}
+
+func newTestMotionDetector(t *testing.T, onEvent func(string, MotionEvent)) *MotionDetector {
+	return &MotionDetector{
+		zones:    NewMotionZoneStore(filepath.Join(t.TempDir(), "zones.json")),
+		camera:   func() string { return "test" },
+		onEvent:  onEvent,
+		notices:  make(chan motionNotice, motionNotices),
+		settings: MotionSettings{Enabled: true, Sensitivity: 50, MinArea: 2, FPS: 2, Cooldown: 5},
+	}
+}
+
+func TestMotionEventOrder(t *testing.T) {
+	kinds := make(chan string, 4)
+	d := newTestMotionDetector(t, func(kind string, event MotionEvent) {
+		kinds <- kind
+	})
+	go d.deliver()
+
+	frames := []*Frame{motionJPEG(t, false)}
+	for i := 0; i < motionStartFrames; i++ {
+		frames = append(frames, motionJPEG(t, true))
+	}
+	for _, frame := range frames {
+		if err := d.analyze(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !d.Active() {
+		t.Fatal("no motion event after a bright block")
+	}
+	d.checkCooldown(time.Now().Add(time.Minute))
+
+	for _, want := range []string{"start", "end"} {
+		select {
+		case kind := <-kinds:
+			if kind != want {
+				t.Errorf("onEvent got %q, want %q", kind, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("onEvent did not receive %q", want)
+		}
+	}
+	if events := d.Events(); len(events) != 1 || events[0].Active || events[0].Frames != motionStartFrames-1 {
+		t.Errorf("events = %+v", events)
+	}
+}
+
+func TestMotionSlowHandlerKeepsLockFree(t *testing.T) {
+	d := newTestMotionDetector(t, nil)
+	// Sin deliver la cola se llena como con un onEvent bloqueado
+	for i := 0; i < motionNotices; i++ {
+		d.notices <- motionNotice{kind: "start"}
+	}
+	d.current = &MotionEvent{ID: "motion-test", Start: time.Now()}
+
+	done := make(chan struct{})
+	go func() {
+		d.checkCooldown(time.Now().Add(time.Minute))
+		close(done)
+	}()
+
+	// El envío espera, pero el mutex queda libre para la API: Active
+	// responde y acaba viendo el evento cerrado
+	active := func() bool {
+		result := make(chan bool)
+		go func() { result <- d.Active() }()
+		select {
+		case a := <-result:
+			return a
+		case <-time.After(time.Second):
+			t.Fatal("detector mutex held while the notice queue is full")
+			return false
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for active() {
+		if time.Now().After(deadline) {
+			t.Fatal("event still active after cooldown")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-done:
+		t.Fatal("checkCooldown returned with a full notice queue")
+	default:
+	}
+
+	<-d.notices
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("checkCooldown did not finish once the queue had room")
+	}
+	for i := 0; i < motionNotices-1; i++ {
+		<-d.notices
+	}
+	if notice := <-d.notices; notice.kind != "end" || notice.event.ID != "motion-test" {
+		t.Errorf("last notice = %+v", notice)
+	}
+}
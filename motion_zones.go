@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	motionZoneInclude = "include"
+	motionZoneExclude = "exclude"
+	// motionMaxZones y motionMaxPoints limitan lo que se acepta por la API
+	motionMaxZones  = 32
+	motionMaxPoints = 64
+)
+
+// MotionPoint es un vértice en coordenadas normalizadas (0 a 1)
+type MotionPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// MotionZone es un polígono que incluye o excluye una parte de la imagen.
+// Si hay zonas de inclusión solo cuenta el movimiento dentro de alguna de
+// ellas; las de exclusión siempre se descartan.
+type MotionZone struct {
+	ID     string        `json:"id"`
+	Name   string        `json:"name,omitempty"`
+	Mode   string        `json:"mode"`
+	Points []MotionPoint `json:"points"`
+}
+
+// MotionZoneStore guarda las zonas de cada cámara en un archivo JSON
+type MotionZoneStore struct {
+	path string
+
+	mutex sync.Mutex
+	zones map[string][]MotionZone
+	masks map[string][]bool
+}
+
+func NewMotionZoneStore(path string) *MotionZoneStore {
+	s := &MotionZoneStore{
+		path:  path,
+		zones: make(map[string][]MotionZone),
+		masks: make(map[string][]bool),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s
+	}
+	if err := json.Unmarshal(data, &s.zones); err != nil {
+		log.Printf("⚠️  Zonas de movimiento dañadas en %s: %v", path, err)
+		s.zones = make(map[string][]MotionZone)
+		return s
+	}
+	log.Printf("🗺️  Zonas de movimiento cargadas para %d cámara(s)", len(s.zones))
+	return s
+}
+
+// Zones devuelve una copia de las zonas de una cámara
+func (s *MotionZoneStore) Zones(camera string) []MotionZone {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]MotionZone{}, s.zones[camera]...)
+}
+
+// Set reemplaza las zonas de una cámara y las guarda en disco
+func (s *MotionZoneStore) Set(camera string, zones []MotionZone) error {
+	if len(zones) > motionMaxZones {
+		return fmt.Errorf("too many zones: %d (max %d)", len(zones), motionMaxZones)
+	}
+	for i := range zones {
+		if zones[i].ID == "" {
+			zones[i].ID = newSessionID("zone")
+		}
+		if err := validateMotionZone(zones[i]); err != nil {
+			return fmt.Errorf("zone %d: %v", i+1, err)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.setLocked(camera, zones)
+}
+
+// setLocked reemplaza las zonas ya validadas y las guarda, dejando las
+// anteriores si falla la escritura. Debe llamarse con el mutex tomado.
+func (s *MotionZoneStore) setLocked(camera string, zones []MotionZone) error {
+	previous, existed := s.zones[camera]
+	if len(zones) == 0 {
+		delete(s.zones, camera)
+	} else {
+		s.zones[camera] = zones
+	}
+	if err := s.saveLocked(); err != nil {
+		if existed {
+			s.zones[camera] = previous
+		} else {
+			delete(s.zones, camera)
+		}
+		return err
+	}
+	delete(s.masks, camera)
+	log.Printf("🗺️  %d zona(s) de movimiento para %s", len(zones), camera)
+	return nil
+}
+
+// Add añade una zona y devuelve su id. Lectura y escritura van en el mismo
+// bloqueo para que dos peticiones a la vez no pierdan ninguna zona.
+func (s *MotionZoneStore) Add(camera string, zone MotionZone) (MotionZone, error) {
+	zone.ID = newSessionID("zone")
+	if err := validateMotionZone(zone); err != nil {
+		return MotionZone{}, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	zones := append(append([]MotionZone{}, s.zones[camera]...), zone)
+	if len(zones) > motionMaxZones {
+		return MotionZone{}, fmt.Errorf("too many zones: %d (max %d)", len(zones), motionMaxZones)
+	}
+	if err := s.setLocked(camera, zones); err != nil {
+		return MotionZone{}, err
+	}
+	return zone, nil
+}
+
+// Remove borra una zona por id
+func (s *MotionZoneStore) Remove(camera, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current := s.zones[camera]
+	for i, zone := range current {
+		if zone.ID == id {
+			// Copia nueva: la anterior sigue siendo la vuelta atrás de setLocked
+			zones := append(append([]MotionZone{}, current[:i]...), current[i+1:]...)
+			return s.setLocked(camera, zones)
+		}
+	}
+	return os.ErrNotExist
+}
+
+// saveLocked escribe el archivo de forma atómica
+func (s *MotionZoneStore) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s.zones, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Mask devuelve qué píxeles de la imagen reducida se analizan, o nil si
+// la cámara no tiene zonas y cuenta toda la imagen
+func (s *MotionZoneStore) Mask(camera string) []bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	zones := s.zones[camera]
+	if len(zones) == 0 {
+		return nil
+	}
+	if mask, ok := s.masks[camera]; ok {
+		return mask
+	}
+
+	hasInclude := false
+	for _, zone := range zones {
+		if zone.Mode == motionZoneInclude {
+			hasInclude = true
+			break
+		}
+	}
+
+	mask := make([]bool, motionWidth*motionHeight)
+	for y := 0; y < motionHeight; y++ {
+		for x := 0; x < motionWidth; x++ {
+			// Se evalúa el centro de cada píxel
+			point := MotionPoint{
+				X: (float64(x) + 0.5) / motionWidth,
+				Y: (float64(y) + 0.5) / motionHeight,
+			}
+			inside := !hasInclude
+			for _, zone := range zones {
+				if !pointInPolygon(point, zone.Points) {
+					continue
+				}
+				if zone.Mode == motionZoneExclude {
+					inside = false
+					break
+				}
+				inside = true
+			}
+			mask[y*motionWidth+x] = inside
+		}
+	}
+	s.masks[camera] = mask
+	return mask
+}
+
+func validateMotionZone(zone MotionZone) error {
+	if zone.Mode != motionZoneInclude && zone.Mode != motionZoneExclude {
+		return fmt.Errorf("mode must be %q or %q", motionZoneInclude, motionZoneExclude)
+	}
+	if len(zone.Points) < 3 || len(zone.Points) > motionMaxPoints {
+		return fmt.Errorf("polygon needs between 3 and %d points", motionMaxPoints)
+	}
+	for _, p := range zone.Points {
+		if p.X < 0 || p.X > 1 || p.Y < 0 || p.Y > 1 {
+			return fmt.Errorf("point (%g, %g) outside 0-1 range", p.X, p.Y)
+		}
+	}
+	return nil
+}
+
+// pointInPolygon usa el método de cruce de rayos
+func pointInPolygon(p MotionPoint, polygon []MotionPoint) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// zoneCamera devuelve el id de cámara de ?camera= o el de la actual
+func (d *MotionDetector) zoneCamera(c *gin.Context) string {
+	if camera := c.Query("camera"); camera != "" {
+		return camera
+	}
+	return d.camera()
+}
+
+// handleMotionZones lista las zonas de una cámara
+func (d *MotionDetector) handleMotionZones(c *gin.Context) {
+	camera := d.zoneCamera(c)
+	c.JSON(http.StatusOK, gin.H{"camera": camera, "zones": d.zones.Zones(camera)})
+}
+
+// handleReplaceMotionZones reemplaza todas las zonas: {"zones": [...]}
+func (d *MotionDetector) handleReplaceMotionZones(c *gin.Context) {
+	var req struct {
+		Zones []MotionZone `json:"zones"`
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("JSON inválido: %v", err)})
+		return
+	}
+	camera := d.zoneCamera(c)
+	if err := d.zones.Set(camera, req.Zones); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"camera": camera, "zones": d.zones.Zones(camera)})
+}
+
+// handleAddMotionZone añade una zona: {"mode": "exclude", "points": [{"x": 0, "y": 0}, ...]}
+func (d *MotionDetector) handleAddMotionZone(c *gin.Context) {
+	var zone MotionZone
+	if err := json.NewDecoder(c.Request.Body).Decode(&zone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("JSON inválido: %v", err)})
+		return
+	}
+	zone, err := d.zones.Add(d.zoneCamera(c), zone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, zone)
+}
+
+// handleDeleteMotionZone borra una zona por id
+func (d *MotionDetector) handleDeleteMotionZone(c *gin.Context) {
+	if err := d.zones.Remove(d.zoneCamera(c), c.Param("id")); err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "zone not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// rect es un polígono rectangular en coordenadas normalizadas
+func rect(x0, y0, x1, y1 float64) []MotionPoint {
+	return []MotionPoint{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}
+}
+
+func TestPointInPolygon(t *testing.T) {
+	triangle := []MotionPoint{{0, 0}, {1, 0}, {0, 1}}
+	// Una "L": el cuadrado entero sin el cuarto superior derecho
+	concave := []MotionPoint{{0, 0}, {0.5, 0}, {0.5, 0.5}, {1, 0.5}, {1, 1}, {0, 1}}
+
+	tests := []struct {
+		name    string
+		point   MotionPoint
+		polygon []MotionPoint
+		want    bool
+	}{
+		{"square center", MotionPoint{0.5, 0.5}, rect(0.25, 0.25, 0.75, 0.75), true},
+		{"square outside", MotionPoint{0.1, 0.5}, rect(0.25, 0.25, 0.75, 0.75), false},
+		{"triangle inside", MotionPoint{0.2, 0.2}, triangle, true},
+		{"triangle beyond hypotenuse", MotionPoint{0.6, 0.6}, triangle, false},
+		{"concave arm", MotionPoint{0.25, 0.25}, concave, true},
+		{"concave notch", MotionPoint{0.75, 0.25}, concave, false},
+		{"concave base", MotionPoint{0.75, 0.75}, concave, true},
+		{"degenerate", MotionPoint{0.5, 0.5}, nil, false},
+	}
+	for _, tt := range tests {
+		if got := pointInPolygon(tt.point, tt.polygon); got != tt.want {
+			t.Errorf("%s: pointInPolygon(%v) = %v, want %v", tt.name, tt.point, got, tt.want)
+		}
+	}
+}
+
+func TestMotionZoneMask(t *testing.T) {
+	// Píxel en el centro de la imagen y en cada esquina
+	center := (motionHeight/2)*motionWidth + motionWidth/2
+	topLeft := 0
+	bottomRight := motionWidth*motionHeight - 1
+
+	tests := []struct {
+		name  string
+		zones []MotionZone
+		want  map[int]bool
+	}{
+		{"no zones", nil, nil},
+		{
+			"exclude only",
+			[]MotionZone{{Mode: motionZoneExclude, Points: rect(0, 0, 0.25, 0.25)}},
+			map[int]bool{topLeft: false, center: true, bottomRight: true},
+		},
+		{
+			"include only",
+			[]MotionZone{{Mode: motionZoneInclude, Points: rect(0.25, 0.25, 0.75, 0.75)}},
+			map[int]bool{topLeft: false, center: true, bottomRight: false},
+		},
+		{
+			"exclude wins over include",
+			[]MotionZone{
+				{Mode: motionZoneInclude, Points: rect(0, 0, 1, 1)},
+				{Mode: motionZoneExclude, Points: rect(0.4, 0.4, 0.6, 0.6)},
+			},
+			map[int]bool{topLeft: true, center: false, bottomRight: true},
+		},
+		{
+			"exclude listed first still wins",
+			[]MotionZone{
+				{Mode: motionZoneExclude, Points: rect(0.4, 0.4, 0.6, 0.6)},
+				{Mode: motionZoneInclude, Points: rect(0, 0, 1, 1)},
+			},
+			map[int]bool{topLeft: true, center: false, bottomRight: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMotionZoneStore(filepath.Join(t.TempDir(), "zones.json"))
+			if err := s.Set("cam", tt.zones); err != nil {
+				t.Fatal(err)
+			}
+			mask := s.Mask("cam")
+			if tt.want == nil {
+				if mask != nil {
+					t.Errorf("mask without zones = %d pixels", len(mask))
+				}
+				return
+			}
+			if len(mask) != motionWidth*motionHeight {
+				t.Fatalf("mask has %d pixels", len(mask))
+			}
+			for pixel, want := range tt.want {
+				if mask[pixel] != want {
+					t.Errorf("pixel %d = %v, want %v", pixel, mask[pixel], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMotionZoneSetErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		zones []MotionZone
+	}{
+		{"bad mode", []MotionZone{{Mode: "ignore", Points: rect(0, 0, 1, 1)}}},
+		{"two points", []MotionZone{{Mode: motionZoneInclude, Points: []MotionPoint{{0, 0}, {1, 1}}}}},
+		{"out of range", []MotionZone{{Mode: motionZoneInclude, Points: rect(0, 0, 1.5, 1)}}},
+		{"too many", make([]MotionZone, motionMaxZones+1)},
+	}
+	for _, tt := range tests {
+		s := NewMotionZoneStore(filepath.Join(t.TempDir(), "zones.json"))
+		if err := s.Set("cam", tt.zones); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+		if zones := s.Zones("cam"); len(zones) != 0 {
+			t.Errorf("%s: invalid zones were stored", tt.name)
+		}
+	}
+}
+
+func TestMotionZoneConcurrentUpdates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zones.json")
+	s := NewMotionZoneStore(path)
+	keep, err := s.Add("cam", MotionZone{Mode: motionZoneExclude, Points: rect(0, 0, 0.1, 0.1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := s.Add("cam", MotionZone{Mode: motionZoneExclude, Points: rect(0.9, 0.9, 1, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const adds = 20
+	var wg sync.WaitGroup
+	for i := 0; i < adds; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Add("cam", MotionZone{Mode: motionZoneInclude, Points: rect(0.2, 0.2, 0.8, 0.8)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.Remove("cam", removed.ID); err != nil {
+			t.Error(err)
+		}
+	}()
+	wg.Wait()
+
+	// Ninguna actualización se pierde, ni en memoria ni en el archivo
+	for _, store := range []*MotionZoneStore{s, NewMotionZoneStore(path)} {
+		zones := store.Zones("cam")
+		if len(zones) != adds+1 {
+			t.Errorf("%d zones, want %d", len(zones), adds+1)
+		}
+		for _, zone := range zones {
+			if zone.ID == removed.ID {
+				t.Error("removed zone came back")
+			}
+		}
+		if len(zones) > 0 && zones[0].ID != keep.ID {
+			t.Errorf("first zone = %s, want %s", zones[0].ID, keep.ID)
+		}
+	}
+}
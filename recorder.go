@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// recordingTimeLayout es el formato de la hora de inicio en el nombre del archivo
+const recordingTimeLayout = "20060102-150405"
+
+// recordingPeerPattern limita los ids de peer que llegan del cliente y
+// forman parte del nombre del archivo
+var recordingPeerPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Recorder graba en disco los tracks WebRTC recibidos mientras la
+// grabación esté activa: VP8 a IVF, H.264 a Annex-B y Opus a Ogg.
+// Cada track genera un archivo "<peerID>_<inicio>" por sesión de grabación,
+// con sufijo "-N" si ya existe uno de ese segundo.
+type Recorder struct {
+	dir    string
+	events *EventBus
+
+	mutex   sync.Mutex
+	enabled bool
+	since   time.Time
+	tracks  map[*trackRecorder]struct{}
+}
+
+// RecordingStatus es el estado que se expone en /api/status
+type RecordingStatus struct {
+	Enabled bool      `json:"enabled"`
+	Since   time.Time `json:"since,omitempty"`
+	Files   []string  `json:"files"`
+}
+
+// trackRecorder escribe los paquetes RTP de un track en su archivo actual
+type trackRecorder struct {
+	recorder        *Recorder
+	peerID          string
+	codec           webrtc.RTPCodecParameters
+	requestKeyframe func()
+
+	mutex  sync.Mutex
+	writer media.Writer
+	path   string
+	start  time.Time
+}
+
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{
+		dir:    dir,
+		tracks: make(map[*trackRecorder]struct{}),
+	}
+}
+
+// addTrack registra un track recibido. Si la grabación está activa el
+// archivo se abre en el momento; requestKeyframe envía un PLI al
+// publicador para que el video empiece a grabarse cuanto antes.
+func (r *Recorder) addTrack(peerID string, codec webrtc.RTPCodecParameters, requestKeyframe func()) *trackRecorder {
+	t := &trackRecorder{
+		recorder:        r,
+		peerID:          peerID,
+		codec:           codec,
+		requestKeyframe: requestKeyframe,
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tracks[t] = struct{}{}
+	if r.enabled {
+		t.open()
+	}
+	return t
+}
+
+// Start activa la grabación de todos los tracks actuales y futuros
+func (r *Recorder) Start() error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create recordings dir: %w", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.enabled {
+		return nil
+	}
+	r.enabled = true
+	r.since = time.Now()
+	for t := range r.tracks {
+		t.open()
+	}
+	log.Printf("⏺️  Grabación iniciada en %s", r.dir)
+	return nil
+}
+
+// Stop cierra los archivos abiertos; los tracks siguen registrados
+func (r *Recorder) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.enabled {
+		return
+	}
+	r.enabled = false
+	r.since = time.Time{}
+	for t := range r.tracks {
+		t.finish()
+	}
+	log.Printf("⏹️  Grabación detenida")
+}
+
+// Status devuelve si se está grabando y los archivos abiertos
+func (r *Recorder) Status() RecordingStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := RecordingStatus{Enabled: r.enabled, Since: r.since, Files: []string{}}
+	for t := range r.tracks {
+		t.mutex.Lock()
+		if t.writer != nil {
+			status.Files = append(status.Files, filepath.Base(t.path))
+		}
+		t.mutex.Unlock()
+	}
+	sort.Strings(status.Files)
+	return status
+}
+
+// isRecording indica si algún track está escribiendo en path
+func (r *Recorder) isRecording(path string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for t := range r.tracks {
+		t.mutex.Lock()
+		active := t.writer != nil && t.path == path
+		t.mutex.Unlock()
+		if active {
+			return true
+		}
+	}
+	return false
+}
+
+// open crea el archivo del track según su codec. Se llama con r.mutex tomado.
+func (t *trackRecorder) open() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.writer != nil {
+		return
+	}
+	if !recordingPeerPattern.MatchString(t.peerID) {
+		log.Printf("⚠️  Id de peer no válido para grabar: %q", t.peerID)
+		return
+	}
+
+	var ext string
+	switch {
+	case strings.EqualFold(t.codec.MimeType, webrtc.MimeTypeVP8):
+		ext = ".ivf"
+	case strings.EqualFold(t.codec.MimeType, webrtc.MimeTypeH264):
+		ext = ".h264"
+	case strings.EqualFold(t.codec.MimeType, webrtc.MimeTypeOpus):
+		ext = ".ogg"
+	default:
+		log.Printf("⚠️  Codec no grabable para peer %s: %s", t.peerID, t.codec.MimeType)
+		return
+	}
+
+	// Reservar el nombre: parar y volver a grabar en el mismo segundo no
+	// debe truncar la grabación anterior
+	base := filepath.Join(t.recorder.dir, fmt.Sprintf("%s_%s", t.peerID, time.Now().Format(recordingTimeLayout)))
+	file, err := createRecordingFile(base, ext)
+	if err != nil {
+		log.Printf("❌ Error creando grabación %s%s: %v", base, ext, err)
+		return
+	}
+	t.path = file.Name()
+
+	var writer media.Writer
+	switch ext {
+	case ".ivf":
+		writer, err = ivfwriter.NewWith(file)
+	case ".h264":
+		writer = h264writer.NewWith(file)
+	case ".ogg":
+		writer, err = oggwriter.NewWith(file, t.codec.ClockRate, t.codec.Channels)
+	}
+	if err != nil {
+		log.Printf("❌ Error creando grabación %s: %v", t.path, err)
+		file.Close()
+		os.Remove(t.path)
+		return
+	}
+
+	t.writer = writer
+	t.start = time.Now()
+	writeRecordingMeta(t.path, t.peerID, recordingEventManual, t.codec.MimeType, t.start, time.Time{})
+	t.recorder.events.Publish(eventRecordingStarted, recordingEventData(t.path, t.peerID, recordingEventManual, t.start, time.Time{}))
+	log.Printf("⏺️  Grabando peer %s en %s", t.peerID, filepath.Base(t.path))
+
+	// Los escritores de video descartan todo hasta el primer keyframe
+	if t.requestKeyframe != nil && !strings.EqualFold(t.codec.MimeType, webrtc.MimeTypeOpus) {
+		go t.requestKeyframe()
+	}
+}
+
+// write añade un paquete RTP al archivo abierto, si lo hay
+func (t *trackRecorder) write(packet *rtp.Packet) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.writer == nil {
+		return
+	}
+	if err := t.writer.WriteRTP(packet); err != nil {
+		log.Printf("❌ Error grabando %s: %v", filepath.Base(t.path), err)
+		t.closeLocked()
+	}
+}
+
+// finish cierra el archivo actual del track
+func (t *trackRecorder) finish() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closeLocked()
+}
+
+func (t *trackRecorder) closeLocked() {
+	if t.writer == nil {
+		return
+	}
+	if err := t.writer.Close(); err != nil {
+		log.Printf("⚠️  Error cerrando grabación %s: %v", filepath.Base(t.path), err)
+	}
+	t.writer = nil
+	end := time.Now()
+	writeRecordingMeta(t.path, t.peerID, recordingEventManual, t.codec.MimeType, t.start, end)
+	t.recorder.events.Publish(eventRecordingFinished, recordingEventData(t.path, t.peerID, recordingEventManual, t.start, end))
+	log.Printf("💾 Grabación guardada: %s", t.path)
+}
+
+// close da de baja el track al terminar y cierra su archivo
+func (t *trackRecorder) close() {
+	r := t.recorder
+	r.mutex.Lock()
+	delete(r.tracks, t)
+	r.mutex.Unlock()
+	t.finish()
+}
+
+// handleStartRecording activa la grabación de los tracks recibidos
+func (r *Recorder) handleStartRecording(c *gin.Context) {
+	if err := r.Start(); err != nil {
+		log.Printf("❌ No se puede iniciar la grabación: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":  "error",
+			"message": fmt.Sprintf("Error al iniciar grabación: %v", err),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "recording",
+		"message": "Grabación iniciada correctamente",
+		"files":   r.Status().Files,
+	})
+}
+
+// handleStopRecording cierra los archivos en curso
+func (r *Recorder) handleStopRecording(c *gin.Context) {
+	r.Stop()
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "stopped",
+		"message": "Grabación detenida correctamente",
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestTrackRecorderUniqueFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec webrtc.RTPCodecCapability
+		ext   string
+	}{
+		{"vp8", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}, ".ivf"},
+		{"h264", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000}, ".h264"},
+		{"opus", webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, ".ogg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRecorder(t.TempDir())
+			if err := r.Start(); err != nil {
+				t.Fatal(err)
+			}
+			track := r.addTrack("pub1", webrtc.RTPCodecParameters{RTPCodecCapability: tt.codec}, nil)
+
+			// Parar y volver a grabar enseguida da otro archivo, no lo trunca
+			var paths []string
+			for i := 0; i < 3; i++ {
+				track.open()
+				paths = append(paths, track.path)
+				track.finish()
+			}
+			seen := make(map[string]bool)
+			for _, path := range paths {
+				if filepath.Ext(path) != tt.ext || seen[path] {
+					t.Errorf("paths = %v", paths)
+					break
+				}
+				seen[path] = true
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// El IVF y el Ogg guardan su cabecera al abrirse
+				if tt.ext != ".h264" && info.Size() == 0 {
+					t.Errorf("%s was truncated", filepath.Base(path))
+				}
+				if _, err := os.Stat(path + recordingMetaExt); err != nil {
+					t.Errorf("missing sidecar: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTrackRecorderRejectsPeerID(t *testing.T) {
+	r := NewRecorder(t.TempDir())
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	codec := webrtc.RTPCodecParameters{RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8, ClockRate: 90000}}
+	track := r.addTrack("../escape", codec, nil)
+	if track.writer != nil || track.path != "" {
+		t.Errorf("recording opened for unsafe peer id: %q", track.path)
+	}
+	entries, _ := os.ReadDir(r.dir)
+	if len(entries) != 0 {
+		t.Errorf("recordings dir has %d entries", len(entries))
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/vp8"
+)
+
+const (
+	// recordingMetaExt y recordingThumbExt son los archivos que acompañan a
+	// cada grabación con sus metadatos y su miniatura
+	recordingMetaExt  = ".json"
+	recordingThumbExt = ".jpg"
+	// recordingThumbWidth es el ancho de las miniaturas
+	recordingThumbWidth = 320
+)
+
+// Eventos que originan una grabación
+const (
+	recordingEventManual     = "manual"
+	recordingEventContinuous = "continuous"
+	recordingEventClip       = "clip"
+	recordingEventMotion     = "motion"
+)
+
+// errRecordingInProgress impide borrar un archivo que se sigue escribiendo
+var errRecordingInProgress = errors.New("recording in progress")
+
+// recordingKinds son los subdirectorios del directorio de datos que
+// forman la biblioteca: tracks WebRTC, grabación continua y clips
+var recordingKinds = []string{"recordings", "segments", "clips"}
+
+// RecordingMeta describe una grabación de la biblioteca. Camera, Event,
+// Codec, Start y End se guardan en un JSON junto al archivo al cerrarlo.
+type RecordingMeta struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Name       string    `json:"name"`
+	Camera     string    `json:"camera"`
+	Event      string    `json:"event"`
+	Codec      string    `json:"codec"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Duration   float64   `json:"duration"`
+	Size       int64     `json:"size"`
+	InProgress bool      `json:"inProgress,omitempty"`
+	URL        string    `json:"url"`
+	Thumbnail  string    `json:"thumbnail,omitempty"`
+}
+
+// recordingSidecar es lo que se guarda en el JSON de cada grabación
+type recordingSidecar struct {
+	Camera string    `json:"camera"`
+	Event  string    `json:"event"`
+	Codec  string    `json:"codec"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+}
+
+// writeRecordingMeta guarda los metadatos de una grabación. Al abrirla
+// se escribe sin final, que se completa al cerrarla.
+func writeRecordingMeta(path, camera, event, codec string, start, end time.Time) {
+	data, err := json.MarshalIndent(recordingSidecar{
+		Camera: camera,
+		Event:  event,
+		Codec:  codec,
+		Start:  start,
+		End:    end,
+	}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path+recordingMetaExt, data, 0644)
+	}
+	if err != nil {
+		log.Printf("⚠️  Error guardando metadatos de %s: %v", filepath.Base(path), err)
+	}
+}
+
+// createRecordingFile crea stem+ext sin pisar nada: si ya existe (dos
+// grabaciones en el mismo segundo o la hora repetida al volver al horario
+// de invierno) prueba stem-2+ext, stem-3+ext...
+func createRecordingFile(stem, ext string) (*os.File, error) {
+	path := stem + ext
+	for i := 2; ; i++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !os.IsExist(err) {
+			return file, err
+		}
+		path = fmt.Sprintf("%s-%d%s", stem, i, ext)
+	}
+}
+
+// recordingStartFromName lee la hora de inicio al final del nombre sin
+// extensión, con o sin el sufijo "-N" de createRecordingFile
+func recordingStartFromName(stem string) (time.Time, bool) {
+	if i := strings.LastIndex(stem, "-"); i >= 0 && len(stem)-i <= 4 {
+		if _, err := strconv.Atoi(stem[i+1:]); err == nil {
+			stem = stem[:i]
+		}
+	}
+	if len(stem) < len(recordingTimeLayout) {
+		return time.Time{}, false
+	}
+	start, err := time.ParseInLocation(recordingTimeLayout, stem[len(stem)-len(recordingTimeLayout):], time.Local)
+	return start, err == nil
+}
+
+// RecordingStore lista, sirve y borra las grabaciones guardadas en disco
+type RecordingStore struct {
+	root string
+	// inProgress indica si algún grabador sigue escribiendo el archivo
+	inProgress []func(path string) bool
+}
+
+func NewRecordingStore(root string) *RecordingStore {
+	return &RecordingStore{root: root}
+}
+
+// recordingCodecFromExt deduce el codec de las grabaciones sin metadatos
+func recordingCodecFromExt(name string) string {
+	switch filepath.Ext(name) {
+	case ".ts", ".h264":
+		return codecH264
+	case ".ivf":
+		return codecVP8
+	case ".ogg":
+		return "audio/opus"
+	case ".mjpeg":
+		return codecJPEG
+	default:
+		return ""
+	}
+}
+
+// recordingContentType es el tipo MIME con el que se sirve cada archivo
+func recordingContentType(name string) string {
+	switch filepath.Ext(name) {
+	case ".ts":
+		return "video/mp2t"
+	case ".h264":
+		return "video/h264"
+	case ".ivf":
+		return "video/x-ivf"
+	case ".ogg":
+		return "audio/ogg"
+	case ".mjpeg":
+		return "video/x-motion-jpeg"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// resolve valida tipo y nombre y devuelve la ruta del archivo
+func (s *RecordingStore) resolve(kind, name string) (string, error) {
+	valid := false
+	for _, k := range recordingKinds {
+		if k == kind {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", fmt.Errorf("unknown recording kind: %s", kind)
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || recordingCodecFromExt(name) == "" {
+		return "", fmt.Errorf("invalid recording name: %s", name)
+	}
+	return filepath.Join(s.root, kind, name), nil
+}
+
+func (s *RecordingStore) isInProgress(path string) bool {
+	for _, check := range s.inProgress {
+		if check(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// meta lee los metadatos de una grabación. Sin JSON (grabaciones en
+// curso o antiguas) el inicio sale del nombre y el final de la fecha de
+// modificación.
+func (s *RecordingStore) meta(kind, name string, info os.FileInfo) RecordingMeta {
+	path := filepath.Join(s.root, kind, name)
+	meta := RecordingMeta{
+		ID:         kind + "/" + name,
+		Kind:       kind,
+		Name:       name,
+		Codec:      recordingCodecFromExt(name),
+		End:        info.ModTime(),
+		Size:       info.Size(),
+		InProgress: s.isInProgress(path),
+		URL:        "/api/recordings/" + kind + "/" + name + "/file",
+	}
+	if meta.Codec != "audio/opus" {
+		meta.Thumbnail = "/api/recordings/" + kind + "/" + name + "/thumbnail"
+	}
+
+	switch kind {
+	case "segments":
+		meta.Event = recordingEventContinuous
+	case "clips":
+		meta.Event = recordingEventClip
+	default:
+		meta.Event = recordingEventManual
+	}
+
+	// Los nombres terminan en la hora de inicio: "<prefijo>_AAAAMMDD-HHMMSS.ext"
+	if start, ok := recordingStartFromName(strings.TrimSuffix(name, filepath.Ext(name))); ok {
+		meta.Start = start
+	}
+	if meta.Start.IsZero() {
+		meta.Start = meta.End
+	}
+
+	if data, err := os.ReadFile(path + recordingMetaExt); err == nil {
+		var sidecar recordingSidecar
+		if err := json.Unmarshal(data, &sidecar); err == nil {
+			meta.Camera = sidecar.Camera
+			if sidecar.Event != "" {
+				meta.Event = sidecar.Event
+			}
+			if sidecar.Codec != "" {
+				meta.Codec = sidecar.Codec
+			}
+			if !sidecar.Start.IsZero() {
+				meta.Start = sidecar.Start
+			}
+			if !sidecar.End.IsZero() {
+				meta.End = sidecar.End
+			}
+		}
+	}
+	if meta.End.After(meta.Start) {
+		meta.Duration = meta.End.Sub(meta.Start).Seconds()
+	}
+	return meta
+}
+
+// List devuelve todas las grabaciones, de la más nueva a la más vieja
+func (s *RecordingStore) List() ([]RecordingMeta, error) {
+	recordings := []RecordingMeta{}
+	for _, kind := range recordingKinds {
+		entries, err := os.ReadDir(filepath.Join(s.root, kind))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasPrefix(name, ".") || recordingCodecFromExt(name) == "" {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			recordings = append(recordings, s.meta(kind, name, info))
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].Start.After(recordings[j].Start)
+	})
+	return recordings, nil
+}
+
+// Get devuelve los metadatos de una grabación
+func (s *RecordingStore) Get(kind, name string) (RecordingMeta, error) {
+	path, err := s.resolve(kind, name)
+	if err != nil {
+		return RecordingMeta{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return RecordingMeta{}, err
+	}
+	return s.meta(kind, name, info), nil
+}
+
+// Delete borra la grabación junto con sus metadatos y miniatura
+func (s *RecordingStore) Delete(kind, name string) error {
+	path, err := s.resolve(kind, name)
+	if err != nil {
+		return err
+	}
+	if s.isInProgress(path) {
+		return errRecordingInProgress
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	os.Remove(path + recordingMetaExt)
+	os.Remove(path + recordingThumbExt)
+	log.Printf("🗑️  Grabación borrada: %s/%s", kind, name)
+	return nil
+}
+
+// Thumbnail devuelve la miniatura JPEG, generándola y guardándola la
+// primera vez a partir del primer frame de la grabación
+func (s *RecordingStore) Thumbnail(kind, name string) ([]byte, error) {
+	path, err := s.resolve(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := os.ReadFile(path + recordingThumbExt); err == nil {
+		return data, nil
+	}
+
+	var img image.Image
+	switch filepath.Ext(name) {
+	case ".mjpeg":
+		img, err = firstMJPEGFrame(path)
+	case ".ivf":
+		img, err = firstIVFFrame(path)
+	case ".ts", ".h264":
+		var data []byte
+		if data, err = extractVideoThumbnail(path, recordingThumbWidth); err == nil {
+			if !s.isInProgress(path) {
+				os.WriteFile(path+recordingThumbExt, data, 0644)
+			}
+			return data, nil
+		}
+	default:
+		return nil, fmt.Errorf("recording has no video")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := encodeThumbnail(img, recordingThumbWidth)
+	if err != nil {
+		return nil, err
+	}
+	if !s.isInProgress(path) {
+		os.WriteFile(path+recordingThumbExt, data, 0644)
+	}
+	return data, nil
+}
+
+// firstMJPEGFrame decodifica el primer JPEG de un clip MJPEG
+func firstMJPEGFrame(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return jpeg.Decode(bufio.NewReader(file))
+}
+
+// firstIVFFrame decodifica el primer frame VP8 de un IVF (siempre keyframe)
+func firstIVFFrame(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Cabecera de 32 bytes y cabecera de frame de 12 (tamaño + timestamp)
+	var header [44]byte
+	if _, err := io.ReadFull(file, header[:]); err != nil {
+		return nil, fmt.Errorf("ivf header read failed: %v", err)
+	}
+	if string(header[:4]) != "DKIF" {
+		return nil, fmt.Errorf("not an ivf file")
+	}
+	size := binary.LittleEndian.Uint32(header[32:36])
+	if size == 0 || size > 16*1024*1024 {
+		return nil, fmt.Errorf("invalid ivf frame size: %d", size)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(file, frame); err != nil {
+		return nil, fmt.Errorf("ivf frame read failed: %v", err)
+	}
+
+	decoder := vp8.NewDecoder()
+	decoder.Init(bytes.NewReader(frame), len(frame))
+	if _, err := decoder.DecodeFrameHeader(); err != nil {
+		return nil, fmt.Errorf("vp8 header decode failed: %v", err)
+	}
+	return decoder.DecodeFrame()
+}
+
+// encodeThumbnail reduce la imagen al ancho dado y la codifica en JPEG
+func encodeThumbnail(img image.Image, width int) ([]byte, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() > width {
+		height := bounds.Dy() * width / bounds.Dx()
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		img = scaled
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, fmt.Errorf("jpeg encode failed: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseRecordingTime acepta RFC 3339 o una fecha AAAA-MM-DD (hora local)
+func parseRecordingTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// handleListRecordings lista la biblioteca con filtros opcionales:
+// from/to (RFC 3339 o AAAA-MM-DD), date, camera, event, kind y limit
+func (s *RecordingStore) handleListRecordings(c *gin.Context) {
+	var from, to time.Time
+	if value := c.Query("date"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("fecha inválida: %s", value)})
+			return
+		}
+		from, to = day, day.AddDate(0, 0, 1)
+	}
+	if value := c.Query("from"); value != "" {
+		t, err := parseRecordingTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("from inválido: %s", value)})
+			return
+		}
+		from = t
+	}
+	if value := c.Query("to"); value != "" {
+		t, err := parseRecordingTime(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("to inválido: %s", value)})
+			return
+		}
+		to = t
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit inválido: %s", value)})
+			return
+		}
+		limit = n
+	}
+	camera, event, kind := c.Query("camera"), c.Query("event"), c.Query("kind")
+
+	recordings, err := s.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filtered := []RecordingMeta{}
+	var total int64
+	for _, recording := range recordings {
+		// Una grabación entra en el rango si se solapa con él
+		if !from.IsZero() && recording.End.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !recording.Start.Before(to) {
+			continue
+		}
+		if camera != "" && !strings.EqualFold(recording.Camera, camera) {
+			continue
+		}
+		if event != "" && recording.Event != event {
+			continue
+		}
+		if kind != "" && recording.Kind != kind {
+			continue
+		}
+		total += recording.Size
+		filtered = append(filtered, recording)
+	}
+	count := len(filtered)
+	if limit > 0 && len(filtered) > limit {
+		filtered = filtered[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":      count,
+		"totalBytes": total,
+		"recordings": filtered,
+	})
+}
+
+// recordingError traduce los errores del almacén a códigos HTTP
+func recordingError(c *gin.Context, err error) {
+	switch {
+	case os.IsNotExist(err):
+		c.JSON(http.StatusNotFound, gin.H{"error": "recording not found"})
+	case errors.Is(err, errRecordingInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// handleGetRecording devuelve los metadatos de una grabación
+func (s *RecordingStore) handleGetRecording(c *gin.Context) {
+	meta, err := s.Get(c.Param("kind"), c.Param("name"))
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, meta)
+}
+
+// handleRecordingFile sirve el archivo con soporte de Range para buscar
+func (s *RecordingStore) handleRecordingFile(c *gin.Context) {
+	path, err := s.resolve(c.Param("kind"), c.Param("name"))
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		recordingError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", recordingContentType(path))
+	if c.Query("download") != "" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	}
+	http.ServeContent(c.Writer, c.Request, filepath.Base(path), info.ModTime(), file)
+}
+
+// handleRecordingThumbnail sirve la miniatura JPEG de una grabación
+func (s *RecordingStore) handleRecordingThumbnail(c *gin.Context) {
+	if _, err := s.Get(c.Param("kind"), c.Param("name")); err != nil {
+		recordingError(c, err)
+		return
+	}
+	data, err := s.Thumbnail(c.Param("kind"), c.Param("name"))
+	if err != nil {
+		log.Printf("⚠️  Sin miniatura para %s/%s: %v", c.Param("kind"), c.Param("name"), err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "max-age=3600")
+	c.Data(http.StatusOK, "image/jpeg", data)
+}
+
+// handleDeleteRecording borra una grabación que no esté en curso
+func (s *RecordingStore) handleDeleteRecording(c *gin.Context) {
+	if err := s.Delete(c.Param("kind"), c.Param("name")); err != nil {
+		recordingError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
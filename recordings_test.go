@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordingStartFromName(t *testing.T) {
+	want := time.Date(2024, 3, 15, 10, 30, 15, 0, time.Local)
+	tests := []struct {
+		stem string
+		ok   bool
+	}{
+		{"20240315-103015", true},
+		{"clip_20240315-103015", true},
+		{"peer-abc_20240315-103015", true},
+		{"20240315-103015-2", true},
+		{"clip_20240315-103015-12", true},
+		{"timelapse_patio", false},
+		{"20240315", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		got, ok := recordingStartFromName(tt.stem)
+		if ok != tt.ok || (ok && !got.Equal(want)) {
+			t.Errorf("recordingStartFromName(%q) = %v, %v; want ok=%v", tt.stem, got, ok, tt.ok)
+		}
+	}
+}
+
+func TestCreateRecordingFile(t *testing.T) {
+	stem := filepath.Join(t.TempDir(), "20240315-103015")
+	var names []string
+	for i := 0; i < 3; i++ {
+		file, err := createRecordingFile(stem, ".ts")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString("segment")
+		file.Close()
+		names = append(names, filepath.Base(file.Name()))
+	}
+	want := []string{"20240315-103015.ts", "20240315-103015-2.ts", "20240315-103015-3.ts"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("file %d = %s, want %s", i, names[i], want[i])
+		}
+	}
+	// El primer archivo no se trunca al crear los siguientes
+	if data, _ := os.ReadFile(stem + ".ts"); string(data) != "segment" {
+		t.Errorf("first file was overwritten: %q", data)
+	}
+}
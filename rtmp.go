@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Tipos de mensaje RTMP usados
+const (
+	rtmpMsgSetChunkSize  = 1
+	rtmpMsgAck           = 3
+	rtmpMsgUserControl   = 4
+	rtmpMsgWindowAckSize = 5
+	rtmpMsgPeerBandwidth = 6
+	rtmpMsgVideo         = 9
+	rtmpMsgDataAMF0      = 18
+	rtmpMsgCommandAMF0   = 20
+)
+
+const (
+	// rtmpHandshakeSize es el tamaño de C1/S1/C2/S2
+	rtmpHandshakeSize = 1536
+	// rtmpOutChunkSize es el tamaño de chunk que anunciamos al servidor
+	rtmpOutChunkSize = 4096
+	// Chunk streams de salida: control, comandos y video
+	rtmpChunkControl = 2
+	rtmpChunkCommand = 3
+	rtmpChunkVideo   = 6
+)
+
+// rtmpConn es una conexión RTMP con lectura y escritura de chunks
+type rtmpConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	writeMutex  sync.Mutex
+	inChunkSize int
+	inbound     map[uint32]*rtmpChunkState
+	bytesSent   uint64
+}
+
+// rtmpChunkState recuerda la última cabecera de cada chunk stream entrante
+type rtmpChunkState struct {
+	length   int
+	msgType  byte
+	streamID uint32
+	extended bool
+	payload  []byte
+}
+
+// rtmpMessage es un mensaje RTMP completo recibido
+type rtmpMessage struct {
+	msgType  byte
+	streamID uint32
+	payload  []byte
+}
+
+// dialRTMP conecta con el servidor y hace el handshake simple
+func dialRTMP(host string, timeout time.Duration) (*rtmpConn, error) {
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &rtmpConn{
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		inChunkSize: 128,
+		inbound:     make(map[uint32]*rtmpChunkState),
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// C0 + C1: versión 3, timestamp, ceros y bytes aleatorios
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	c0c1[0] = 3
+	if _, err := rand.Read(c0c1[9:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.Write(c0c1); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// S0 + S1 + S2; C2 es el eco de S1
+	s0s1s2 := make([]byte, 1+2*rtmpHandshakeSize)
+	if _, err := io.ReadFull(c.reader, s0s1s2); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("rtmp handshake failed: %v", err)
+	}
+	if s0s1s2[0] != 3 {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported rtmp version %d", s0s1s2[0])
+	}
+	if _, err := conn.Write(s0s1s2[1 : 1+rtmpHandshakeSize]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Chunks grandes para no trocear cada frame en pedazos de 128 bytes
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, rtmpOutChunkSize)
+	if err := c.writeMessage(rtmpChunkControl, rtmpMsgSetChunkSize, 0, 0, size); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *rtmpConn) Close() error {
+	return c.conn.Close()
+}
+
+// writeMessage trocea un mensaje en chunks (cabecera tipo 0 y tipo 3)
+func (c *rtmpConn) writeMessage(csid byte, msgType byte, streamID uint32, timestamp uint32, payload []byte) error {
+	extended := timestamp >= 0xFFFFFF
+	headerTimestamp := timestamp
+	if extended {
+		headerTimestamp = 0xFFFFFF
+	}
+
+	buf := make([]byte, 0, len(payload)+16+len(payload)/rtmpOutChunkSize*5)
+	buf = append(buf, csid&0x3F,
+		byte(headerTimestamp>>16), byte(headerTimestamp>>8), byte(headerTimestamp),
+		byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload)),
+		msgType)
+	buf = binary.LittleEndian.AppendUint32(buf, streamID)
+	if extended {
+		buf = binary.BigEndian.AppendUint32(buf, timestamp)
+	}
+
+	for offset := 0; offset < len(payload); offset += rtmpOutChunkSize {
+		if offset > 0 {
+			buf = append(buf, 0xC0|csid&0x3F)
+			if extended {
+				buf = binary.BigEndian.AppendUint32(buf, timestamp)
+			}
+		}
+		end := min(offset+rtmpOutChunkSize, len(payload))
+		buf = append(buf, payload[offset:end]...)
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	n, err := c.conn.Write(buf)
+	c.bytesSent += uint64(n)
+	return err
+}
+
+// sentBytes devuelve los bytes escritos en la conexión
+func (c *rtmpConn) sentBytes() uint64 {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.bytesSent
+}
+
+// writeCommand envía un comando AMF0
+func (c *rtmpConn) writeCommand(streamID uint32, values ...interface{}) error {
+	return c.writeMessage(rtmpChunkCommand, rtmpMsgCommandAMF0, streamID, 0, encodeAMF0(values...))
+}
+
+// readMessage lee chunks hasta completar un mensaje. Los mensajes de
+// control de protocolo (tamaño de chunk, ping) se atienden aquí.
+func (c *rtmpConn) readMessage() (*rtmpMessage, error) {
+	for {
+		msg, err := c.readChunk()
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			continue
+		}
+
+		switch msg.msgType {
+		case rtmpMsgSetChunkSize:
+			if len(msg.payload) >= 4 {
+				c.inChunkSize = int(binary.BigEndian.Uint32(msg.payload) & 0x7FFFFFFF)
+			}
+			continue
+		case rtmpMsgUserControl:
+			// Ping request (6) -> ping response (7) con el mismo timestamp
+			if len(msg.payload) >= 6 && binary.BigEndian.Uint16(msg.payload) == 6 {
+				pong := append([]byte{0, 7}, msg.payload[2:6]...)
+				if err := c.writeMessage(rtmpChunkControl, rtmpMsgUserControl, 0, 0, pong); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		case rtmpMsgAck, rtmpMsgWindowAckSize, rtmpMsgPeerBandwidth:
+			continue
+		}
+		return msg, nil
+	}
+}
+
+// readChunk lee un chunk y devuelve el mensaje si queda completo
+func (c *rtmpConn) readChunk() (*rtmpMessage, error) {
+	b0, err := c.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	format := b0 >> 6
+	csid := uint32(b0 & 0x3F)
+	switch csid {
+	case 0:
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(b)
+	case 1:
+		var b [2]byte
+		if _, err := io.ReadFull(c.reader, b[:]); err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(b[0]) + uint32(b[1])*256
+	}
+
+	state := c.inbound[csid]
+	if state == nil {
+		state = &rtmpChunkState{}
+		c.inbound[csid] = state
+	}
+
+	headerSize := [4]int{11, 7, 3, 0}[format]
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+	if format <= 2 {
+		state.extended = uint32(header[0])<<16|uint32(header[1])<<8|uint32(header[2]) == 0xFFFFFF
+	}
+	if format <= 1 {
+		state.length = int(header[3])<<16 | int(header[4])<<8 | int(header[5])
+		state.msgType = header[6]
+	}
+	if format == 0 {
+		state.streamID = binary.LittleEndian.Uint32(header[7:11])
+	}
+	if state.extended {
+		var ext [4]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	remaining := state.length - len(state.payload)
+	n := min(remaining, c.inChunkSize)
+	if n > 0 {
+		data := make([]byte, n)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		state.payload = append(state.payload, data...)
+	}
+
+	if len(state.payload) < state.length {
+		return nil, nil
+	}
+	msg := &rtmpMessage{msgType: state.msgType, streamID: state.streamID, payload: state.payload}
+	state.payload = nil
+	return msg, nil
+}
+
+// amf0Object es un objeto AMF0 con las claves en orden
+type amf0Object []amf0Property
+
+type amf0Property struct {
+	key   string
+	value interface{}
+}
+
+// get devuelve el valor de una clave del objeto
+func (o amf0Object) get(key string) interface{} {
+	for _, property := range o {
+		if property.key == key {
+			return property.value
+		}
+	}
+	return nil
+}
+
+// encodeAMF0 serializa números, strings, booleanos, nil y objetos
+func encodeAMF0(values ...interface{}) []byte {
+	var buf []byte
+	for _, value := range values {
+		buf = appendAMF0(buf, value)
+	}
+	return buf
+}
+
+func appendAMF0(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case float64:
+		buf = append(buf, 0x00)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+	case int:
+		return appendAMF0(buf, float64(v))
+	case bool:
+		if v {
+			return append(buf, 0x01, 1)
+		}
+		return append(buf, 0x01, 0)
+	case string:
+		buf = append(buf, 0x02)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+		return append(buf, v...)
+	case amf0Object:
+		buf = append(buf, 0x03)
+		for _, property := range v {
+			buf = binary.BigEndian.AppendUint16(buf, uint16(len(property.key)))
+			buf = append(buf, property.key...)
+			buf = appendAMF0(buf, property.value)
+		}
+		return append(buf, 0, 0, 0x09)
+	default:
+		return append(buf, 0x05) // null
+	}
+}
+
+// decodeAMF0 interpreta todos los valores de un comando
+func decodeAMF0(data []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(data) > 0 {
+		value, rest, err := decodeAMF0Value(data)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+		data = rest
+	}
+	return values, nil
+}
+
+func decodeAMF0Value(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("amf0: unexpected end")
+	}
+	marker, data := data[0], data[1:]
+	switch marker {
+	case 0x00:
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("amf0: short number")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	case 0x01:
+		if len(data) < 1 {
+			return nil, nil, fmt.Errorf("amf0: short boolean")
+		}
+		return data[0] != 0, data[1:], nil
+	case 0x02:
+		return decodeAMF0String(data)
+	case 0x03, 0x08:
+		if marker == 0x08 {
+			if len(data) < 4 {
+				return nil, nil, fmt.Errorf("amf0: short array")
+			}
+			data = data[4:]
+		}
+		var object amf0Object
+		for {
+			if len(data) >= 3 && data[0] == 0 && data[1] == 0 && data[2] == 0x09 {
+				return object, data[3:], nil
+			}
+			key, rest, err := decodeAMF0String(data)
+			if err != nil {
+				return nil, nil, err
+			}
+			value, rest, err := decodeAMF0Value(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			object = append(object, amf0Property{key: key.(string), value: value})
+			data = rest
+		}
+	case 0x05, 0x06:
+		return nil, data, nil
+	default:
+		return nil, nil, fmt.Errorf("amf0: unsupported marker 0x%02x", marker)
+	}
+}
+
+func decodeAMF0String(data []byte) (interface{}, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, fmt.Errorf("amf0: short string")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return nil, nil, fmt.Errorf("amf0: short string")
+	}
+	return string(data[2 : 2+n]), data[2+n:], nil
+}
+
+// flvAVCSequenceHeader construye el AVCDecoderConfigurationRecord en un tag de video
+func flvAVCSequenceHeader(sps, pps []byte) []byte {
+	tag := []byte{0x17, 0x00, 0, 0, 0}
+	tag = append(tag, 0x01, sps[1], sps[2], sps[3], 0xFF, 0xE1)
+	tag = binary.BigEndian.AppendUint16(tag, uint16(len(sps)))
+	tag = append(tag, sps...)
+	tag = append(tag, 0x01)
+	tag = binary.BigEndian.AppendUint16(tag, uint16(len(pps)))
+	return append(tag, pps...)
+}
+
+// flvAVCFrame convierte un access unit Annex-B en un tag de video AVC con
+// las unidades NAL prefijadas por su longitud
+func flvAVCFrame(annexB []byte, keyframe bool) []byte {
+	frameType := byte(0x27)
+	if keyframe {
+		frameType = 0x17
+	}
+	tag := []byte{frameType, 0x01, 0, 0, 0}
+	for _, nalu := range splitAnnexB(annexB) {
+		switch h264NALType(nalu) {
+		case h264NALAUD, h264NALSPS, h264NALPPS:
+			// Los parámetros van en el sequence header
+			continue
+		}
+		tag = binary.BigEndian.AppendUint32(tag, uint32(len(nalu)))
+		tag = append(tag, nalu...)
+	}
+	return tag
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// rtmpDialTimeout limita la conexión y cada respuesta del servidor RTMP
+	rtmpDialTimeout = 10 * time.Second
+	// rtmpMaxBackoff es la espera máxima entre reconexiones
+	rtmpMaxBackoff = 60 * time.Second
+	// rtmpBuffer es cuántos frames puede acumular el envío
+	rtmpBuffer = 60
+)
+
+// Estados del publicador RTMP
+const (
+	rtmpStateIdle       = "idle"
+	rtmpStateConnecting = "connecting"
+	rtmpStatePublishing = "publishing"
+	rtmpStateRetrying   = "retrying"
+)
+
+// RTMPPublisher empuja el video H.264 de la cámara a un servidor RTMP
+// (nginx-rtmp, MediaMTX...) como FLV, reconectando con espera creciente.
+// Solo hay video: el pipeline de la cámara no transporta audio.
+type RTMPPublisher struct {
+	video           func() *FrameBroker
+	requestKeyframe func()
+	events          *EventBus
+
+	mutex  sync.Mutex
+	url    string
+	stop   chan struct{}
+	done   chan struct{}
+	status RTMPStatus
+	conn   *rtmpConn
+}
+
+// RTMPStatus describe el estado del envío RTMP para la API
+type RTMPStatus struct {
+	URL        string    `json:"url,omitempty"`
+	Enabled    bool      `json:"enabled"`
+	State      string    `json:"state"`
+	Error      string    `json:"error,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+	BytesSent  uint64    `json:"bytesSent"`
+	Reconnects int       `json:"reconnects"`
+}
+
+// RTMPConfig es el cuerpo de POST /api/rtmp
+type RTMPConfig struct {
+	URL     string `json:"url"`
+	Enabled bool   `json:"enabled"`
+}
+
+func NewRTMPPublisher(video func() *FrameBroker) *RTMPPublisher {
+	return &RTMPPublisher{
+		video:  video,
+		status: RTMPStatus{State: rtmpStateIdle},
+	}
+}
+
+// Configure cambia el destino; con enabled=false detiene el envío
+func (p *RTMPPublisher) Configure(rawURL string, enabled bool) error {
+	if enabled {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Scheme != "rtmp" || parsed.Host == "" || strings.Trim(parsed.Path, "/") == "" {
+			return fmt.Errorf("invalid rtmp url (expected rtmp://host[:port]/app/stream): %s", rawURL)
+		}
+	}
+
+	p.stopPublishing()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.url = rawURL
+	p.status = RTMPStatus{State: rtmpStateIdle, Enabled: enabled}
+	if !enabled {
+		return nil
+	}
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run(rawURL, p.stop, p.done)
+	return nil
+}
+
+// stopPublishing detiene el envío en curso y espera a que termine
+func (p *RTMPPublisher) stopPublishing() {
+	p.mutex.Lock()
+	stop := p.stop
+	done := p.done
+	conn := p.conn
+	p.stop = nil
+	p.done = nil
+	p.mutex.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	if conn != nil {
+		conn.Close()
+	}
+	<-done
+}
+
+// Status devuelve una copia del estado con la clave del stream oculta
+func (p *RTMPPublisher) Status() RTMPStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := p.status
+	status.URL = maskRTMPURL(p.url)
+	if p.conn != nil {
+		status.BytesSent += p.conn.sentBytes()
+	}
+	return status
+}
+
+func (p *RTMPPublisher) setState(state string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.status.State != state {
+		p.status.Since = time.Now()
+	}
+	p.status.State = state
+	p.status.Error = ""
+	if err != nil {
+		p.status.Error = err.Error()
+	}
+}
+
+// run publica hasta que se pide parar, reintentando tras cada fallo
+func (p *RTMPPublisher) run(rawURL string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	backoff := time.Second
+	for {
+		p.setState(rtmpStateConnecting, nil)
+		published, err := p.publish(rawURL, stop)
+
+		select {
+		case <-stop:
+			p.setState(rtmpStateIdle, nil)
+			return
+		default:
+		}
+
+		if published {
+			backoff = time.Second
+		}
+		log.Printf("⚠️  Envío RTMP interrumpido: %v (reintento en %s)", err, backoff)
+		p.events.Publish(eventRTMPFailed, gin.H{"url": maskRTMPURL(rawURL), "error": err.Error(), "retry": backoff.Seconds()})
+		p.setState(rtmpStateRetrying, err)
+		p.mutex.Lock()
+		p.status.Reconnects++
+		p.mutex.Unlock()
+
+		select {
+		case <-stop:
+			p.setState(rtmpStateIdle, nil)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > rtmpMaxBackoff {
+			backoff = rtmpMaxBackoff
+		}
+	}
+}
+
+// publish abre la sesión RTMP y envía video hasta un error o la parada.
+// Devuelve si se llegó a publicar.
+func (p *RTMPPublisher) publish(rawURL string, stop <-chan struct{}) (bool, error) {
+	broker := p.video()
+	if broker == nil {
+		return false, fmt.Errorf("no encoded video available (start the camera; JPEG sources need ffmpeg)")
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return false, err
+	}
+	host := target.Host
+	if target.Port() == "" {
+		host = net.JoinHostPort(target.Hostname(), "1935")
+	}
+	// rtmp://host/app/stream: la aplicación es el primer segmento de la ruta
+	app, streamKey, _ := strings.Cut(strings.Trim(target.Path, "/"), "/")
+	if target.RawQuery != "" {
+		streamKey += "?" + target.RawQuery
+	}
+	tcURL := fmt.Sprintf("rtmp://%s/%s", target.Host, app)
+
+	conn, err := dialRTMP(host, rtmpDialTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	p.mutex.Lock()
+	p.conn = conn
+	p.mutex.Unlock()
+	defer func() {
+		p.mutex.Lock()
+		p.status.BytesSent += conn.sentBytes()
+		p.conn = nil
+		p.mutex.Unlock()
+	}()
+
+	streamID, err := rtmpHandshakeCommands(conn, app, streamKey, tcURL)
+	if err != nil {
+		return false, err
+	}
+
+	// Leer del servidor en segundo plano: pings y errores de publicación
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := conn.readMessage()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if msg.msgType == rtmpMsgCommandAMF0 {
+				if values, _ := decodeAMF0(msg.payload); len(values) > 0 && values[0] == "onStatus" {
+					if info, ok := values[len(values)-1].(amf0Object); ok && info.get("level") == "error" {
+						readErr <- fmt.Errorf("rtmp server error: %v", info.get("code"))
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	sub := broker.Subscribe(rtmpBuffer)
+	defer sub.Close()
+	if p.requestKeyframe != nil {
+		p.requestKeyframe()
+	}
+
+	log.Printf("📤 Envío RTMP iniciado: %s", maskRTMPURL(rawURL))
+	p.setState(rtmpStatePublishing, nil)
+	p.events.Publish(eventRTMPConnected, gin.H{"url": maskRTMPURL(rawURL)})
+
+	var params h264ParameterSets
+	var sentSPS, sentPPS []byte
+	var start time.Time
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame := <-sub.C:
+			if frame.Codec != codecH264 {
+				return false, fmt.Errorf("rtmp output needs H.264, got %s", frame.Codec)
+			}
+			params.update(splitAnnexB(frame.Data))
+			if !params.ready() {
+				continue
+			}
+			if start.IsZero() {
+				start = frame.Timestamp
+				metadata := encodeAMF0("@setDataFrame", "onMetaData", amf0Object{
+					{key: "videocodecid", value: 7},
+					{key: "encoder", value: "alien-cam"},
+				})
+				if err := conn.writeMessage(rtmpChunkCommand, rtmpMsgDataAMF0, streamID, 0, metadata); err != nil {
+					return true, err
+				}
+			}
+			timestamp := uint32(frame.Timestamp.Sub(start) / time.Millisecond)
+
+			// El sequence header se reenvía si cambian los SPS/PPS
+			if !bytes.Equal(sentSPS, params.sps) || !bytes.Equal(sentPPS, params.pps) {
+				if len(params.sps) < 4 {
+					continue
+				}
+				header := flvAVCSequenceHeader(params.sps, params.pps)
+				if err := conn.writeMessage(rtmpChunkVideo, rtmpMsgVideo, streamID, timestamp, header); err != nil {
+					return true, err
+				}
+				sentSPS, sentPPS = params.sps, params.pps
+			}
+
+			tag := flvAVCFrame(frame.Data, frame.Keyframe)
+			if err := conn.writeMessage(rtmpChunkVideo, rtmpMsgVideo, streamID, timestamp, tag); err != nil {
+				return true, err
+			}
+		case err := <-readErr:
+			return true, err
+		case <-ticker.C:
+			if p.video() != broker {
+				return true, fmt.Errorf("video source changed")
+			}
+		case <-stop:
+			conn.writeCommand(streamID, "deleteStream", 0, nil, float64(streamID))
+			log.Println("⏹️  Envío RTMP detenido")
+			return true, nil
+		}
+	}
+}
+
+// rtmpHandshakeCommands hace connect, createStream y publish y devuelve el
+// message stream ID asignado
+func rtmpHandshakeCommands(conn *rtmpConn, app, streamKey, tcURL string) (uint32, error) {
+	conn.conn.SetReadDeadline(time.Now().Add(rtmpDialTimeout))
+	defer conn.conn.SetReadDeadline(time.Time{})
+
+	err := conn.writeCommand(0, "connect", 1, amf0Object{
+		{key: "app", value: app},
+		{key: "type", value: "nonprivate"},
+		{key: "flashVer", value: "FMLE/3.0 (compatible; alien-cam)"},
+		{key: "tcUrl", value: tcURL},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := waitRTMPResult(conn, 1); err != nil {
+		return 0, fmt.Errorf("connect: %w", err)
+	}
+
+	conn.writeCommand(0, "releaseStream", 2, nil, streamKey)
+	conn.writeCommand(0, "FCPublish", 3, nil, streamKey)
+	if err := conn.writeCommand(0, "createStream", 4, nil); err != nil {
+		return 0, err
+	}
+	result, err := waitRTMPResult(conn, 4)
+	if err != nil {
+		return 0, fmt.Errorf("createStream: %w", err)
+	}
+	id, ok := result[len(result)-1].(float64)
+	if !ok {
+		return 0, fmt.Errorf("createStream: missing stream id")
+	}
+	streamID := uint32(id)
+
+	if err := conn.writeCommand(streamID, "publish", 5, nil, streamKey, "live"); err != nil {
+		return 0, err
+	}
+	for {
+		msg, err := conn.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		if msg.msgType != rtmpMsgCommandAMF0 {
+			continue
+		}
+		values, _ := decodeAMF0(msg.payload)
+		if len(values) == 0 || values[0] != "onStatus" {
+			continue
+		}
+		info, _ := values[len(values)-1].(amf0Object)
+		code, _ := info.get("code").(string)
+		if code == "NetStream.Publish.Start" {
+			return streamID, nil
+		}
+		if info.get("level") == "error" {
+			return 0, fmt.Errorf("publish rejected: %s", code)
+		}
+	}
+}
+
+// waitRTMPResult espera el _result (o _error) de la transacción dada
+func waitRTMPResult(conn *rtmpConn, transaction float64) ([]interface{}, error) {
+	for {
+		msg, err := conn.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if msg.msgType != rtmpMsgCommandAMF0 {
+			continue
+		}
+		values, err := decodeAMF0(msg.payload)
+		if err != nil || len(values) < 2 || values[1] != transaction {
+			continue
+		}
+		switch values[0] {
+		case "_result":
+			return values, nil
+		case "_error":
+			if info, ok := values[len(values)-1].(amf0Object); ok {
+				return nil, fmt.Errorf("%v", info.get("code"))
+			}
+			return nil, fmt.Errorf("rejected by server")
+		}
+	}
+}
+
+// maskRTMPURL oculta la clave del stream para mostrarla en la API y logs
+func maskRTMPURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || rawURL == "" {
+		return ""
+	}
+	app, key, found := strings.Cut(strings.Trim(parsed.Path, "/"), "/")
+	if found && key != "" {
+		key = "****"
+	}
+	masked := fmt.Sprintf("%s://%s/%s", parsed.Scheme, parsed.Host, app)
+	if found {
+		masked += "/" + key
+	}
+	return masked
+}
+
+// handleRTMPStatus devuelve el estado del envío RTMP
+func (p *RTMPPublisher) handleRTMPStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, p.Status())
+}
+
+// handleRTMPConfig activa, cambia o detiene el envío RTMP
+func (p *RTMPPublisher) handleRTMPConfig(c *gin.Context) {
+	var config RTMPConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+	if err := p.Configure(config.URL, config.Enabled); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": err.Error()})
+		return
+	}
+	log.Printf("📤 Configuración RTMP actualizada: %s (activo: %v)", maskRTMPURL(config.URL), config.Enabled)
+	c.JSON(http.StatusOK, p.Status())
+}
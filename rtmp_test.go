@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeAMF0(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"number", 1.0, "00 3ff0000000000000"},
+		{"int", 4, "00 4010000000000000"},
+		{"true", true, "01 01"},
+		{"false", false, "01 00"},
+		{"string", "connect", "02 0007 636f6e6e656374"},
+		{"empty string", "", "02 0000"},
+		{"null", nil, "05"},
+		{"object", amf0Object{{key: "app", value: "live"}, {key: "videocodecid", value: 7}},
+			"03 0003 617070 02 0004 6c697665 000c 766964656f636f6465636964 00 401c000000000000 000009"},
+		{"empty object", amf0Object{}, "03 000009"},
+	}
+	for _, tt := range tests {
+		want, err := hex.DecodeString(string(bytes.ReplaceAll([]byte(tt.want), []byte(" "), nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := encodeAMF0(tt.value); !bytes.Equal(got, want) {
+			t.Errorf("%s: encodeAMF0(%v) = % x, want % x", tt.name, tt.value, got, want)
+		}
+	}
+}
+
+func TestDecodeAMF0(t *testing.T) {
+	command := encodeAMF0("_result", 1, amf0Object{{key: "level", value: "status"}}, nil, 5.5, false)
+	values, err := decodeAMF0(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"_result", 1.0, amf0Object{{key: "level", value: "status"}}, nil, 5.5, false}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("decodeAMF0 = %#v, want %#v", values, want)
+	}
+
+	// ECMA array (0x08) con su contador y undefined (0x06)
+	array := []byte{0x08, 0, 0, 0, 1, 0, 1, 'a', 0x06, 0, 0, 0x09}
+	values, err = decodeAMF0(array)
+	if err != nil || !reflect.DeepEqual(values, []interface{}{amf0Object{{key: "a", value: nil}}}) {
+		t.Errorf("decodeAMF0(ecma array) = %#v, %v", values, err)
+	}
+
+	for _, data := range [][]byte{
+		{0x00, 0x3f},
+		{0x01},
+		{0x02, 0x00, 0x05, 'a'},
+		{0x03, 0x00, 0x01, 'a'},
+		{0x0B},
+	} {
+		if _, err := decodeAMF0(data); err == nil {
+			t.Errorf("decodeAMF0(% x): expected error", data)
+		}
+	}
+}
+
+// captureRTMPMessage devuelve los bytes que writeMessage pone en la conexión
+func captureRTMPMessage(t *testing.T, csid, msgType byte, streamID, timestamp uint32, payload []byte) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	written := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(server)
+		written <- data
+	}()
+	c := &rtmpConn{conn: client}
+	if err := c.writeMessage(csid, msgType, streamID, timestamp, payload); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	data := <-written
+	if c.sentBytes() != uint64(len(data)) {
+		t.Errorf("sentBytes = %d, wrote %d", c.sentBytes(), len(data))
+	}
+	return data
+}
+
+func TestRTMPWriteMessage(t *testing.T) {
+	payload := make([]byte, 2*rtmpOutChunkSize+100)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	tests := []struct {
+		name      string
+		timestamp uint32
+		header    []byte
+		// continuation es la cabecera de cada chunk tipo 3
+		continuation []byte
+	}{
+		{
+			"short timestamp", 1000,
+			[]byte{0x06, 0x00, 0x03, 0xE8, 0x00, 0x20, 0x64, rtmpMsgVideo, 1, 0, 0, 0},
+			[]byte{0xC6},
+		},
+		{
+			"extended timestamp", 0x01000000,
+			[]byte{0x06, 0xFF, 0xFF, 0xFF, 0x00, 0x20, 0x64, rtmpMsgVideo, 1, 0, 0, 0, 0x01, 0, 0, 0},
+			[]byte{0xC6, 0x01, 0, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := captureRTMPMessage(t, rtmpChunkVideo, rtmpMsgVideo, 1, tt.timestamp, payload)
+			if !bytes.HasPrefix(data, tt.header) {
+				t.Fatalf("header = % x, want % x", data[:len(tt.header)], tt.header)
+			}
+			data = data[len(tt.header):]
+
+			// Tres chunks: dos llenos y el resto, separados por cabeceras tipo 3
+			var got []byte
+			for i, size := range []int{rtmpOutChunkSize, rtmpOutChunkSize, 100} {
+				if i > 0 {
+					if !bytes.HasPrefix(data, tt.continuation) {
+						t.Fatalf("chunk %d header = % x, want % x", i, data[:len(tt.continuation)], tt.continuation)
+					}
+					data = data[len(tt.continuation):]
+				}
+				if len(data) < size {
+					t.Fatalf("chunk %d: %d bytes left, want %d", i, len(data), size)
+				}
+				got = append(got, data[:size]...)
+				data = data[size:]
+			}
+			if len(data) != 0 || !bytes.Equal(got, payload) {
+				t.Errorf("payload mismatch (%d trailing bytes)", len(data))
+			}
+		})
+	}
+}
+
+func TestRTMPReadMessage(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 300)
+	wire := captureRTMPMessage(t, rtmpChunkCommand, rtmpMsgCommandAMF0, 1, 0, encodeAMF0("onStatus"))
+	// Un mensaje de 300 bytes con el tamaño de chunk por defecto (128)
+	// llega en tres chunks; detrás va un comando en otro chunk stream
+	split := []byte{0x04, 0, 0, 0, 0x00, 0x01, 0x2C, rtmpMsgVideo, 1, 0, 0, 0}
+	split = append(split, payload[:128]...)
+	split = append(split, 0xC4)
+	split = append(split, payload[128:256]...)
+	split = append(split, 0xC4)
+	split = append(split, payload[256:]...)
+
+	c := &rtmpConn{
+		reader:      bufio.NewReader(bytes.NewReader(append(split, wire...))),
+		inChunkSize: 128,
+		inbound:     make(map[uint32]*rtmpChunkState),
+	}
+	msg, err := c.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.msgType != rtmpMsgVideo || msg.streamID != 1 || !bytes.Equal(msg.payload, payload) {
+		t.Errorf("first message = type %d stream %d, %d bytes", msg.msgType, msg.streamID, len(msg.payload))
+	}
+	msg, err = c.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := decodeAMF0(msg.payload); msg.msgType != rtmpMsgCommandAMF0 || len(values) != 1 || values[0] != "onStatus" {
+		t.Errorf("second message = type %d %v", msg.msgType, values)
+	}
+}
+
+func TestFLVAVCTags(t *testing.T) {
+	sps := []byte{0x67, 0x42, 0xC0, 0x1E, 0xD9}
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	header := flvAVCSequenceHeader(sps, pps)
+	want := []byte{0x17, 0x00, 0, 0, 0, 0x01, 0x42, 0xC0, 0x1E, 0xFF, 0xE1, 0, 5}
+	want = append(want, sps...)
+	want = append(want, 0x01, 0, 4)
+	want = append(want, pps...)
+	if !bytes.Equal(header, want) {
+		t.Errorf("flvAVCSequenceHeader = % x, want % x", header, want)
+	}
+
+	tests := []struct {
+		name     string
+		annexB   []byte
+		keyframe bool
+		want     []byte
+	}{
+		{
+			"keyframe drops parameter sets",
+			[]byte{0, 0, 0, 1, 0x09, 0xF0, 0, 0, 0, 1, 0x67, 0x42, 0, 0, 1, 0x68, 0xCE, 0, 0, 0, 1, 0x65, 0x88, 0x84},
+			true,
+			[]byte{0x17, 0x01, 0, 0, 0, 0, 0, 0, 3, 0x65, 0x88, 0x84},
+		},
+		{
+			"inter frame with two slices",
+			[]byte{0, 0, 1, 0x41, 0x9A, 0, 0, 1, 0x41, 0x9B, 0x01},
+			false,
+			[]byte{0x27, 0x01, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9A, 0, 0, 0, 3, 0x41, 0x9B, 0x01},
+		},
+	}
+	for _, tt := range tests {
+		if got := flvAVCFrame(tt.annexB, tt.keyframe); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: flvAVCFrame = % x, want % x", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// rtspMaxBody limita el cuerpo de los mensajes RTSP (SDP, parámetros)
+const rtspMaxBody = 64 * 1024
+
+// rtspMessage es una petición o respuesta RTSP ya leída
+type rtspMessage struct {
+	// Primera línea: "DESCRIBE rtsp://... RTSP/1.0" o "RTSP/1.0 200 OK"
+	method string
+	url    string
+	status int
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// rtspInterleaved es un paquete RTP/RTCP intercalado en la conexión TCP
+type rtspInterleaved struct {
+	channel byte
+	data    []byte
+}
+
+// readRTSP lee el siguiente mensaje de la conexión. Los paquetes
+// intercalados ('$') se devuelven aparte para que el llamador decida.
+func readRTSP(reader *bufio.Reader) (*rtspMessage, *rtspInterleaved, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first[0] == '$' {
+		var header [4]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return nil, nil, err
+		}
+		data := make([]byte, int(header[2])<<8|int(header[3]))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, nil, err
+		}
+		return nil, &rtspInterleaved{channel: header[1], data: data}, nil
+	}
+
+	tp := textproto.NewReader(reader)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, nil, err
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, nil, fmt.Errorf("malformed rtsp line: %q", line)
+	}
+
+	msg := &rtspMessage{}
+	if strings.HasPrefix(parts[0], "RTSP/") {
+		msg.status, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed rtsp status: %q", line)
+		}
+	} else {
+		if len(parts) < 3 {
+			return nil, nil, fmt.Errorf("malformed rtsp request: %q", line)
+		}
+		msg.method = parts[0]
+		msg.url = parts[1]
+	}
+
+	msg.header, err = tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if value := msg.header.Get("Content-Length"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || length > rtspMaxBody {
+			return nil, nil, fmt.Errorf("invalid content length: %s", value)
+		}
+		msg.body = make([]byte, length)
+		if _, err := io.ReadFull(reader, msg.body); err != nil {
+			return nil, nil, err
+		}
+	}
+	return msg, nil, nil
+}
+
+// rtspStatusText devuelve la frase de los códigos de estado usados
+func rtspStatusText(status int) string {
+	switch status {
+	case 200:
+		return "OK"
+	case 400:
+		return "Bad Request"
+	case 401:
+		return "Unauthorized"
+	case 404:
+		return "Not Found"
+	case 405:
+		return "Method Not Allowed"
+	case 454:
+		return "Session Not Found"
+	case 455:
+		return "Method Not Valid in This State"
+	case 461:
+		return "Unsupported Transport"
+	case 503:
+		return "Service Unavailable"
+	default:
+		return "Error"
+	}
+}
+
+// formatRTSPResponse construye una respuesta con CSeq primero y las
+// cabeceras en el orden dado ("Nombre: valor")
+func formatRTSPResponse(status int, cseq string, headers []string, body []byte) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\n", status, rtspStatusText(status))
+	fmt.Fprintf(&b, "CSeq: %s\r\n", cseq)
+	b.WriteString("Server: alien-cam\r\n")
+	for _, header := range headers {
+		b.WriteString(header)
+		b.WriteString("\r\n")
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	return []byte(b.String())
+}
+
+// formatRTSPRequest construye una petición con las cabeceras dadas
+func formatRTSPRequest(method, url string, cseq int, headers []string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, url)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", cseq)
+	b.WriteString("User-Agent: alien-cam\r\n")
+	for _, header := range headers {
+		b.WriteString(header)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// formatInterleaved antepone la cabecera '$' de RTP sobre TCP
+func formatInterleaved(channel byte, data []byte) []byte {
+	frame := make([]byte, 4+len(data))
+	frame[0] = '$'
+	frame[1] = channel
+	frame[2] = byte(len(data) >> 8)
+	frame[3] = byte(len(data))
+	copy(frame[4:], data)
+	return frame
+}
+
+// parseRTSPTransport separa una cabecera Transport en su perfil y
+// parámetros ("interleaved" -> "0-1", "unicast" -> "")
+func parseRTSPTransport(value string) (string, map[string]string) {
+	// Solo se considera la primera opción si el cliente ofrece varias
+	value, _, _ = strings.Cut(value, ",")
+	fields := strings.Split(value, ";")
+	params := make(map[string]string)
+	for _, field := range fields[1:] {
+		key, val, _ := strings.Cut(strings.TrimSpace(field), "=")
+		params[strings.ToLower(key)] = val
+	}
+	return strings.ToUpper(strings.TrimSpace(fields[0])), params
+}
+
+// parsePortRange interpreta "a-b" (o "a") como par de puertos o canales
+func parsePortRange(value string) (int, int, error) {
+	first, second, hasSecond := strings.Cut(value, "-")
+	a, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range: %s", value)
+	}
+	b := a + 1
+	if hasSecond {
+		if b, err = strconv.Atoi(second); err != nil {
+			return 0, 0, fmt.Errorf("invalid range: %s", value)
+		}
+	}
+	return a, b, nil
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+const (
+	// rtspPath es la ruta del stream: rtsp://<ip>:8554/cam
+	rtspPath = "/cam"
+	// rtspTrackControl identifica la única pista en DESCRIBE/SETUP
+	rtspTrackControl = "trackID=0"
+	// rtspPayloadType es el payload type dinámico del H.264
+	rtspPayloadType = 96
+	// rtspMTU es el tamaño máximo de los paquetes RTP
+	rtspMTU = 1400
+	// rtspSessionTimeout cierra las sesiones UDP sin keepalive
+	rtspSessionTimeout = 60 * time.Second
+	// rtspDescribeTimeout es lo que espera DESCRIBE por un keyframe
+	rtspDescribeTimeout = 5 * time.Second
+	// rtspReportInterval es cada cuánto se envía un Sender Report
+	rtspReportInterval = 5 * time.Second
+	// rtspBuffer es cuántos frames puede acumular un cliente lento
+	rtspBuffer = 30
+	// Puertos UDP del servidor para RTP y RTCP
+	rtspRTPPort  = 8000
+	rtspRTCPPort = 8001
+)
+
+// RTSPServer sirve el video H.264 de la cámara activa por RTSP, con RTP
+// intercalado en TCP o por UDP, para NVRs, Home Assistant, Frigate o VLC
+type RTSPServer struct {
+	video           func() *FrameBroker
+	requestKeyframe func()
+
+	mutex    sync.Mutex
+	sessions map[string]*rtspSession
+	rtpConn  *net.UDPConn
+	rtcpConn *net.UDPConn
+}
+
+// rtspConn es una conexión de control de un cliente
+type rtspConn struct {
+	server     *RTSPServer
+	conn       net.Conn
+	writeMutex sync.Mutex
+}
+
+// rtspSession es un cliente reproduciendo el stream
+type rtspSession struct {
+	id       string
+	conn     *rtspConn
+	ssrc     uint32
+	seqBase  uint16
+	timeBase uint32
+
+	mutex sync.Mutex
+	// Transporte elegido en SETUP
+	transport rtspTransport
+	setupDone bool
+	playing   bool
+	lastSeen  time.Time
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// rtspTransport es el destino de los paquetes de una sesión: canales
+// intercalados en la conexión RTSP o direcciones UDP del cliente
+type rtspTransport struct {
+	tcp      bool
+	channel  byte
+	rtpAddr  *net.UDPAddr
+	rtcpAddr *net.UDPAddr
+}
+
+func NewRTSPServer(video func() *FrameBroker) *RTSPServer {
+	return &RTSPServer{
+		video:    video,
+		sessions: make(map[string]*rtspSession),
+	}
+}
+
+// ListenAndServe acepta conexiones RTSP en addr; el transporte UDP solo se
+// ofrece si se pueden abrir sus puertos
+func (s *RTSPServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	rtpConn, rtpErr := net.ListenUDP("udp", &net.UDPAddr{Port: rtspRTPPort})
+	rtcpConn, rtcpErr := net.ListenUDP("udp", &net.UDPAddr{Port: rtspRTCPPort})
+	if rtpErr != nil || rtcpErr != nil {
+		log.Printf("⚠️  RTSP sin transporte UDP (puertos %d-%d ocupados), solo TCP", rtspRTPPort, rtspRTCPPort)
+		if rtpConn != nil {
+			rtpConn.Close()
+		}
+		if rtcpConn != nil {
+			rtcpConn.Close()
+		}
+	} else {
+		s.rtpConn = rtpConn
+		s.rtcpConn = rtcpConn
+		go s.readRTCP(rtcpConn)
+	}
+
+	go s.expireSessions()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn atiende las peticiones de una conexión de control
+func (s *RTSPServer) handleConn(conn net.Conn) {
+	c := &rtspConn{server: s, conn: conn}
+	reader := bufio.NewReader(conn)
+	log.Printf("🔌 Cliente RTSP conectado: %s", conn.RemoteAddr())
+
+	defer func() {
+		conn.Close()
+		// Las sesiones TCP mueren con su conexión
+		s.mutex.Lock()
+		for id, session := range s.sessions {
+			if session.conn == c && session.currentTransport().tcp {
+				session.close()
+				delete(s.sessions, id)
+			}
+		}
+		s.mutex.Unlock()
+		log.Printf("🔌 Cliente RTSP desconectado: %s", conn.RemoteAddr())
+	}()
+
+	for {
+		req, interleaved, err := readRTSP(reader)
+		if err != nil {
+			return
+		}
+		// El RTCP intercalado del cliente (receiver reports) se ignora
+		if interleaved != nil {
+			continue
+		}
+		if req.method == "" {
+			continue
+		}
+
+		response := s.handleRequest(c, req)
+		if err := c.write(response); err != nil {
+			return
+		}
+	}
+}
+
+// handleRequest despacha una petición y devuelve la respuesta completa
+func (s *RTSPServer) handleRequest(c *rtspConn, req *rtspMessage) []byte {
+	cseq := req.header.Get("CSeq")
+	log.Printf("📨 RTSP %s %s", req.method, req.url)
+
+	if session := s.getSession(req.header.Get("Session")); session != nil {
+		session.touch()
+	}
+
+	switch req.method {
+	case "OPTIONS":
+		return formatRTSPResponse(200, cseq, []string{
+			"Public: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER",
+		}, nil)
+	case "DESCRIBE":
+		return s.handleDescribe(c, req, cseq)
+	case "SETUP":
+		return s.handleSetup(c, req, cseq)
+	case "PLAY":
+		return s.handlePlay(req, cseq)
+	case "TEARDOWN":
+		return s.handleTeardown(req, cseq)
+	case "GET_PARAMETER":
+		return formatRTSPResponse(200, cseq, nil, nil)
+	default:
+		return formatRTSPResponse(405, cseq, []string{
+			"Allow: OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN, GET_PARAMETER",
+		}, nil)
+	}
+}
+
+// handleDescribe responde con el SDP del stream H.264
+func (s *RTSPServer) handleDescribe(c *rtspConn, req *rtspMessage, cseq string) []byte {
+	if !isRTSPStreamURL(req.url) {
+		return formatRTSPResponse(404, cseq, nil, nil)
+	}
+
+	keyframe := s.waitKeyframe(rtspDescribeTimeout)
+	if keyframe == nil {
+		return formatRTSPResponse(503, cseq, nil, nil)
+	}
+
+	host, _, _ := net.SplitHostPort(c.conn.LocalAddr().String())
+	sdp := buildRTSPSDP(host, keyframe.Data)
+	base := strings.TrimSuffix(req.url, "/") + "/"
+	return formatRTSPResponse(200, cseq, []string{
+		"Content-Base: " + base,
+		"Content-Type: application/sdp",
+	}, []byte(sdp))
+}
+
+// waitKeyframe devuelve un keyframe H.264 reciente (para los SPS/PPS)
+func (s *RTSPServer) waitKeyframe(timeout time.Duration) *Frame {
+	broker := s.video()
+	if broker == nil {
+		return nil
+	}
+	if latest := broker.Latest(); latest != nil && latest.Keyframe && latest.Codec == codecH264 {
+		return latest
+	}
+
+	// El suscriptor empieza esperando un keyframe
+	sub := broker.Subscribe(1)
+	defer sub.Close()
+	if s.requestKeyframe != nil {
+		s.requestKeyframe()
+	}
+
+	select {
+	case frame := <-sub.C:
+		if frame.Codec != codecH264 {
+			log.Printf("⚠️  RTSP solo admite H.264, el video es %s", frame.Codec)
+			return nil
+		}
+		return frame
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+// buildRTSPSDP genera la descripción con una pista H.264 y sus parámetros
+func buildRTSPSDP(host string, keyframe []byte) string {
+	var params h264ParameterSets
+	params.update(splitAnnexB(keyframe))
+
+	fmtp := "packetization-mode=1"
+	if params.ready() {
+		if len(params.sps) >= 4 {
+			fmtp += ";profile-level-id=" + strings.ToUpper(hex.EncodeToString(params.sps[1:4]))
+		}
+		fmtp += ";sprop-parameter-sets=" + base64.StdEncoding.EncodeToString(params.sps) +
+			"," + base64.StdEncoding.EncodeToString(params.pps)
+	}
+
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=- %d 1 IN IP4 %s", time.Now().Unix(), host),
+		"s=Alien Cam",
+		"c=IN IP4 0.0.0.0",
+		"t=0 0",
+		"a=control:*",
+		fmt.Sprintf("m=video 0 RTP/AVP %d", rtspPayloadType),
+		fmt.Sprintf("a=rtpmap:%d H264/90000", rtspPayloadType),
+		fmt.Sprintf("a=fmtp:%d %s", rtspPayloadType, fmtp),
+		"a=control:" + rtspTrackControl,
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// handleSetup negocia el transporte (TCP intercalado o UDP unicast)
+func (s *RTSPServer) handleSetup(c *rtspConn, req *rtspMessage, cseq string) []byte {
+	if !isRTSPStreamURL(req.url) {
+		return formatRTSPResponse(404, cseq, nil, nil)
+	}
+
+	session := s.getSession(req.header.Get("Session"))
+	if req.header.Get("Session") != "" && session == nil {
+		return formatRTSPResponse(454, cseq, nil, nil)
+	}
+	if session == nil {
+		session = &rtspSession{
+			id:       newSessionID("rtsp"),
+			conn:     c,
+			ssrc:     rand.Uint32(),
+			seqBase:  uint16(rand.Uint32()),
+			timeBase: rand.Uint32(),
+			lastSeen: time.Now(),
+			stop:     make(chan struct{}),
+		}
+	}
+
+	profile, params := parseRTSPTransport(req.header.Get("Transport"))
+	if _, multicast := params["multicast"]; multicast {
+		return formatRTSPResponse(461, cseq, nil, nil)
+	}
+
+	var transport rtspTransport
+	var header string
+	switch profile {
+	case "RTP/AVP/TCP":
+		channel := 0
+		if value, ok := params["interleaved"]; ok {
+			first, _, err := parsePortRange(value)
+			if err != nil || first < 0 || first > 254 {
+				return formatRTSPResponse(400, cseq, nil, nil)
+			}
+			channel = first
+		}
+		transport = rtspTransport{tcp: true, channel: byte(channel)}
+		header = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%08X", channel, channel+1, session.ssrc)
+	case "RTP/AVP", "RTP/AVP/UDP":
+		if s.rtpConn == nil {
+			return formatRTSPResponse(461, cseq, nil, nil)
+		}
+		rtpPort, rtcpPort, err := parsePortRange(params["client_port"])
+		if err != nil {
+			return formatRTSPResponse(461, cseq, nil, nil)
+		}
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		ip := net.ParseIP(host)
+		transport = rtspTransport{
+			rtpAddr:  &net.UDPAddr{IP: ip, Port: rtpPort},
+			rtcpAddr: &net.UDPAddr{IP: ip, Port: rtcpPort},
+		}
+		header = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d;ssrc=%08X",
+			rtpPort, rtcpPort, rtspRTPPort, rtspRTCPPort, session.ssrc)
+	default:
+		return formatRTSPResponse(461, cseq, nil, nil)
+	}
+	session.mutex.Lock()
+	session.transport = transport
+	session.setupDone = true
+	session.mutex.Unlock()
+
+	s.mutex.Lock()
+	s.sessions[session.id] = session
+	s.mutex.Unlock()
+
+	return formatRTSPResponse(200, cseq, []string{
+		"Transport: " + header,
+		fmt.Sprintf("Session: %s;timeout=%d", session.id, int(rtspSessionTimeout.Seconds())),
+	}, nil)
+}
+
+// handlePlay empieza a enviar el video de la sesión
+func (s *RTSPServer) handlePlay(req *rtspMessage, cseq string) []byte {
+	session := s.getSession(req.header.Get("Session"))
+	if session == nil {
+		return formatRTSPResponse(454, cseq, nil, nil)
+	}
+	broker := s.video()
+
+	session.mutex.Lock()
+	setupDone := session.setupDone
+	alreadyPlaying := session.playing
+	if setupDone && broker != nil {
+		session.playing = true
+	}
+	session.mutex.Unlock()
+	if !setupDone {
+		return formatRTSPResponse(455, cseq, nil, nil)
+	}
+	if broker == nil {
+		return formatRTSPResponse(503, cseq, nil, nil)
+	}
+	if !alreadyPlaying {
+		go s.stream(session, broker)
+	}
+
+	base := strings.TrimSuffix(req.url, "/")
+	if !strings.HasSuffix(base, rtspTrackControl) {
+		base += "/" + rtspTrackControl
+	}
+	return formatRTSPResponse(200, cseq, []string{
+		"Range: npt=0.000-",
+		fmt.Sprintf("Session: %s;timeout=%d", session.id, int(rtspSessionTimeout.Seconds())),
+		fmt.Sprintf("RTP-Info: url=%s;seq=%d;rtptime=%d", base, session.seqBase, session.timeBase),
+	}, nil)
+}
+
+// handleTeardown termina la sesión
+func (s *RTSPServer) handleTeardown(req *rtspMessage, cseq string) []byte {
+	id := sessionIDFromHeader(req.header.Get("Session"))
+
+	s.mutex.Lock()
+	session := s.sessions[id]
+	delete(s.sessions, id)
+	s.mutex.Unlock()
+
+	if session == nil {
+		return formatRTSPResponse(454, cseq, nil, nil)
+	}
+	session.close()
+	return formatRTSPResponse(200, cseq, nil, nil)
+}
+
+// stream empaqueta en RTP los frames H.264 del broker hasta que la sesión
+// termina o cambia la fuente de video
+func (s *RTSPServer) stream(session *rtspSession, broker *FrameBroker) {
+	sub := broker.Subscribe(rtspBuffer)
+	defer sub.Close()
+	if s.requestKeyframe != nil {
+		s.requestKeyframe()
+	}
+	log.Printf("▶️  RTSP %s reproduciendo (%s)", session.id, session.transportName())
+
+	payloader := &codecs.H264Payloader{}
+	var params h264ParameterSets
+	seq := session.seqBase
+	var start time.Time
+	var lastTimestamp uint32
+	var packets, octets uint32
+
+	ticker := time.NewTicker(rtspReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case frame := <-sub.C:
+			if frame.Codec != codecH264 {
+				continue
+			}
+			if start.IsZero() {
+				start = frame.Timestamp
+			}
+			lastTimestamp = session.timeBase + uint32(ticks90kHz(frame.Timestamp.Sub(start)))
+
+			payloads := payloader.Payload(rtspMTU, params.complete(frame.Data))
+			for i, payload := range payloads {
+				packet := rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						Marker:         i == len(payloads)-1,
+						PayloadType:    rtspPayloadType,
+						SequenceNumber: seq,
+						Timestamp:      lastTimestamp,
+						SSRC:           session.ssrc,
+					},
+					Payload: payload,
+				}
+				seq++
+				raw, err := packet.Marshal()
+				if err != nil {
+					continue
+				}
+				if err := session.writeRTP(raw); err != nil {
+					log.Printf("⏹️  RTSP %s finalizado: %v", session.id, err)
+					s.removeSession(session)
+					return
+				}
+				packets++
+				octets += uint32(len(payload))
+			}
+		case <-ticker.C:
+			if s.video() != broker {
+				log.Printf("⏹️  RTSP %s finalizado: cambió la fuente de video", session.id)
+				s.removeSession(session)
+				return
+			}
+			if !start.IsZero() {
+				report := &rtcp.SenderReport{
+					SSRC:        session.ssrc,
+					NTPTime:     toNTPTime(time.Now()),
+					RTPTime:     lastTimestamp,
+					PacketCount: packets,
+					OctetCount:  octets,
+				}
+				if raw, err := report.Marshal(); err == nil {
+					session.writeRTCP(raw)
+				}
+			}
+		case <-session.stop:
+			log.Printf("⏹️  RTSP %s finalizado (%d frames descartados)", session.id, sub.Dropped())
+			return
+		}
+	}
+}
+
+// readRTCP consume los receiver reports UDP, que sirven de keepalive
+func (s *RTSPServer) readRTCP(conn *net.UDPConn) {
+	buf := make([]byte, 1500)
+	for {
+		_, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		for _, session := range s.sessions {
+			transport := session.currentTransport()
+			if !transport.tcp && transport.rtcpAddr != nil && transport.rtcpAddr.IP.Equal(addr.IP) && transport.rtcpAddr.Port == addr.Port {
+				session.touch()
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// expireSessions cierra las sesiones UDP que dejan de dar señales de vida
+func (s *RTSPServer) expireSessions() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mutex.Lock()
+		for id, session := range s.sessions {
+			session.mutex.Lock()
+			expired := !session.transport.tcp && time.Since(session.lastSeen) > rtspSessionTimeout
+			session.mutex.Unlock()
+			if expired {
+				log.Printf("⌛ Sesión RTSP %s expirada", id)
+				session.close()
+				delete(s.sessions, id)
+			}
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// ClientCount devuelve cuántas sesiones RTSP están reproduciendo
+func (s *RTSPServer) ClientCount() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := 0
+	for _, session := range s.sessions {
+		session.mutex.Lock()
+		if session.playing {
+			count++
+		}
+		session.mutex.Unlock()
+	}
+	return count
+}
+
+func (s *RTSPServer) getSession(header string) *rtspSession {
+	id := sessionIDFromHeader(header)
+	if id == "" {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sessions[id]
+}
+
+func (s *RTSPServer) removeSession(session *rtspSession) {
+	s.mutex.Lock()
+	if s.sessions[session.id] == session {
+		delete(s.sessions, session.id)
+	}
+	s.mutex.Unlock()
+	session.close()
+}
+
+func (session *rtspSession) touch() {
+	session.mutex.Lock()
+	session.lastSeen = time.Now()
+	session.mutex.Unlock()
+}
+
+func (session *rtspSession) close() {
+	session.once.Do(func() {
+		close(session.stop)
+	})
+}
+
+// currentTransport devuelve una copia del transporte elegido en SETUP
+func (session *rtspSession) currentTransport() rtspTransport {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.transport
+}
+
+func (session *rtspSession) transportName() string {
+	if session.currentTransport().tcp {
+		return "TCP"
+	}
+	return "UDP"
+}
+
+// writeRTP envía un paquete RTP por el transporte de la sesión
+func (session *rtspSession) writeRTP(raw []byte) error {
+	transport := session.currentTransport()
+	if transport.tcp {
+		return session.conn.write(formatInterleaved(transport.channel, raw))
+	}
+	_, err := session.conn.server.rtpConn.WriteToUDP(raw, transport.rtpAddr)
+	return err
+}
+
+// writeRTCP envía un paquete RTCP; los errores se ignoran
+func (session *rtspSession) writeRTCP(raw []byte) {
+	transport := session.currentTransport()
+	if transport.tcp {
+		session.conn.write(formatInterleaved(transport.channel+1, raw))
+		return
+	}
+	session.conn.server.rtcpConn.WriteToUDP(raw, transport.rtcpAddr)
+}
+
+// write serializa las escrituras de respuestas y RTP intercalado
+func (c *rtspConn) write(data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// isRTSPStreamURL comprueba que la URL apunte a /cam o a su pista
+func isRTSPStreamURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	path := strings.TrimSuffix(parsed.Path, "/")
+	path = strings.TrimSuffix(path, "/"+rtspTrackControl)
+	return path == rtspPath
+}
+
+// sessionIDFromHeader quita los parámetros (";timeout=60") de la cabecera Session
+func sessionIDFromHeader(header string) string {
+	id, _, _ := strings.Cut(header, ";")
+	return strings.TrimSpace(id)
+}
+
+// toNTPTime convierte un instante al formato NTP de 64 bits de RTCP
+func toNTPTime(t time.Time) uint64 {
+	const ntpEpochOffset = 2208988800
+	seconds := uint64(t.Unix()) + ntpEpochOffset
+	fraction := uint64(t.Nanosecond()) << 32 / 1e9
+	return seconds<<32 | fraction
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+const (
+	// rtspDialTimeout limita la conexión y cada respuesta de la cámara remota
+	rtspDialTimeout = 5 * time.Second
+	// rtspReadTimeout da la cámara por caída si deja de enviar datos
+	rtspReadTimeout = 10 * time.Second
+	// rtspMaxBackoff es la espera máxima entre reconexiones
+	rtspMaxBackoff = 30 * time.Second
+	// rtspMaxLate es cuántos paquetes RTP espera el samplebuilder por un hueco
+	rtspMaxLate = 128
+)
+
+// RTSPCameraSource se conecta a una cámara RTSP existente (RTP intercalado
+// en TCP) y la expone como fuente de alien-cam: el H.264 recibido se reparte
+// sin recodificar y los snapshots se decodifican con ffmpeg
+type RTSPCameraSource struct {
+	url   *url.URL
+	video *FrameBroker
+
+	mutex           sync.Mutex
+	stop            chan struct{}
+	done            chan struct{}
+	connected       bool
+	keyframe        *Frame
+	updated         chan struct{}
+	lastSnapshotSeq uint64
+}
+
+// rtspClient es una conexión de control con la cámara remota
+type rtspClient struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	cseq       int
+	user       string
+	password   string
+	session    string
+	auth       string
+	realm      string
+	nonce      string
+	writeMutex sync.Mutex
+}
+
+// rtspMedia es la pista H.264 encontrada en el SDP remoto
+type rtspMedia struct {
+	control     string
+	payloadType uint8
+	sps         []byte
+	pps         []byte
+}
+
+func NewRTSPCameraSource(rawURL string) (*RTSPCameraSource, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid rtsp url: %s", rawURL)
+	}
+	if parsed.Port() == "" {
+		parsed.Host = net.JoinHostPort(parsed.Hostname(), "554")
+	}
+	return &RTSPCameraSource{
+		url:     parsed,
+		video:   NewFrameBroker(),
+		updated: make(chan struct{}),
+	}, nil
+}
+
+// VideoFrames devuelve el broker con el H.264 recibido de la cámara
+func (s *RTSPCameraSource) VideoFrames() *FrameBroker {
+	return s.video
+}
+
+// Open lanza la conexión con la cámara remota, que se reintenta sola
+func (s *RTSPCameraSource) Open() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stop != nil {
+		return nil
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run(s.stop, s.done)
+	return nil
+}
+
+// CaptureFrame espera un keyframe más nuevo que el último entregado y lo
+// convierte a JPEG con ffmpeg
+func (s *RTSPCameraSource) CaptureFrame() ([]byte, error) {
+	deadline := time.Now().Add(ingestFrameTimeout)
+
+	for {
+		s.mutex.Lock()
+		keyframe := s.keyframe
+		updated := s.updated
+		connected := s.connected
+		if keyframe != nil && keyframe.Seq > s.lastSnapshotSeq {
+			s.lastSnapshotSeq = keyframe.Seq
+			s.mutex.Unlock()
+			return decodeH264Snapshot(keyframe.Data)
+		}
+		s.mutex.Unlock()
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			if !connected {
+				return nil, fmt.Errorf("rtsp camera %s not connected", s.url.Host)
+			}
+			return nil, fmt.Errorf("no keyframe received from rtsp camera")
+		}
+
+		select {
+		case <-updated:
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *RTSPCameraSource) Capabilities() CameraCapabilities {
+	return CameraCapabilities{
+		ID:      rtspURLWithoutAuth(s.url),
+		Name:    fmt.Sprintf("RTSP (%s%s)", s.url.Host, s.url.Path),
+		Backend: "rtsp",
+		Formats: []string{codecJPEG, codecH264},
+	}
+}
+
+// Close corta la conexión con la cámara remota
+func (s *RTSPCameraSource) Close() error {
+	s.mutex.Lock()
+	stop := s.stop
+	done := s.done
+	s.stop = nil
+	s.done = nil
+	s.lastSnapshotSeq = 0
+	s.mutex.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+	s.video.Reset()
+	return nil
+}
+
+// run mantiene la conexión con la cámara reintentando con espera creciente
+func (s *RTSPCameraSource) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	backoff := time.Second
+	for {
+		streamed, err := s.pull(stop)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if streamed {
+			backoff = time.Second
+		}
+		log.Printf("⚠️  Cámara RTSP %s desconectada: %v (reintento en %s)", s.url.Host, err, backoff)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > rtspMaxBackoff {
+			backoff = rtspMaxBackoff
+		}
+	}
+}
+
+// pull negocia la sesión y recibe video hasta que falla o se pide parar.
+// Devuelve si llegó a recibirse video.
+func (s *RTSPCameraSource) pull(stop <-chan struct{}) (bool, error) {
+	client, err := dialRTSP(s.url)
+	if err != nil {
+		return false, err
+	}
+	defer client.conn.Close()
+
+	baseURL := rtspURLWithoutAuth(s.url)
+	describe, err := client.do("DESCRIBE", baseURL, []string{"Accept: application/sdp"})
+	if err != nil {
+		return false, err
+	}
+	if describe.status != 200 {
+		return false, fmt.Errorf("describe failed: %d", describe.status)
+	}
+
+	media, err := parseRTSPMedia(string(describe.body))
+	if err != nil {
+		return false, err
+	}
+	contentBase := describe.header.Get("Content-Base")
+	if contentBase == "" {
+		contentBase = baseURL
+	}
+	trackURL := resolveRTSPControl(contentBase, media.control)
+
+	setup, err := client.do("SETUP", trackURL, []string{"Transport: RTP/AVP/TCP;unicast;interleaved=0-1"})
+	if err != nil {
+		return false, err
+	}
+	if setup.status != 200 {
+		return false, fmt.Errorf("setup failed: %d", setup.status)
+	}
+	client.session = sessionIDFromHeader(setup.header.Get("Session"))
+	keepalive := rtspSessionTimeout / 2
+	if _, params, found := strings.Cut(setup.header.Get("Session"), ";"); found {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "timeout="); ok {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 1 {
+				keepalive = time.Duration(seconds) * time.Second / 2
+			}
+		}
+	}
+	rtpChannel := byte(0)
+	if _, params := parseRTSPTransport(setup.header.Get("Transport")); params["interleaved"] != "" {
+		if first, _, err := parsePortRange(params["interleaved"]); err == nil {
+			rtpChannel = byte(first)
+		}
+	}
+
+	play, err := client.do("PLAY", baseURL, []string{"Range: npt=0.000-"})
+	if err != nil {
+		return false, err
+	}
+	if play.status != 200 {
+		return false, fmt.Errorf("play failed: %d", play.status)
+	}
+
+	log.Printf("📡 Cámara RTSP conectada: %s", s.url.Host)
+	s.setConnected(true)
+	defer s.setConnected(false)
+
+	// Keepalive y cierre ordenado con TEARDOWN cuando se pide parar
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				client.send("GET_PARAMETER", baseURL, nil)
+			case <-stop:
+				client.send("TEARDOWN", baseURL, nil)
+				client.conn.Close()
+				return
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	params := h264ParameterSets{sps: media.sps, pps: media.pps}
+	builder := samplebuilder.New(rtspMaxLate, &codecs.H264Packet{}, 90000)
+	streamed := false
+
+	for {
+		client.conn.SetReadDeadline(time.Now().Add(rtspReadTimeout))
+		_, interleaved, err := readRTSP(client.reader)
+		if err != nil {
+			return streamed, err
+		}
+		// Respuestas a los keepalive y RTCP de la cámara se ignoran
+		if interleaved == nil || interleaved.channel != rtpChannel {
+			continue
+		}
+
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(interleaved.data); err != nil || packet.PayloadType != media.payloadType {
+			continue
+		}
+		builder.Push(packet)
+
+		for sample := builder.Pop(); sample != nil; sample = builder.Pop() {
+			if len(sample.Data) == 0 {
+				continue
+			}
+			data := params.complete(sample.Data)
+			frame := &Frame{
+				Data:      data,
+				Codec:     codecH264,
+				Keyframe:  isH264Keyframe(data),
+				Timestamp: time.Now(),
+				Duration:  sample.Duration,
+			}
+			s.publish(frame)
+			streamed = true
+		}
+	}
+}
+
+func (s *RTSPCameraSource) setConnected(connected bool) {
+	s.mutex.Lock()
+	s.connected = connected
+	s.mutex.Unlock()
+}
+
+func (s *RTSPCameraSource) publish(frame *Frame) {
+	s.video.Publish(frame)
+
+	if frame.Keyframe {
+		s.mutex.Lock()
+		s.keyframe = frame
+		close(s.updated)
+		s.updated = make(chan struct{})
+		s.mutex.Unlock()
+	}
+}
+
+// dialRTSP abre la conexión de control con la cámara
+func dialRTSP(u *url.URL) (*rtspClient, error) {
+	conn, err := net.DialTimeout("tcp", u.Host, rtspDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	client := &rtspClient{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	if u.User != nil {
+		client.user = u.User.Username()
+		client.password, _ = u.User.Password()
+	}
+	return client, nil
+}
+
+// send escribe una petición sin esperar la respuesta
+func (c *rtspClient) send(method, uri string, headers []string) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	c.cseq++
+	if c.session != "" {
+		headers = append(headers, "Session: "+c.session)
+	}
+	if auth := c.authorization(method, uri); auth != "" {
+		headers = append(headers, "Authorization: "+auth)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(rtspDialTimeout))
+	_, err := c.conn.Write(formatRTSPRequest(method, uri, c.cseq, headers))
+	return err
+}
+
+// do envía una petición y espera su respuesta, reintentando una vez con
+// credenciales si la cámara responde 401
+func (c *rtspClient) do(method, uri string, headers []string) (*rtspMessage, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.send(method, uri, headers); err != nil {
+			return nil, err
+		}
+
+		c.conn.SetReadDeadline(time.Now().Add(rtspDialTimeout))
+		var response *rtspMessage
+		for response == nil {
+			msg, _, err := readRTSP(c.reader)
+			if err != nil {
+				return nil, err
+			}
+			if msg != nil && msg.method == "" {
+				response = msg
+			}
+		}
+
+		if response.status != 401 || attempt > 0 || c.user == "" {
+			return response, nil
+		}
+		if err := c.setChallenge(response.header.Values("WWW-Authenticate")); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// setChallenge guarda el esquema de autenticación pedido (Digest si se ofrece)
+func (c *rtspClient) setChallenge(challenges []string) error {
+	for _, challenge := range challenges {
+		scheme, params, _ := strings.Cut(challenge, " ")
+		if strings.EqualFold(scheme, "Digest") {
+			values := parseAuthParams(params)
+			c.auth = "Digest"
+			c.realm = values["realm"]
+			c.nonce = values["nonce"]
+			return nil
+		}
+	}
+	for _, challenge := range challenges {
+		if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+			c.auth = "Basic"
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported rtsp authentication: %v", challenges)
+}
+
+// authorization genera la cabecera Authorization para la petición
+func (c *rtspClient) authorization(method, uri string) string {
+	switch c.auth {
+	case "Basic":
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(c.user+":"+c.password))
+	case "Digest":
+		ha1 := md5Hex(c.user + ":" + c.realm + ":" + c.password)
+		ha2 := md5Hex(method + ":" + uri)
+		response := md5Hex(ha1 + ":" + c.nonce + ":" + ha2)
+		return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+			c.user, c.realm, c.nonce, uri, response)
+	default:
+		return ""
+	}
+}
+
+// parseAuthParams interpreta los pares clave="valor" de un WWW-Authenticate
+func parseAuthParams(params string) map[string]string {
+	values := make(map[string]string)
+	for _, field := range strings.Split(params, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(field), "=")
+		if found {
+			values[strings.ToLower(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return values
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseRTSPMedia busca la primera pista de video H.264 del SDP. Los
+// atributos de cada sección m=video se recogen primero y se resuelven al
+// acabarla, porque muchas cámaras ponen a=control o a=fmtp antes de a=rtpmap.
+func parseRTSPMedia(sdp string) (*rtspMedia, error) {
+	var section *rtspSDPSection
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			if media := section.media(); media != nil {
+				return media, nil
+			}
+			section = nil
+			if strings.HasPrefix(line, "m=video") {
+				section = &rtspSDPSection{payloadType: -1, fmtp: make(map[string]string)}
+			}
+		case section == nil:
+		case strings.HasPrefix(line, "a=rtpmap:"):
+			pt, encoding, _ := strings.Cut(strings.TrimPrefix(line, "a=rtpmap:"), " ")
+			if section.payloadType >= 0 || !strings.HasPrefix(strings.ToUpper(encoding), "H264/") {
+				continue
+			}
+			if n, err := strconv.Atoi(pt); err == nil && n >= 0 && n < 128 {
+				section.payloadType = n
+			}
+		case strings.HasPrefix(line, "a=fmtp:"):
+			pt, params, _ := strings.Cut(strings.TrimPrefix(line, "a=fmtp:"), " ")
+			section.fmtp[pt] = params
+		case strings.HasPrefix(line, "a=control:"):
+			section.control = strings.TrimPrefix(line, "a=control:")
+		}
+	}
+
+	if media := section.media(); media != nil {
+		return media, nil
+	}
+	return nil, fmt.Errorf("no H.264 video track in rtsp stream")
+}
+
+// rtspSDPSection son los atributos de una sección m=video del SDP
+type rtspSDPSection struct {
+	payloadType int
+	control     string
+	fmtp        map[string]string
+}
+
+// media devuelve la pista H.264 de la sección, o nil si no la tiene
+func (s *rtspSDPSection) media() *rtspMedia {
+	if s == nil || s.payloadType < 0 {
+		return nil
+	}
+	media := &rtspMedia{payloadType: uint8(s.payloadType), control: s.control}
+	for _, param := range strings.Split(s.fmtp[strconv.Itoa(s.payloadType)], ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if key != "sprop-parameter-sets" {
+			continue
+		}
+		sets := strings.Split(value, ",")
+		if len(sets) < 2 {
+			continue
+		}
+		sps, spsErr := base64.StdEncoding.DecodeString(sets[0])
+		pps, ppsErr := base64.StdEncoding.DecodeString(sets[1])
+		if spsErr == nil && ppsErr == nil && len(sps) > 0 && len(pps) > 0 {
+			media.sps, media.pps = sps, pps
+		}
+	}
+	return media
+}
+
+// resolveRTSPControl combina el Content-Base con el control de la pista
+func resolveRTSPControl(base, control string) string {
+	switch {
+	case control == "" || control == "*":
+		return base
+	case strings.HasPrefix(strings.ToLower(control), "rtsp://"):
+		return control
+	case strings.HasSuffix(base, "/"):
+		return base + control
+	default:
+		return base + "/" + control
+	}
+}
+
+// rtspURLWithoutAuth quita las credenciales de la URL que se envía en las peticiones
+func rtspURLWithoutAuth(u *url.URL) string {
+	clean := *u
+	clean.User = nil
+	return clean.String()
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+)
+
+var (
+	testRTSPSPS = []byte{0x67, 0x42, 0xC0, 0x1E, 0xD9, 0x00}
+	testRTSPPPS = []byte{0x68, 0xCE, 0x3C, 0x80}
+)
+
+const (
+	testRTSPUser  = "admin"
+	testRTSPPass  = "s3cret"
+	testRTSPRealm = "FakeCam"
+	testRTSPNonce = "0a4f113b"
+)
+
+// fakeRTSPCamera es una cámara RTSP mínima que pide Digest y, tras PLAY,
+// envía H.264 enlatado por RTP intercalado en TCP
+type fakeRTSPCamera struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	requests []string
+	teardown chan struct{}
+}
+
+func newFakeRTSPCamera(t *testing.T) *fakeRTSPCamera {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	camera := &fakeRTSPCamera{listener: listener, teardown: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go camera.serve(conn)
+		}
+	}()
+	return camera
+}
+
+func (f *fakeRTSPCamera) url() string {
+	return "rtsp://" + testRTSPUser + ":" + testRTSPPass + "@" + f.listener.Addr().String() + "/stream"
+}
+
+// log devuelve las peticiones recibidas como "MÉTODO uri -> estado"
+func (f *fakeRTSPCamera) log() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+func (f *fakeRTSPCamera) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var writeMutex sync.Mutex
+	write := func(data []byte) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		_, err := conn.Write(data)
+		return err
+	}
+	base := "rtsp://" + f.listener.Addr().String() + "/stream/"
+
+	for {
+		req, _, err := readRTSP(reader)
+		if err != nil {
+			return
+		}
+		if req == nil || req.method == "" {
+			continue
+		}
+		cseq := req.header.Get("CSeq")
+
+		status, headers, body := 200, []string(nil), []byte(nil)
+		switch {
+		case !validTestDigest(req):
+			status = 401
+			headers = []string{
+				`WWW-Authenticate: Basic realm="` + testRTSPRealm + `"`,
+				`WWW-Authenticate: Digest realm="` + testRTSPRealm + `", nonce="` + testRTSPNonce + `"`,
+			}
+		case req.method == "DESCRIBE":
+			headers = []string{"Content-Base: " + base, "Content-Type: application/sdp"}
+			body = []byte(strings.Join([]string{
+				"v=0",
+				"o=- 0 0 IN IP4 127.0.0.1",
+				"s=fake",
+				"t=0 0",
+				"m=audio 0 RTP/AVP 0",
+				"a=control:trackID=0",
+				// Como muchas cámaras, a=control va antes que a=rtpmap
+				"m=video 0 RTP/AVP 96",
+				"a=control:trackID=1",
+				"a=rtpmap:96 H264/90000",
+				"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=" +
+					base64.StdEncoding.EncodeToString(testRTSPSPS) + "," + base64.StdEncoding.EncodeToString(testRTSPPPS),
+				"",
+			}, "\r\n"))
+		case req.method == "SETUP":
+			if req.url != base+"trackID=1" || !strings.Contains(req.header.Get("Transport"), "interleaved=0-1") {
+				status = 461
+				break
+			}
+			headers = []string{
+				"Transport: RTP/AVP/TCP;unicast;interleaved=2-3",
+				"Session: 12345678;timeout=60",
+			}
+		case req.method == "PLAY":
+			if req.header.Get("Session") != "12345678" {
+				status = 454
+				break
+			}
+		case req.method == "TEARDOWN":
+			close(f.teardown)
+		}
+
+		f.mutex.Lock()
+		f.requests = append(f.requests, fmt.Sprintf("%s %s -> %d", req.method, req.url, status))
+		f.mutex.Unlock()
+
+		if err := write(formatRTSPResponse(status, cseq, headers, body)); err != nil {
+			return
+		}
+		if req.method == "PLAY" && status == 200 {
+			go f.stream(write)
+		}
+	}
+}
+
+// stream envía un IDR seguido de frames P hasta que se cierra la conexión
+func (f *fakeRTSPCamera) stream(write func([]byte) error) {
+	payloader := &codecs.H264Payloader{}
+	seq := uint16(1000)
+	for i := 0; ; i++ {
+		nalu := []byte{0x41, 0x9A, byte(i)}
+		if i%10 == 0 {
+			nalu = append([]byte{0x65, 0x88, 0x84}, bytes.Repeat([]byte{byte(i)}, 3000)...)
+		}
+		payloads := payloader.Payload(1200, append([]byte{0, 0, 0, 1}, nalu...))
+		for j, payload := range payloads {
+			packet := rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					Marker:         j == len(payloads)-1,
+					PayloadType:    96,
+					SequenceNumber: seq,
+					Timestamp:      uint32(i * 3000),
+					SSRC:           0xCAFE,
+				},
+				Payload: payload,
+			}
+			seq++
+			raw, _ := packet.Marshal()
+			// RTCP por el canal 3 que el cliente debe ignorar
+			if err := write(formatInterleaved(3, []byte{0x80, 0xC8, 0, 0})); err != nil {
+				return
+			}
+			if err := write(formatInterleaved(2, raw)); err != nil {
+				return
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// validTestDigest comprueba la respuesta Digest (RFC 2617 sin qop)
+func validTestDigest(req *rtspMessage) bool {
+	auth, ok := strings.CutPrefix(req.header.Get("Authorization"), "Digest ")
+	if !ok {
+		return false
+	}
+	params := parseAuthParams(auth)
+	hash := func(value string) string {
+		sum := md5.Sum([]byte(value))
+		return hex.EncodeToString(sum[:])
+	}
+	ha1 := hash(testRTSPUser + ":" + testRTSPRealm + ":" + testRTSPPass)
+	ha2 := hash(req.method + ":" + params["uri"])
+	return params["username"] == testRTSPUser && params["realm"] == testRTSPRealm &&
+		params["nonce"] == testRTSPNonce && params["uri"] == req.url &&
+		params["response"] == hash(ha1+":"+testRTSPNonce+":"+ha2)
+}
+
+func TestRTSPCameraSourcePull(t *testing.T) {
+	camera := newFakeRTSPCamera(t)
+	source, err := NewRTSPCameraSource(camera.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := source.VideoFrames().Subscribe(64)
+	defer sub.Close()
+	if err := source.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keyframe *Frame
+	frames := 0
+	timeout := time.After(5 * time.Second)
+	for keyframe == nil || frames < 5 {
+		select {
+		case frame := <-sub.C:
+			if frame.Codec != codecH264 {
+				t.Fatalf("unexpected codec %s", frame.Codec)
+			}
+			if frame.Keyframe && keyframe == nil {
+				keyframe = frame
+			}
+			frames++
+		case <-timeout:
+			t.Fatalf("received %d frames; requests: %v", frames, camera.log())
+		}
+	}
+
+	// Los SPS/PPS del SDP se anteponen al IDR, que no los trae
+	nalus := splitAnnexB(keyframe.Data)
+	if len(nalus) != 3 || !bytes.Equal(nalus[0], testRTSPSPS) || !bytes.Equal(nalus[1], testRTSPPPS) ||
+		h264NALType(nalus[2]) != h264NALIDR || len(nalus[2]) != 3003 {
+		t.Errorf("keyframe is not SPS+PPS+IDR: %d NAL units", len(nalus))
+	}
+
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-camera.teardown:
+	case <-time.After(2 * time.Second):
+		t.Error("no TEARDOWN after Close")
+	}
+
+	base := "rtsp://" + camera.listener.Addr().String() + "/stream"
+	want := []string{
+		"DESCRIBE " + base + " -> 401",
+		"DESCRIBE " + base + " -> 200",
+		"SETUP " + base + "/trackID=1 -> 200",
+		"PLAY " + base + " -> 200",
+	}
+	got := camera.log()
+	if len(got) < len(want) {
+		t.Fatalf("requests = %v, want prefix %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if last := got[len(got)-1]; last != "TEARDOWN "+base+" -> 200" {
+		t.Errorf("last request = %q, want TEARDOWN", last)
+	}
+}
+
+func TestRTSPCameraSourceBadPassword(t *testing.T) {
+	camera := newFakeRTSPCamera(t)
+	u := strings.Replace(camera.url(), testRTSPPass, "wrong", 1)
+	source, err := NewRTSPCameraSource(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = source.pull(make(chan struct{}))
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("pull with bad password: err = %v, want describe 401", err)
+	}
+	// Una sola repetición con credenciales, sin bucles de 401
+	if got := camera.log(); len(got) != 2 {
+		t.Errorf("requests = %v, want two DESCRIBE", got)
+	}
+}
+
+func TestRTSPClientAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		challenges []string
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "basic",
+			challenges: []string{`Basic realm="cam"`},
+			want:       "Basic dXNlcjpwYXNz",
+		},
+		{
+			name:       "digest preferred",
+			challenges: []string{`Basic realm="cam"`, `Digest realm="cam", nonce="abc"`},
+			// md5(md5("user:cam:pass") + ":abc:" + md5("DESCRIBE:rtsp://h/s"))
+			want: `Digest username="user", realm="cam", nonce="abc", uri="rtsp://h/s", response="` +
+				md5Hex(md5Hex("user:cam:pass")+":abc:"+md5Hex("DESCRIBE:rtsp://h/s")) + `"`,
+		},
+		{
+			name:       "unsupported",
+			challenges: []string{`Bearer realm="cam"`},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &rtspClient{user: "user", password: "pass"}
+			err := c.setChallenge(tt.challenges)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setChallenge error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := c.authorization("DESCRIBE", "rtsp://h/s"); got != tt.want {
+				t.Errorf("authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRTSPMedia(t *testing.T) {
+	tests := []struct {
+		name    string
+		sdp     string
+		pt      uint8
+		control string
+		params  bool
+	}{
+		{
+			"rtpmap first",
+			"v=0\r\nm=audio 0 RTP/AVP 0\r\na=rtpmap:0 PCMU/8000\r\na=control:audio\r\n" +
+				"m=video 0 RTP/AVP 97\r\na=rtpmap:97 H264/90000\r\n" +
+				"a=fmtp:97 profile-level-id=42e01e;sprop-parameter-sets=Z0LAHtkA,aM48gA==\r\na=control:video\r\n",
+			97, "video", true,
+		},
+		{
+			"control and fmtp before rtpmap",
+			"v=0\r\nm=video 0 RTP/AVP 96\r\na=control:trackID=0\r\n" +
+				"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0LAHtkA,aM48gA==\r\n" +
+				"a=rtpmap:96 H264/90000\r\nm=audio 0 RTP/AVP 8\r\na=control:trackID=1\r\n",
+			96, "trackID=0", true,
+		},
+		{
+			"fmtp of another payload type",
+			"v=0\r\nm=video 0 RTP/AVP 96 98\r\na=rtpmap:98 VP8/90000\r\na=fmtp:98 sprop-parameter-sets=Z0LAHtkA,aM48gA==\r\n" +
+				"a=rtpmap:96 H264/90000\r\na=control:video\r\n",
+			96, "video", false,
+		},
+		{
+			"second video section",
+			"v=0\r\nm=video 0 RTP/AVP 26\r\na=rtpmap:26 JPEG/90000\r\na=control:mjpeg\r\n" +
+				"m=video 0 RTP/AVP 96\r\na=control:h264\r\na=rtpmap:96 H264/90000\r\n",
+			96, "h264", false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			media, err := parseRTSPMedia(tt.sdp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if media.payloadType != tt.pt || media.control != tt.control {
+				t.Errorf("parseRTSPMedia = pt %d control %q, want pt %d control %q", media.payloadType, media.control, tt.pt, tt.control)
+			}
+			if params := bytes.Equal(media.sps, testRTSPSPS) && bytes.Equal(media.pps, testRTSPPPS); params != tt.params {
+				t.Errorf("parameter sets found = %v, want %v", params, tt.params)
+			}
+		})
+	}
+
+	if _, err := parseRTSPMedia("v=0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 VP8/90000\r\n"); err == nil {
+		t.Error("expected error for SDP without H.264")
+	}
+}
+
+func TestResolveRTSPControl(t *testing.T) {
+	tests := []struct {
+		base, control, want string
+	}{
+		{"rtsp://h/s/", "trackID=1", "rtsp://h/s/trackID=1"},
+		{"rtsp://h/s", "trackID=1", "rtsp://h/s/trackID=1"},
+		{"rtsp://h/s", "*", "rtsp://h/s"},
+		{"rtsp://h/s", "", "rtsp://h/s"},
+		{"rtsp://h/s", "RTSP://other/t", "RTSP://other/t"},
+	}
+	for _, tt := range tests {
+		if got := resolveRTSPControl(tt.base, tt.control); got != tt.want {
+			t.Errorf("resolveRTSPControl(%q, %q) = %q, want %q", tt.base, tt.control, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRTSPTransport(t *testing.T) {
+	tests := []struct {
+		value   string
+		profile string
+		params  map[string]string
+	}{
+		{
+			value:   "RTP/AVP/TCP;unicast;interleaved=0-1",
+			profile: "RTP/AVP/TCP",
+			params:  map[string]string{"unicast": "", "interleaved": "0-1"},
+		},
+		{
+			value:   "rtp/avp;unicast;client_port=5000-5001",
+			profile: "RTP/AVP",
+			params:  map[string]string{"unicast": "", "client_port": "5000-5001"},
+		},
+		{
+			// Solo cuenta la primera opción ofrecida
+			value:   "RTP/AVP/UDP; Multicast; TTL=4, RTP/AVP/TCP;interleaved=2-3",
+			profile: "RTP/AVP/UDP",
+			params:  map[string]string{"multicast": "", "ttl": "4"},
+		},
+		{
+			value:   "RTP/AVP",
+			profile: "RTP/AVP",
+			params:  map[string]string{},
+		},
+		{
+			value:   "",
+			profile: "",
+			params:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		profile, params := parseRTSPTransport(tt.value)
+		if profile != tt.profile || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseRTSPTransport(%q) = %q, %v; want %q, %v", tt.value, profile, params, tt.profile, tt.params)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value   string
+		first   int
+		second  int
+		wantErr bool
+	}{
+		{value: "5000-5001", first: 5000, second: 5001},
+		{value: "0-1", first: 0, second: 1},
+		{value: "8", first: 8, second: 9},
+		{value: "6000-6010", first: 6000, second: 6010},
+		{value: "", wantErr: true},
+		{value: "a-b", wantErr: true},
+		{value: "5000-", wantErr: true},
+		{value: "-5000", wantErr: true},
+	}
+	for _, tt := range tests {
+		first, second, err := parsePortRange(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePortRange(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (first != tt.first || second != tt.second) {
+			t.Errorf("parsePortRange(%q) = %d, %d; want %d, %d", tt.value, first, second, tt.first, tt.second)
+		}
+	}
+}
+
+func TestReadRTSP(t *testing.T) {
+	input := "$\x01\x00\x03abc" +
+		"RTSP/1.0 200 OK\r\nCSeq: 2\r\nContent-Length: 4\r\n\r\nv=0\n" +
+		"PLAY rtsp://h/cam RTSP/1.0\r\nCSeq: 3\r\nSession: 1234\r\n\r\n"
+	reader := bufio.NewReader(strings.NewReader(input))
+
+	msg, interleaved, err := readRTSP(reader)
+	if err != nil || msg != nil || interleaved == nil || interleaved.channel != 1 || string(interleaved.data) != "abc" {
+		t.Fatalf("interleaved packet: %+v %+v %v", msg, interleaved, err)
+	}
+
+	msg, _, err = readRTSP(reader)
+	if err != nil || msg.status != 200 || msg.method != "" || msg.header.Get("CSeq") != "2" || string(msg.body) != "v=0\n" {
+		t.Fatalf("response: %+v %v", msg, err)
+	}
+
+	msg, _, err = readRTSP(reader)
+	if err != nil || msg.method != "PLAY" || msg.url != "rtsp://h/cam" || msg.header.Get("Session") != "1234" {
+		t.Fatalf("request: %+v %v", msg, err)
+	}
+
+	bad := bufio.NewReader(strings.NewReader("RTSP/1.0 200 OK\r\nContent-Length: 999999\r\n\r\n"))
+	if _, _, err := readRTSP(bad); err == nil {
+		t.Error("expected error for oversized body")
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// segmentDefaultDuration es la duración de cada archivo de la grabación continua
+	segmentDefaultDuration = 5 * time.Minute
+	// segmentDefaultRetention es la antigüedad máxima de un segmento
+	segmentDefaultRetention = 72 * time.Hour
+	// segmentDefaultQuota es el espacio máximo que ocupan todos los segmentos
+	segmentDefaultQuota = 1024 * 1024 * 1024
+	// segmentPruneInterval es cada cuánto se aplica la retención
+	segmentPruneInterval = time.Minute
+	// segmentMaxGap corta el segmento si el video se interrumpe
+	segmentMaxGap = 3 * time.Second
+	segmentBuffer = 120
+	segmentExt    = ".ts"
+)
+
+// SegmentRecorder graba el video de la cámara de forma continua en
+// archivos MPEG-TS de duración fija y borra los más viejos según la
+// antigüedad máxima y la cuota de disco.
+type SegmentRecorder struct {
+	dir             string
+	source          func() *FrameBroker
+	requestKeyframe func()
+	camera          func() string
+	events          *EventBus
+	duration        time.Duration
+	retention       time.Duration
+	quota           int64
+
+	mutex   sync.Mutex
+	enabled bool
+	stop    chan struct{}
+	done    chan struct{}
+	current *recordingSegment
+}
+
+// recordingSegment es el archivo en curso
+type recordingSegment struct {
+	path   string
+	camera string
+	start  time.Time
+	last   time.Time
+	file   *os.File
+	writer *bufio.Writer
+	muxer  *tsMuxer
+}
+
+// SegmentInfo describe un segmento guardado
+type SegmentInfo struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Size  int64     `json:"size"`
+}
+
+// SegmentStatus es el estado que se expone en /api/status
+type SegmentStatus struct {
+	Enabled   bool         `json:"enabled"`
+	Segments  int          `json:"segments"`
+	DiskUsage int64        `json:"diskUsage"`
+	DiskFree  *int64       `json:"diskFree,omitempty"` // nil si la plataforma no lo informa
+	Quota     int64        `json:"quota"`
+	Retention string       `json:"retention"`
+	Oldest    *SegmentInfo `json:"oldest,omitempty"`
+	Newest    *SegmentInfo `json:"newest,omitempty"`
+}
+
+// NewSegmentRecorder crea la grabación continua con la configuración de
+// ALIEN_CAM_SEGMENT, ALIEN_CAM_RETENTION y ALIEN_CAM_QUOTA_MB
+func NewSegmentRecorder(dir string, source func() *FrameBroker) *SegmentRecorder {
+	r := &SegmentRecorder{
+		dir:       dir,
+		source:    source,
+		duration:  segmentDefaultDuration,
+		retention: segmentDefaultRetention,
+		quota:     segmentDefaultQuota,
+	}
+
+	if value := os.Getenv("ALIEN_CAM_SEGMENT"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d >= 10*time.Second {
+			r.duration = d
+		} else {
+			log.Printf("⚠️  ALIEN_CAM_SEGMENT inválido: %s", value)
+		}
+	}
+	if value := os.Getenv("ALIEN_CAM_RETENTION"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			r.retention = d
+		} else {
+			log.Printf("⚠️  ALIEN_CAM_RETENTION inválido: %s", value)
+		}
+	}
+	if value := os.Getenv("ALIEN_CAM_QUOTA_MB"); value != "" {
+		if mb, err := strconv.ParseInt(value, 10, 64); err == nil && mb > 0 {
+			r.quota = mb * 1024 * 1024
+		} else {
+			log.Printf("⚠️  ALIEN_CAM_QUOTA_MB inválido: %s", value)
+		}
+	}
+	return r
+}
+
+// Start arranca la grabación continua en segundo plano
+func (r *SegmentRecorder) Start() error {
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create segments dir: %w", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.enabled {
+		return nil
+	}
+	r.enabled = true
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.run(r.stop, r.done)
+	go r.pruneLoop(r.stop)
+	log.Printf("🔁 Grabación continua en %s (segmentos de %v, retención %v, cuota %d MB)",
+		r.dir, r.duration, r.retention, r.quota/(1024*1024))
+	return nil
+}
+
+// Stop cierra el segmento en curso y detiene la grabación continua
+func (r *SegmentRecorder) Stop() {
+	r.mutex.Lock()
+	stop := r.stop
+	done := r.done
+	r.enabled = false
+	r.stop = nil
+	r.done = nil
+	r.mutex.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+	log.Println("⏹️  Grabación continua detenida")
+}
+
+func (r *SegmentRecorder) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for {
+		if broker := r.source(); broker != nil {
+			r.consume(broker, stop)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// consume graba los frames del broker hasta que cambia la fuente de video
+// o se detiene la grabación
+func (r *SegmentRecorder) consume(broker *FrameBroker, stop <-chan struct{}) {
+	sub := broker.Subscribe(segmentBuffer)
+	defer sub.Close()
+	defer r.finishSegment()
+
+	if r.requestKeyframe != nil {
+		r.requestKeyframe()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-stop:
+			return
+		case frame := <-sub.C:
+			if frame.Codec != codecH264 {
+				if !warned {
+					log.Printf("⚠️  La grabación continua solo admite H.264, el video es %s", frame.Codec)
+					warned = true
+				}
+				continue
+			}
+			if err := r.writeFrame(frame); err != nil {
+				log.Printf("❌ Error en la grabación continua: %v", err)
+				r.finishSegment()
+			}
+		case <-ticker.C:
+			if r.source() != broker {
+				return
+			}
+			// Cerrar el segmento si el video se cortó
+			r.mutex.Lock()
+			if r.current != nil && time.Since(r.current.last) > segmentMaxGap {
+				r.finishSegmentLocked()
+			}
+			r.mutex.Unlock()
+		}
+	}
+}
+
+// writeFrame añade el frame al segmento en curso. Los segmentos empiezan
+// siempre en un keyframe y se cortan en el primero tras la duración fijada.
+func (r *SegmentRecorder) writeFrame(frame *Frame) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.current != nil && frame.Timestamp.Sub(r.current.last) > segmentMaxGap {
+		r.finishSegmentLocked()
+	}
+
+	if r.current != nil && frame.Timestamp.Sub(r.current.start) >= r.duration {
+		if frame.Keyframe {
+			r.finishSegmentLocked()
+		} else if r.requestKeyframe != nil {
+			go r.requestKeyframe()
+		}
+	}
+
+	if r.current == nil {
+		if !frame.Keyframe {
+			return nil
+		}
+		if err := r.openSegmentLocked(frame.Timestamp); err != nil {
+			return err
+		}
+	}
+
+	r.current.last = frame.Timestamp
+	pts := frame.Timestamp.Sub(r.current.start)
+	return r.current.muxer.writeVideo(pts, frame.Keyframe, frame.Data)
+}
+
+func (r *SegmentRecorder) openSegmentLocked(start time.Time) error {
+	file, err := createRecordingFile(filepath.Join(r.dir, start.Format(recordingTimeLayout)), segmentExt)
+	if err != nil {
+		return fmt.Errorf("failed to create segment: %w", err)
+	}
+	path := file.Name()
+
+	segment := &recordingSegment{
+		path:   path,
+		camera: r.camera(),
+		start:  start,
+		last:   start,
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}
+	segment.muxer = newTSMuxer(segment.writer)
+	if err := segment.muxer.writeTables(); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	r.current = segment
+	writeRecordingMeta(path, segment.camera, recordingEventContinuous, codecH264, start, time.Time{})
+	r.events.Publish(eventRecordingStarted, recordingEventData(path, segment.camera, recordingEventContinuous, start, time.Time{}))
+	return nil
+}
+
+func (r *SegmentRecorder) finishSegment() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.finishSegmentLocked()
+}
+
+// finishSegmentLocked vuelca y cierra el segmento en curso
+func (r *SegmentRecorder) finishSegmentLocked() {
+	segment := r.current
+	if segment == nil {
+		return
+	}
+	r.current = nil
+
+	if err := segment.writer.Flush(); err != nil {
+		log.Printf("⚠️  Error guardando segmento %s: %v", filepath.Base(segment.path), err)
+	}
+	segment.file.Close()
+	// La fecha de modificación marca el final del segmento
+	os.Chtimes(segment.path, segment.last, segment.last)
+	writeRecordingMeta(segment.path, segment.camera, recordingEventContinuous, codecH264, segment.start, segment.last)
+	r.events.Publish(eventRecordingFinished, recordingEventData(segment.path, segment.camera, recordingEventContinuous, segment.start, segment.last))
+	go r.prune()
+}
+
+// isRecording indica si path es el segmento en curso
+func (r *SegmentRecorder) isRecording(path string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.current != nil && r.current.path == path
+}
+
+// pruneLoop aplica la retención periódicamente
+func (r *SegmentRecorder) pruneLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(segmentPruneInterval)
+	defer ticker.Stop()
+	for {
+		r.prune()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune borra primero los segmentos más viejos que la retención y después
+// los más antiguos hasta que el total quepa en la cuota
+func (r *SegmentRecorder) prune() {
+	segments, err := r.segments()
+	if err != nil {
+		log.Printf("⚠️  Error listando segmentos: %v", err)
+		return
+	}
+
+	r.mutex.Lock()
+	current := ""
+	if r.current != nil {
+		current = filepath.Base(r.current.path)
+	}
+	r.mutex.Unlock()
+
+	var usage int64
+	for _, segment := range segments {
+		usage += segment.Size
+	}
+
+	cutoff := time.Now().Add(-r.retention)
+	for _, segment := range segments {
+		if segment.Name == current {
+			continue
+		}
+		if !segment.End.Before(cutoff) && usage <= r.quota {
+			break
+		}
+		path := filepath.Join(r.dir, segment.Name)
+		if err := os.Remove(path); err != nil {
+			log.Printf("⚠️  Error borrando segmento %s: %v", segment.Name, err)
+			continue
+		}
+		os.Remove(path + recordingMetaExt)
+		os.Remove(path + recordingThumbExt)
+		usage -= segment.Size
+		log.Printf("🧹 Segmento borrado por retención: %s", segment.Name)
+	}
+}
+
+// segments lista los segmentos guardados del más viejo al más nuevo
+func (r *SegmentRecorder) segments() ([]SegmentInfo, error) {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var segments []SegmentInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		start, ok := recordingStartFromName(strings.TrimSuffix(name, segmentExt))
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		segments = append(segments, SegmentInfo{
+			Name:  name,
+			Start: start,
+			End:   info.ModTime(),
+			Size:  info.Size(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		if !segments[i].Start.Equal(segments[j].Start) {
+			return segments[i].Start.Before(segments[j].Start)
+		}
+		return segments[i].End.Before(segments[j].End)
+	})
+	return segments, nil
+}
+
+// Status resume los segmentos guardados y el espacio en disco
+func (r *SegmentRecorder) Status() SegmentStatus {
+	r.mutex.Lock()
+	status := SegmentStatus{
+		Enabled:   r.enabled,
+		Quota:     r.quota,
+		Retention: r.retention.String(),
+	}
+	r.mutex.Unlock()
+
+	segments, err := r.segments()
+	if err != nil {
+		return status
+	}
+	status.Segments = len(segments)
+	for _, segment := range segments {
+		status.DiskUsage += segment.Size
+	}
+	if len(segments) > 0 {
+		status.Oldest = &segments[0]
+		status.Newest = &segments[len(segments)-1]
+	}
+
+	if free, ok := diskFree(r.dir); ok {
+		status.DiskFree = &free
+	}
+	return status
+}
+
+// handleContinuousStatus devuelve el estado de la grabación continua
+func (r *SegmentRecorder) handleContinuousStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, r.Status())
+}
+
+// handleContinuousConfig inicia o detiene la grabación continua: {"enabled": true}
+func (r *SegmentRecorder) handleContinuousConfig(c *gin.Context) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Enabled == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "message": "Se espera {\"enabled\": true|false}"})
+		return
+	}
+	if *req.Enabled {
+		if err := r.Start(); err != nil {
+			log.Printf("❌ No se puede iniciar la grabación continua: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "message": err.Error()})
+			return
+		}
+	} else {
+		r.Stop()
+	}
+	c.JSON(http.StatusOK, r.Status())
+}
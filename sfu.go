@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// relayKeyframeInterval limita los PLI reenviados al publicador
+const relayKeyframeInterval = 500 * time.Millisecond
+
+// trackRelay reenvía el RTP del track de un publicador a los tracks locales
+// de todos los visores suscritos, sin decodificar (estilo SFU)
+type trackRelay struct {
+	peerID          string
+	codec           webrtc.RTPCodecCapability
+	requestKeyframe func()
+
+	mutex           sync.RWMutex
+	subscribers     map[string]*webrtc.TrackLocalStaticRTP
+	lastKeyframeReq time.Time
+}
+
+func newTrackRelay(peerID string, codec webrtc.RTPCodecCapability, requestKeyframe func()) *trackRelay {
+	return &trackRelay{
+		peerID:          peerID,
+		codec:           codec,
+		requestKeyframe: requestKeyframe,
+		subscribers:     make(map[string]*webrtc.TrackLocalStaticRTP),
+	}
+}
+
+// forward escribe el paquete en el track de cada suscriptor
+func (r *trackRelay) forward(packet *rtp.Packet) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for peerID, track := range r.subscribers {
+		if err := track.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			log.Printf("⚠️  Error reenviando RTP a peer %s: %v", peerID, err)
+		}
+	}
+}
+
+func (r *trackRelay) addSubscriber(peerID string, track *webrtc.TrackLocalStaticRTP) {
+	r.mutex.Lock()
+	r.subscribers[peerID] = track
+	count := len(r.subscribers)
+	r.mutex.Unlock()
+
+	log.Printf("📡 Peer %s suscrito al publicador %s (%d visores)", peerID, r.peerID, count)
+
+	// El nuevo visor necesita un keyframe para empezar a decodificar
+	r.forceKeyframe()
+}
+
+func (r *trackRelay) removeSubscriber(peerID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.subscribers[peerID]; exists {
+		delete(r.subscribers, peerID)
+		log.Printf("📡 Peer %s dejó de ver al publicador %s", peerID, r.peerID)
+	}
+}
+
+// keyframe pide un keyframe al publicador respetando relayKeyframeInterval
+func (r *trackRelay) keyframe() {
+	r.mutex.Lock()
+	if time.Since(r.lastKeyframeReq) < relayKeyframeInterval {
+		r.mutex.Unlock()
+		return
+	}
+	r.lastKeyframeReq = time.Now()
+	r.mutex.Unlock()
+
+	r.requestKeyframe()
+}
+
+// forceKeyframe pide un keyframe al publicador sin esperar el intervalo
+func (r *trackRelay) forceKeyframe() {
+	r.mutex.Lock()
+	r.lastKeyframeReq = time.Time{}
+	r.mutex.Unlock()
+	r.keyframe()
+}
+
+// setPublisher registra el track de video de un peer como el que se reenvía
+func (w *WebRTCManager) setPublisher(peerID string, track *webrtc.TrackRemote, requestKeyframe func()) *trackRelay {
+	relay := newTrackRelay(peerID, track.Codec().RTPCodecCapability, requestKeyframe)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	previous := w.relay
+
+	if previous != nil {
+		log.Printf("🔀 Publicador %s reemplaza a %s en el reenvío", peerID, previous.peerID)
+
+		// Los visores del publicador anterior pasan al nuevo si el códec
+		// coincide. Se mueven antes de publicar el relay para que nadie lo
+		// use mientras se llena su mapa de visores.
+		if strings.EqualFold(previous.codec.MimeType, relay.codec.MimeType) {
+			previous.mutex.Lock()
+			for viewerID, localTrack := range previous.subscribers {
+				relay.subscribers[viewerID] = localTrack
+			}
+			previous.subscribers = make(map[string]*webrtc.TrackLocalStaticRTP)
+			previous.mutex.Unlock()
+		}
+	}
+	w.relay = relay
+	return relay
+}
+
+// clearPublisher quita el relay si sigue siendo el activo
+func (w *WebRTCManager) clearPublisher(relay *trackRelay) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.relay == relay {
+		w.relay = nil
+	}
+}
+
+// activeRelay devuelve el relay del publicador actual o nil
+func (w *WebRTCManager) activeRelay() *trackRelay {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.relay
+}
+
+// startRelay suscribe al visor al publicador cuando la conexión está lista
+func (w *WebRTCManager) startRelay(peerID string, pc *webrtc.PeerConnection, relay *trackRelay, track *webrtc.TrackLocalStaticRTP) {
+	if !w.waitForViewer(peerID, pc) {
+		return
+	}
+	relay.addSubscriber(peerID, track)
+
+	// Evitar dejar un suscriptor colgado si el peer se cerró mientras tanto
+	if isPeerFinished(pc) {
+		relay.removeSubscriber(peerID)
+	}
+}
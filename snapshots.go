@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// snapshotMinInterval evita programar capturas demasiado seguidas
+	snapshotMinInterval = 10 * time.Second
+	snapshotIndexName   = "index.json"
+	snapshotNameLayout  = "150405"
+	snapshotDateLayout  = "2006-01-02"
+	// snapshotGalleryLimit es el número de fotos por defecto de la galería
+	snapshotGalleryLimit = 100
+)
+
+// SnapshotArchive toma fotos según un intervalo o una expresión cron y las
+// guarda en snapshots/AAAA/MM/DD/HHMMSS.jpg con un index.json por día
+type SnapshotArchive struct {
+	dir     string
+	capture func() ([]byte, error)
+	events  *EventBus
+	wake    chan struct{}
+
+	mutex    sync.Mutex
+	interval time.Duration
+	cron     *cronSchedule
+	next     time.Time
+	latest   *SnapshotEntry
+	count    int
+	failures int
+}
+
+// SnapshotEntry es una foto del archivo tal como aparece en el índice
+type SnapshotEntry struct {
+	Name string    `json:"name"`
+	Date string    `json:"date"`
+	Time time.Time `json:"time"`
+	Size int       `json:"size"`
+	URL  string    `json:"url"`
+}
+
+// SnapshotSchedule es la programación que se lee y cambia por la API.
+// Interval es una duración de Go ("5m"); vacío y sin cron desactiva.
+type SnapshotSchedule struct {
+	Interval string         `json:"interval,omitempty"`
+	Cron     string         `json:"cron,omitempty"`
+	Enabled  bool           `json:"enabled"`
+	Next     time.Time      `json:"next,omitempty"`
+	Count    int            `json:"count"`
+	Failures int            `json:"failures"`
+	Latest   *SnapshotEntry `json:"latest,omitempty"`
+}
+
+// NewSnapshotArchive crea el archivo con la programación de
+// ALIEN_CAM_SNAPSHOT_INTERVAL o ALIEN_CAM_SNAPSHOT_CRON
+func NewSnapshotArchive(dir string, capture func() ([]byte, error)) *SnapshotArchive {
+	a := &SnapshotArchive{
+		dir:     dir,
+		capture: capture,
+		wake:    make(chan struct{}, 1),
+	}
+	a.latest = a.findLatest()
+
+	config := SnapshotSchedule{
+		Interval: os.Getenv("ALIEN_CAM_SNAPSHOT_INTERVAL"),
+		Cron:     os.Getenv("ALIEN_CAM_SNAPSHOT_CRON"),
+	}
+	if config.Interval != "" || config.Cron != "" {
+		if err := a.Configure(config); err != nil {
+			log.Printf("❌ Programación de fotos inválida: %v", err)
+		}
+	}
+
+	go a.run()
+	return a
+}
+
+// Configure cambia la programación; solo puede haber intervalo o cron
+func (a *SnapshotArchive) Configure(config SnapshotSchedule) error {
+	var interval time.Duration
+	var cron *cronSchedule
+	switch {
+	case config.Interval != "" && config.Cron != "":
+		return fmt.Errorf("use either interval or cron, not both")
+	case config.Interval != "":
+		d, err := time.ParseDuration(config.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %v", err)
+		}
+		if d < snapshotMinInterval {
+			return fmt.Errorf("interval must be at least %v", snapshotMinInterval)
+		}
+		interval = d
+	case config.Cron != "":
+		var err error
+		if cron, err = parseCron(config.Cron); err != nil {
+			return err
+		}
+		if cron.next(time.Now()).IsZero() {
+			return fmt.Errorf("cron expression never runs within a year: %q", config.Cron)
+		}
+	}
+
+	a.mutex.Lock()
+	a.interval = interval
+	a.cron = cron
+	a.next = a.nextLocked(time.Now())
+	a.mutex.Unlock()
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+
+	switch {
+	case interval > 0:
+		log.Printf("📸 Fotos programadas cada %v", interval)
+	case cron != nil:
+		log.Printf("📸 Fotos programadas con cron %q", cron)
+	default:
+		log.Printf("📸 Fotos programadas desactivadas")
+	}
+	return nil
+}
+
+// nextLocked calcula la siguiente captura. Los intervalos se alinean al
+// reloj (cada 5m cae en :00, :05...) para que las fotos sean comparables.
+func (a *SnapshotArchive) nextLocked(now time.Time) time.Time {
+	switch {
+	case a.interval > 0:
+		return now.Truncate(a.interval).Add(a.interval)
+	case a.cron != nil:
+		return a.cron.next(now)
+	default:
+		return time.Time{}
+	}
+}
+
+func (a *SnapshotArchive) run() {
+	for {
+		a.mutex.Lock()
+		a.next = a.nextLocked(time.Now())
+		next := a.next
+		a.mutex.Unlock()
+
+		if next.IsZero() {
+			<-a.wake
+			continue
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			if _, err := a.Capture(); err != nil {
+				log.Printf("⚠️  Foto programada fallida: %v", err)
+			}
+		case <-a.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// Capture toma una foto y la añade al archivo y al índice del día
+func (a *SnapshotArchive) Capture() (*SnapshotEntry, error) {
+	data, err := a.capture()
+	if err != nil {
+		a.mutex.Lock()
+		a.failures++
+		a.mutex.Unlock()
+		return nil, err
+	}
+
+	now := time.Now()
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	dayDir := filepath.Join(a.dir, now.Format("2006"), now.Format("01"), now.Format("02"))
+	if err := os.MkdirAll(dayDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot dir: %w", err)
+	}
+
+	// Dos fotos en el mismo segundo (programada y manual) no se pisan
+	name := now.Format(snapshotNameLayout) + ".jpg"
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(dayDir, name)); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s-%d.jpg", now.Format(snapshotNameLayout), i)
+	}
+	if err := os.WriteFile(filepath.Join(dayDir, name), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	date := now.Format(snapshotDateLayout)
+	entry := SnapshotEntry{
+		Name: name,
+		Date: date,
+		Time: now,
+		Size: len(data),
+		URL:  "/api/snapshots/file/" + date + "/" + name,
+	}
+	index := readSnapshotIndex(dayDir)
+	index = append(index, entry)
+	if err := writeSnapshotIndex(dayDir, index); err != nil {
+		log.Printf("⚠️  Error actualizando índice de fotos: %v", err)
+	}
+
+	a.latest = &entry
+	a.count++
+	a.events.Publish(eventSnapshotTaken, entry)
+	log.Printf("📸 Foto archivada: %s/%s (%d bytes)", date, name, len(data))
+	return &entry, nil
+}
+
+// dayDir devuelve el directorio de una fecha AAAA-MM-DD
+func (a *SnapshotArchive) dayDir(date string) (string, error) {
+	day, err := time.Parse(snapshotDateLayout, date)
+	if err != nil {
+		return "", fmt.Errorf("invalid date: %s", date)
+	}
+	return filepath.Join(a.dir, day.Format("2006"), day.Format("01"), day.Format("02")), nil
+}
+
+func readSnapshotIndex(dayDir string) []SnapshotEntry {
+	data, err := os.ReadFile(filepath.Join(dayDir, snapshotIndexName))
+	if err != nil {
+		return nil
+	}
+	var index []SnapshotEntry
+	if err := json.Unmarshal(data, &index); err != nil {
+		log.Printf("⚠️  Índice de fotos dañado en %s: %v", dayDir, err)
+		return nil
+	}
+	return index
+}
+
+// writeSnapshotIndex reemplaza el índice de forma atómica
+func writeSnapshotIndex(dayDir string, index []SnapshotEntry) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dayDir, snapshotIndexName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dayDir, snapshotIndexName))
+}
+
+// Days devuelve las fechas con fotos, de la más reciente a la más antigua
+func (a *SnapshotArchive) Days() []string {
+	matches, _ := filepath.Glob(filepath.Join(a.dir, "*", "*", "*", snapshotIndexName))
+	days := make([]string, 0, len(matches))
+	for _, match := range matches {
+		rel, err := filepath.Rel(a.dir, filepath.Dir(match))
+		if err != nil {
+			continue
+		}
+		date := strings.ReplaceAll(filepath.ToSlash(rel), "/", "-")
+		if _, err := time.Parse(snapshotDateLayout, date); err == nil {
+			days = append(days, date)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(days)))
+	return days
+}
+
+// findLatest busca la última foto archivada al arrancar
+func (a *SnapshotArchive) findLatest() *SnapshotEntry {
+	for _, date := range a.Days() {
+		dir, err := a.dayDir(date)
+		if err != nil {
+			continue
+		}
+		if index := readSnapshotIndex(dir); len(index) > 0 {
+			latest := index[len(index)-1]
+			return &latest
+		}
+	}
+	return nil
+}
+
+// Schedule devuelve la programación actual y la última foto
+func (a *SnapshotArchive) Schedule() SnapshotSchedule {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	schedule := SnapshotSchedule{
+		Enabled:  a.interval > 0 || a.cron != nil,
+		Next:     a.next,
+		Count:    a.count,
+		Failures: a.failures,
+		Latest:   a.latest,
+	}
+	if a.interval > 0 {
+		schedule.Interval = a.interval.String()
+	}
+	if a.cron != nil {
+		schedule.Cron = a.cron.String()
+	}
+	return schedule
+}
+
+// handleSnapshotGallery lista las fotos de un día (por defecto el último
+// con fotos), de la más nueva a la más vieja, y los días disponibles
+func (a *SnapshotArchive) handleSnapshotGallery(c *gin.Context) {
+	days := a.Days()
+	date := c.Query("date")
+	if date == "" && len(days) > 0 {
+		date = days[0]
+	}
+
+	limit := snapshotGalleryLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit inválido: %s", value)})
+			return
+		}
+		limit = n
+	}
+
+	snapshots := []SnapshotEntry{}
+	if date != "" {
+		dir, err := a.dayDir(date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		a.mutex.Lock()
+		index := readSnapshotIndex(dir)
+		a.mutex.Unlock()
+		for i := len(index) - 1; i >= 0 && len(snapshots) < limit; i-- {
+			snapshots = append(snapshots, index[i])
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":      date,
+		"days":      days,
+		"snapshots": snapshots,
+	})
+}
+
+// handleLatestSnapshot sirve la última foto archivada
+func (a *SnapshotArchive) handleLatestSnapshot(c *gin.Context) {
+	a.mutex.Lock()
+	latest := a.latest
+	a.mutex.Unlock()
+	if latest == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no snapshots archived yet"})
+		return
+	}
+	dir, err := a.dayDir(latest.Date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Snapshot-Time", latest.Time.Format(time.RFC3339))
+	c.File(filepath.Join(dir, latest.Name))
+}
+
+// handleSnapshotFile sirve una foto del archivo por fecha y nombre
+func (a *SnapshotArchive) handleSnapshotFile(c *gin.Context) {
+	dir, err := a.dayDir(c.Param("date"))
+	name := c.Param("name")
+	if err != nil || name != filepath.Base(name) || !strings.HasSuffix(name, ".jpg") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid snapshot"})
+		return
+	}
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "snapshot not found"})
+		return
+	}
+	c.Header("Cache-Control", "max-age=86400")
+	c.File(path)
+}
+
+// handleTakeSnapshot archiva una foto en el momento
+func (a *SnapshotArchive) handleTakeSnapshot(c *gin.Context) {
+	entry, err := a.Capture()
+	if err != nil {
+		log.Printf("❌ No se puede archivar la foto: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, entry)
+}
+
+// handleSnapshotSchedule devuelve la programación actual
+func (a *SnapshotArchive) handleSnapshotSchedule(c *gin.Context) {
+	c.JSON(http.StatusOK, a.Schedule())
+}
+
+// handleSnapshotScheduleConfig cambia la programación:
+// {"interval": "5m"}, {"cron": "0 * * * *"} o {} para desactivar
+func (a *SnapshotArchive) handleSnapshotScheduleConfig(c *gin.Context) {
+	var config SnapshotSchedule
+	if err := json.NewDecoder(c.Request.Body).Decode(&config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("JSON inválido: %v", err)})
+		return
+	}
+	if err := a.Configure(config); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, a.Schedule())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// sseBuffer es cuántos eventos puede acumular un cliente lento antes de
+	// que se le corte la conexión para que se reponga desde el historial
+	sseBuffer = 64
+	// sseKeepAlive mantiene viva la conexión a través de proxies
+	sseKeepAlive = 15 * time.Second
+	// sseRetry es la espera que se pide al navegador antes de reconectar
+	sseRetry = 3 * time.Second
+)
+
+// handleEventStream envía los eventos del bus como Server-Sent Events. Con
+// la cabecera Last-Event-ID (o ?since=) se reenvía primero lo que el
+// cliente se perdió del historial; ?type= filtra igual que /api/events.
+func (b *EventBus) handleEventStream(c *gin.Context) {
+	var since uint64
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("since")
+	}
+	if value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid event id: " + value})
+			return
+		}
+		since = n
+	}
+	types := parseEventTypes(c.Query("type"))
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "streaming not supported"})
+		return
+	}
+
+	// Suscribirse antes de leer el historial para no perder nada entre medias
+	sub := b.Subscribe(sseBuffer, types...)
+	defer sub.Close()
+
+	w := c.Writer
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("📡 Cliente de eventos conectado desde %s", c.Request.RemoteAddr)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+
+	// Sin since no se repite el historial, pero lo que ya está en sub.C se
+	// publicó después de suscribirse y se envía entero
+	last := since
+	if since > 0 {
+		missed, _ := b.History(since, types, 0)
+		for _, event := range missed {
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+			last = event.ID
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			log.Printf("📡 Cliente de eventos desconectado desde %s", c.Request.RemoteAddr)
+			return
+		case event := <-sub.C:
+			// Si se perdieron eventos se corta antes de escribir más: el
+			// navegador reconecta con Last-Event-ID y los recupera del historial
+			if sub.Dropped() > 0 {
+				log.Printf("⚠️  Cliente de eventos lento, se fuerza la reconexión")
+				return
+			}
+			if event.ID <= last {
+				continue
+			}
+			if err := writeSSEEvent(w, event); err != nil {
+				return
+			}
+			last = event.ID
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSEEvent escribe un evento con su número, tipo y datos en JSON
+func writeSSEEvent(w http.ResponseWriter, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pausedWriter retiene la primera escritura del handler hasta que el test
+// lo suelta, para publicar justo después de que se haya suscrito
+type pausedWriter struct {
+	header  http.Header
+	pipe    *io.PipeWriter
+	paused  chan struct{}
+	release chan struct{}
+	first   bool
+}
+
+func (w *pausedWriter) Header() http.Header { return w.header }
+func (w *pausedWriter) WriteHeader(int)     {}
+func (w *pausedWriter) Flush()              {}
+
+func (w *pausedWriter) Write(data []byte) (int, error) {
+	if !w.first {
+		w.first = true
+		close(w.paused)
+		<-w.release
+	}
+	return w.pipe.Write(data)
+}
+
+// readSSEIDs lee los "id:" de los primeros n eventos del stream
+func readSSEIDs(t *testing.T, reader *bufio.Reader, n int) []string {
+	t.Helper()
+	var ids []string
+	for len(ids) < n {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended after %v: %v", ids, err)
+		}
+		if id, ok := strings.CutPrefix(strings.TrimSpace(line), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestEventStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		header string
+		// want son los ids esperados tras 3 eventos viejos y 2 publicados
+		// mientras el handler prepara el stream
+		want []string
+	}{
+		{"live only", "", []string{"4", "5"}},
+		{"resume", "2", []string{"3", "4", "5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := NewEventBus()
+			for i := 0; i < 3; i++ {
+				bus.Publish(eventMotionStart, nil)
+			}
+
+			reader, pipe := io.Pipe()
+			w := &pausedWriter{
+				header:  make(http.Header),
+				pipe:    pipe,
+				paused:  make(chan struct{}),
+				release: make(chan struct{}),
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/events/stream", nil).WithContext(ctx)
+			if tt.header != "" {
+				c.Request.Header.Set("Last-Event-ID", tt.header)
+			}
+			done := make(chan struct{})
+			go func() {
+				bus.handleEventStream(c)
+				close(done)
+			}()
+			defer func() {
+				cancel()
+				reader.Close()
+				<-done
+			}()
+
+			// El handler ya está suscrito cuando escribe por primera vez
+			<-w.paused
+			bus.Publish(eventMotionEnd, nil)
+			bus.Publish(eventMotionStart, nil)
+			close(w.release)
+
+			// Si se pierde un evento la lectura se queda esperando: se corta
+			timer := time.AfterFunc(2*time.Second, func() { reader.Close() })
+			defer timer.Stop()
+			ids := readSSEIDs(t, bufio.NewReader(reader), len(tt.want))
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ids = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// TermuxCameraSource captura fotos usando los comandos de Termux:API
+type TermuxCameraSource struct {
+	cameraID string
+	mutex    sync.Mutex
+	ready    bool
+	cameras  []termuxCameraInfo
+}
+
+// termuxCameraInfo refleja una entrada de la salida de termux-camera-info
+type termuxCameraInfo struct {
+	ID              string `json:"id"`
+	Facing          string `json:"facing"`
+	JpegOutputSizes []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"jpeg_output_sizes"`
+}
+
+func NewTermuxCameraSource(cameraID string) *TermuxCameraSource {
+	return &TermuxCameraSource{cameraID: cameraID}
+}
+
+func (t *TermuxCameraSource) Open() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.probe()
+}
+
+// probe verifica el entorno y consulta las cámaras disponibles.
+// Debe llamarse con el mutex tomado.
+func (t *TermuxCameraSource) probe() error {
+	// Verificar si estamos en Android/Termux
+	if !isAndroidEnvironment() {
+		log.Println("❌ No se detectó entorno Android/Termux")
+		return fmt.Errorf("not in android environment")
+	}
+
+	log.Println("✅ Entorno Android/Termux detectado")
+
+	// Verificar si Termux:API está disponible
+	if !isCommandAvailable("termux-camera-info") {
+		log.Println("❌ Termux:API no está disponible")
+		log.Println("💡 Solución: pkg install termux-api e instalar Termux:API desde F-Droid")
+		return fmt.Errorf("termux:api not available")
+	}
+
+	log.Println("✅ Termux:API detectado")
+
+	// Probar obtener información de cámaras
+	output, err := exec.Command("termux-camera-info").Output()
+	if err != nil {
+		log.Printf("❌ Error al obtener info de cámaras: %v", err)
+		log.Println("💡 Verifica permisos de cámara en Ajustes > Aplicaciones > Termux")
+		return fmt.Errorf("camera info failed: %v", err)
+	}
+	log.Printf("📷 Cámaras detectadas: %s", string(output))
+
+	var cameras []termuxCameraInfo
+	if err := json.Unmarshal(output, &cameras); err != nil {
+		log.Printf("⚠️  No se pudo interpretar termux-camera-info: %v", err)
+	}
+	t.cameras = cameras
+	t.ready = true
+	return nil
+}
+
+func (t *TermuxCameraSource) CaptureFrame() ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	log.Println("🔍 Iniciando captura de imagen...")
+
+	if !t.ready {
+		if err := t.probe(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Obtener directorio temporal seguro para Android
+	tmpDir := getTempDir()
+	tmpFile := filepath.Join(tmpDir, "alien_cam_temp.jpg")
+
+	log.Printf("📁 Directorio temporal: %s", tmpDir)
+
+	// Capturar imagen con Termux:API (sintaxis correcta)
+	log.Println("📸 Capturando imagen...")
+	cmd := exec.Command("termux-camera-photo", "-c", t.cameraID, tmpFile)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("❌ Error al capturar imagen: %v", err)
+		log.Printf("📄 Salida del comando: %s", string(output))
+		log.Println("💡 Verifica:")
+		log.Println("   - Permisos de cámara concedidos a Termux")
+		log.Println("   - La cámara no está siendo usada por otra app")
+		log.Println("   - El dispositivo tiene cámara funcional")
+		// Volver a verificar el entorno en la siguiente captura
+		t.ready = false
+		return nil, fmt.Errorf("camera capture failed: %v", err)
+	}
+
+	log.Println("✅ Imagen capturada exitosamente")
+
+	// Verificar si el archivo existe
+	if _, err := os.Stat(tmpFile); os.IsNotExist(err) {
+		log.Printf("❌ El archivo de imagen no existe: %s", tmpFile)
+		return nil, fmt.Errorf("image file not created")
+	}
+
+	// Leer la imagen capturada
+	imgData, err := os.ReadFile(tmpFile)
+	if err != nil {
+		log.Printf("❌ Error al leer archivo de imagen: %v", err)
+		return nil, fmt.Errorf("failed to read image: %v", err)
+	}
+
+	// Limpiar archivo temporal
+	if err := os.Remove(tmpFile); err != nil {
+		log.Printf("⚠️  No se pudo eliminar archivo temporal: %v", err)
+	} else {
+		log.Println("🗑️  Archivo temporal eliminado")
+	}
+
+	log.Printf("✅ Imagen leída correctamente (%d bytes)", len(imgData))
+	return imgData, nil
+}
+
+func (t *TermuxCameraSource) Capabilities() CameraCapabilities {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	caps := CameraCapabilities{
+		ID:         "termux:" + t.cameraID,
+		Name:       "Termux Camera",
+		Backend:    "termux",
+		Resolution: "640x480",
+		Formats:    []string{"image/jpeg"},
+	}
+
+	for _, camera := range t.cameras {
+		if camera.ID != t.cameraID {
+			continue
+		}
+		caps.Name = fmt.Sprintf("Termux Camera %s (%s)", camera.ID, camera.Facing)
+		for _, size := range camera.JpegOutputSizes {
+			caps.Resolutions = append(caps.Resolutions, fmt.Sprintf("%dx%d", size.Width, size.Height))
+		}
+		if len(caps.Resolutions) > 0 {
+			caps.Resolution = caps.Resolutions[0]
+		}
+	}
+	return caps
+}
+
+func (t *TermuxCameraSource) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.ready = false
+	return nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// TestPatternSource genera frames JPEG sintéticos (barras de color, una caja
+// en movimiento, contador de frames y hora) para desarrollo sin cámara real.
+type TestPatternSource struct {
+	width    int
+	height   int
+	fps      int
+	mutex    sync.Mutex
+	frame    uint64
+	started  time.Time
+	nextShot time.Time
+	bars     *image.RGBA
+}
+
+// testPatternMaxSize limita cada lado de la imagen generada
+const testPatternMaxSize = 8192
+
+// Barras de color estilo SMPTE
+var testPatternBars = []color.RGBA{
+	{192, 192, 192, 255},
+	{192, 192, 0, 255},
+	{0, 192, 192, 255},
+	{0, 192, 0, 255},
+	{192, 0, 192, 255},
+	{192, 0, 0, 255},
+	{0, 0, 192, 255},
+}
+
+func NewTestPatternSource(width, height, fps int) (*TestPatternSource, error) {
+	if width <= 0 || height <= 0 || width > testPatternMaxSize || height > testPatternMaxSize {
+		return nil, fmt.Errorf("invalid resolution: %dx%d", width, height)
+	}
+	if fps <= 0 {
+		return nil, fmt.Errorf("invalid fps: %d", fps)
+	}
+	return &TestPatternSource{width: width, height: height, fps: fps}, nil
+}
+
+func (t *TestPatternSource) Open() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.bars == nil {
+		t.bars = t.renderBars()
+	}
+	if t.started.IsZero() {
+		t.started = time.Now()
+	}
+	return nil
+}
+
+func (t *TestPatternSource) CaptureFrame() ([]byte, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.bars == nil {
+		t.bars = t.renderBars()
+		t.started = time.Now()
+	}
+
+	// Respetar la tasa de frames configurada
+	now := time.Now()
+	if wait := t.nextShot.Sub(now); wait > 0 {
+		time.Sleep(wait)
+		now = time.Now()
+	}
+	t.nextShot = now.Add(time.Second / time.Duration(t.fps))
+	t.frame++
+
+	img := image.NewRGBA(t.bars.Bounds())
+	draw.Draw(img, img.Bounds(), t.bars, image.Point{}, draw.Src)
+
+	// Caja que rebota por la imagen
+	box := t.height / 6
+	elapsed := now.Sub(t.started).Seconds()
+	x := bounce(elapsed*float64(t.width)/4, t.width-box)
+	y := bounce(elapsed*float64(t.height)/3, t.height-box)
+	draw.Draw(img, image.Rect(x, y, x+box, y+box), image.NewUniform(color.RGBA{255, 255, 255, 255}), image.Point{}, draw.Src)
+	inner := box / 4
+	draw.Draw(img, image.Rect(x+inner, y+inner, x+box-inner, y+box-inner), image.NewUniform(color.RGBA{255, 64, 0, 255}), image.Point{}, draw.Src)
+
+	// Textos incrustados
+	scale := t.height / 240
+	if scale < 1 {
+		scale = 1
+	}
+	drawLabel(img, fmt.Sprintf("FRAME %06d", t.frame), 8*scale, 8*scale, scale)
+	drawLabel(img, now.Format("2006-01-02 15:04:05.000"), 8*scale, t.height-24*scale, scale)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("jpeg encode failed: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *TestPatternSource) Capabilities() CameraCapabilities {
+	resolution := fmt.Sprintf("%dx%d", t.width, t.height)
+	return CameraCapabilities{
+		ID:          "testpattern",
+		Name:        "Test Pattern",
+		Backend:     "testpattern",
+		Resolution:  resolution,
+		Resolutions: []string{resolution},
+		Formats:     []string{"image/jpeg"},
+		MaxFPS:      t.fps,
+	}
+}
+
+func (t *TestPatternSource) Close() error {
+	return nil
+}
+
+// renderBars dibuja el fondo fijo: barras de color arriba y rampa de grises abajo
+func (t *TestPatternSource) renderBars() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, t.width, t.height))
+	barsHeight := t.height * 2 / 3
+	for i, c := range testPatternBars {
+		x0 := i * t.width / len(testPatternBars)
+		x1 := (i + 1) * t.width / len(testPatternBars)
+		draw.Draw(img, image.Rect(x0, 0, x1, barsHeight), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	for x := 0; x < t.width; x++ {
+		v := uint8(x * 255 / t.width)
+		for y := barsHeight; y < t.height; y++ {
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+// bounce convierte una posición lineal en un ir y venir dentro de [0, limit]
+func bounce(pos float64, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	p := int(pos) % (2 * limit)
+	if p > limit {
+		p = 2*limit - p
+	}
+	return p
+}
+
+// drawLabel escribe texto sobre un fondo negro, escalado por un factor entero
+func drawLabel(dst *image.RGBA, text string, x, y, scale int) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil() + 4
+	height := face.Height + 2
+
+	label := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(label, label.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	drawer := &font.Drawer{
+		Dst:  label,
+		Src:  image.NewUniform(color.RGBA{255, 255, 255, 255}),
+		Face: face,
+		Dot:  fixed.P(2, face.Ascent+1),
+	}
+	drawer.DrawString(text)
+
+	for ly := 0; ly < height; ly++ {
+		for lx := 0; lx < width; lx++ {
+			c := label.RGBAAt(lx, ly)
+			rect := image.Rect(x+lx*scale, y+ly*scale, x+(lx+1)*scale, y+(ly+1)*scale)
+			draw.Draw(dst, rect.Intersect(dst.Bounds()), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+}
+
+// parseResolution interpreta cadenas como "1280x720"
+func parseResolution(value string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(value), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid resolution: %s", value)
+	}
+	width, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid resolution: %s", value)
+	}
+	height, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid resolution: %s", value)
+	}
+	if width <= 0 || height <= 0 || width > testPatternMaxSize || height > testPatternMaxSize {
+		return 0, 0, fmt.Errorf("invalid resolution: %s (1-%d per side)", value, testPatternMaxSize)
+	}
+	return width, height, nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// timelapseMinInterval evita saturar la cámara con capturas seguidas
+	timelapseMinInterval = time.Second
+	// timelapseMaxFrames limita el número de frames de un trabajo
+	timelapseMaxFrames = 100000
+	// timelapseDefaultFPS es la velocidad del video ensamblado
+	timelapseDefaultFPS = 24
+	timelapseVideoName  = "timelapse.avi"
+	timelapseFrameName  = "frame_%06d.jpg"
+)
+
+// Estados de un trabajo de time-lapse
+const (
+	timelapseCapturing  = "capturing"
+	timelapseAssembling = "assembling"
+	timelapseDone       = "done"
+	timelapseFailed     = "failed"
+)
+
+// timelapseNamePattern limita los nombres de directorio elegidos por el cliente
+var timelapseNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// TimelapseManager ejecuta trabajos que capturan un JPEG cada cierto
+// intervalo, los guardan numerados y al terminar los ensamblan en un AVI
+type TimelapseManager struct {
+	dir     string
+	capture func() ([]byte, error)
+	events  *EventBus
+
+	mutex sync.Mutex
+	jobs  map[string]*TimelapseJob
+}
+
+// TimelapseJob es el estado de un trabajo que se expone por la API
+type TimelapseJob struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Interval  float64   `json:"interval"`
+	Duration  float64   `json:"duration"`
+	FPS       int       `json:"fps"`
+	State     string    `json:"state"`
+	Frames    int       `json:"frames"`
+	Expected  int       `json:"expected"`
+	Errors    int       `json:"errors"`
+	LastError string    `json:"lastError,omitempty"`
+	Progress  float64   `json:"progress"`
+	Assembled int       `json:"assembled"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitempty"`
+	Video     string    `json:"video,omitempty"`
+
+	dir  string
+	stop chan struct{}
+}
+
+// TimelapseRequest es el cuerpo de POST /api/timelapse (en segundos)
+type TimelapseRequest struct {
+	Interval float64 `json:"interval"`
+	Duration float64 `json:"duration"`
+	FPS      int     `json:"fps"`
+	Name     string  `json:"name"`
+}
+
+func NewTimelapseManager(dir string, capture func() ([]byte, error)) *TimelapseManager {
+	return &TimelapseManager{
+		dir:     dir,
+		capture: capture,
+		jobs:    make(map[string]*TimelapseJob),
+	}
+}
+
+// Start valida la petición y lanza el trabajo en segundo plano
+func (m *TimelapseManager) Start(req TimelapseRequest) (*TimelapseJob, error) {
+	interval := time.Duration(req.Interval * float64(time.Second))
+	duration := time.Duration(req.Duration * float64(time.Second))
+	if interval < timelapseMinInterval {
+		return nil, fmt.Errorf("interval must be at least %v", timelapseMinInterval)
+	}
+	if duration < interval {
+		return nil, fmt.Errorf("duration must be at least one interval")
+	}
+	expected := int(duration/interval) + 1
+	if expected > timelapseMaxFrames {
+		return nil, fmt.Errorf("too many frames: %d (max %d)", expected, timelapseMaxFrames)
+	}
+	fps := req.FPS
+	if fps == 0 {
+		fps = timelapseDefaultFPS
+	}
+	if fps < 1 || fps > 60 {
+		return nil, fmt.Errorf("fps must be between 1 and 60")
+	}
+
+	id := newSessionID("tl")
+	name := req.Name
+	if name == "" {
+		name = "timelapse_" + time.Now().Format(recordingTimeLayout)
+	}
+	if !timelapseNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid name: %s", name)
+	}
+	dir := filepath.Join(m.dir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return nil, fmt.Errorf("output directory already exists: %s", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output dir: %w", err)
+	}
+
+	job := &TimelapseJob{
+		ID:       id,
+		Name:     name,
+		Interval: interval.Seconds(),
+		Duration: duration.Seconds(),
+		FPS:      fps,
+		State:    timelapseCapturing,
+		Expected: expected,
+		Started:  time.Now(),
+		dir:      dir,
+		stop:     make(chan struct{}),
+	}
+
+	m.mutex.Lock()
+	m.jobs[id] = job
+	snapshot := *job
+	m.mutex.Unlock()
+
+	go m.run(job, interval)
+	log.Printf("⏱️  Time-lapse %s iniciado: un frame cada %v durante %v en %s", id, interval, duration, dir)
+	return &snapshot, nil
+}
+
+// run captura los frames y ensambla el video al terminar o al detenerse
+func (m *TimelapseManager) run(job *TimelapseJob, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// El primer frame es inmediato y el último coincide con el final
+	m.captureFrame(job)
+capture:
+	for attempts := 1; attempts < job.Expected; attempts++ {
+		select {
+		case <-ticker.C:
+			m.captureFrame(job)
+		case <-job.stop:
+			break capture
+		}
+	}
+
+	m.mutex.Lock()
+	job.State = timelapseAssembling
+	m.mutex.Unlock()
+
+	err := m.assemble(job)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job.Finished = time.Now()
+	if err != nil {
+		job.State = timelapseFailed
+		job.LastError = err.Error()
+		m.events.Publish(eventTimelapseFinished, *job)
+		log.Printf("❌ Time-lapse %s sin video: %v", job.ID, err)
+		return
+	}
+	job.State = timelapseDone
+	job.Video = "/api/timelapse/" + job.ID + "/video"
+	m.events.Publish(eventTimelapseFinished, *job)
+	log.Printf("🎞️  Time-lapse %s terminado: %d frames en %s", job.ID, job.Frames, filepath.Join(job.dir, timelapseVideoName))
+}
+
+// captureFrame guarda el siguiente frame numerado
+func (m *TimelapseManager) captureFrame(job *TimelapseJob) {
+	data, err := m.capture()
+	if err == nil {
+		m.mutex.Lock()
+		index := job.Frames + 1
+		m.mutex.Unlock()
+		err = os.WriteFile(filepath.Join(job.dir, fmt.Sprintf(timelapseFrameName, index)), data, 0644)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err != nil {
+		job.Errors++
+		job.LastError = err.Error()
+		log.Printf("⚠️  Time-lapse %s: captura fallida: %v", job.ID, err)
+		return
+	}
+	job.Frames++
+	job.Progress = float64(job.Frames) / float64(job.Expected)
+}
+
+// assemble junta los frames guardados en un AVI MJPEG sin recodificar
+func (m *TimelapseManager) assemble(job *TimelapseJob) error {
+	frames, err := filepath.Glob(filepath.Join(job.dir, "frame_*.jpg"))
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("no frames captured")
+	}
+	sort.Strings(frames)
+
+	first, err := os.ReadFile(frames[0])
+	if err != nil {
+		return err
+	}
+	writer, err := newAVIWriter(filepath.Join(job.dir, timelapseVideoName), first, job.FPS)
+	if err != nil {
+		return err
+	}
+
+	for i, path := range frames {
+		data := first
+		if i > 0 {
+			if data, err = os.ReadFile(path); err != nil {
+				writer.Close()
+				return err
+			}
+		}
+		if err := writer.WriteFrame(data); err != nil {
+			if err == errAVIFull {
+				// El video queda con los frames que caben en el AVI
+				log.Printf("⚠️  Time-lapse %s: el AVI llegó a 4 GB, se guarda con %d de %d frames", job.ID, i, len(frames))
+				m.mutex.Lock()
+				job.LastError = fmt.Sprintf("video truncated to %d of %d frames (4 GB AVI limit)", i, len(frames))
+				m.mutex.Unlock()
+				break
+			}
+			writer.Close()
+			return err
+		}
+		m.mutex.Lock()
+		job.Assembled = i + 1
+		m.mutex.Unlock()
+	}
+	return writer.Close()
+}
+
+// Stop termina la captura antes de tiempo; el video se ensambla igual
+func (m *TimelapseManager) Stop(id string) (*TimelapseJob, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if job.State == timelapseCapturing {
+		select {
+		case <-job.stop:
+		default:
+			close(job.stop)
+			log.Printf("⏹️  Time-lapse %s detenido", id)
+		}
+	}
+	snapshot := *job
+	return &snapshot, nil
+}
+
+// Jobs devuelve una copia de los trabajos, del más nuevo al más viejo
+func (m *TimelapseManager) Jobs() []TimelapseJob {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	jobs := make([]TimelapseJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Started.After(jobs[j].Started)
+	})
+	return jobs
+}
+
+// Job devuelve una copia del trabajo con el id dado
+func (m *TimelapseManager) Job(id string) (TimelapseJob, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return TimelapseJob{}, false
+	}
+	return *job, true
+}
+
+// handleStartTimelapse crea un trabajo: {"interval": 10, "duration": 3600, "fps": 24, "name": "atardecer"}
+func (m *TimelapseManager) handleStartTimelapse(c *gin.Context) {
+	var req TimelapseRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("JSON inválido: %v", err)})
+		return
+	}
+	job, err := m.Start(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, job)
+}
+
+// handleListTimelapses lista los trabajos con su progreso
+func (m *TimelapseManager) handleListTimelapses(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"jobs": m.Jobs()})
+}
+
+// handleGetTimelapse devuelve el progreso de un trabajo
+func (m *TimelapseManager) handleGetTimelapse(c *gin.Context) {
+	job, ok := m.Job(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// handleStopTimelapse detiene la captura y ensambla lo capturado
+func (m *TimelapseManager) handleStopTimelapse(c *gin.Context) {
+	job, err := m.Stop(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// handleTimelapseVideo sirve el AVI ensamblado con soporte de Range
+func (m *TimelapseManager) handleTimelapseVideo(c *gin.Context) {
+	job, ok := m.Job(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "timelapse not found"})
+		return
+	}
+	if job.State != timelapseDone {
+		c.JSON(http.StatusConflict, gin.H{"error": "timelapse not finished", "state": job.State})
+		return
+	}
+	c.Header("Content-Type", "video/x-msvideo")
+	c.File(filepath.Join(job.dir, timelapseVideoName))
+}
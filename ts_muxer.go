@@ -0,0 +1,217 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+const (
+	tsPacketSize = 188
+	tsPATPID     = 0x0000
+	tsPMTPID     = 0x1000
+	tsVideoPID   = 0x0100
+	// tsStreamTypeH264 es el stream_type de H.264 en la PMT
+	tsStreamTypeH264 = 0x1B
+	// tsTimeOffset evita PTS/PCR negativos al inicio del stream
+	tsTimeOffset = 90000
+)
+
+// tsMuxer escribe video H.264 como MPEG-TS (un programa, una pista de video)
+type tsMuxer struct {
+	w          io.Writer
+	continuity map[uint16]byte
+	packet     [tsPacketSize]byte
+}
+
+func newTSMuxer(w io.Writer) *tsMuxer {
+	return &tsMuxer{
+		w:          w,
+		continuity: make(map[uint16]byte),
+	}
+}
+
+// writeTables escribe PAT y PMT; debe ir al principio de cada segmento
+func (m *tsMuxer) writeTables() error {
+	pat := []byte{
+		0x00,       // table_id
+		0xB0, 0x0D, // section_syntax_indicator + section_length
+		0x00, 0x01, // transport_stream_id
+		0xC1,       // version 0, current_next
+		0x00, 0x00, // section_number, last_section_number
+		0x00, 0x01, // program_number
+		0xE0 | byte(tsPMTPID>>8), byte(tsPMTPID & 0xFF),
+	}
+	if err := m.writeSection(tsPATPID, pat); err != nil {
+		return err
+	}
+
+	pmt := []byte{
+		0x02,       // table_id
+		0xB0, 0x12, // section_syntax_indicator + section_length
+		0x00, 0x01, // program_number
+		0xC1,       // version 0, current_next
+		0x00, 0x00, // section_number, last_section_number
+		0xE0 | byte(tsVideoPID>>8), byte(tsVideoPID & 0xFF), // PCR PID
+		0xF0, 0x00, // program_info_length
+		tsStreamTypeH264,
+		0xE0 | byte(tsVideoPID>>8), byte(tsVideoPID & 0xFF),
+		0xF0, 0x00, // ES_info_length
+	}
+	return m.writeSection(tsPMTPID, pmt)
+}
+
+// writeSection escribe una tabla PSI en un único paquete con su CRC
+func (m *tsMuxer) writeSection(pid uint16, section []byte) error {
+	crc := crc32MPEG2(section)
+	payload := make([]byte, 0, len(section)+5)
+	payload = append(payload, 0x00) // pointer_field
+	payload = append(payload, section...)
+	payload = append(payload, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	p := m.packet[:]
+	p[0] = 0x47
+	p[1] = 0x40 | byte(pid>>8)
+	p[2] = byte(pid)
+	p[3] = 0x10 | m.nextContinuity(pid)
+	n := copy(p[4:], payload)
+	for i := 4 + n; i < tsPacketSize; i++ {
+		p[i] = 0xFF
+	}
+	_, err := m.w.Write(p)
+	return err
+}
+
+// writeVideo escribe un access unit H.264 (Annex-B) como un PES.
+// pts es el instante de presentación relativo al inicio del stream.
+func (m *tsMuxer) writeVideo(pts time.Duration, keyframe bool, annexB []byte) error {
+	ts := ticks90kHz(pts) + tsTimeOffset
+
+	// Los decodificadores esperan un AUD al principio de cada access unit
+	var data []byte
+	if nalus := splitAnnexB(annexB); len(nalus) > 0 && h264NALType(nalus[0]) != h264NALAUD {
+		data = append([]byte{0, 0, 0, 1, h264NALAUD, 0xF0}, annexB...)
+	} else {
+		data = annexB
+	}
+
+	header := []byte{
+		0x00, 0x00, 0x01, 0xE0, // start code + stream_id video
+		0x00, 0x00, // PES_packet_length sin límite
+		0x80, 0x80, 0x05, // PTS presente
+	}
+	header = append(header, encodePTS(0x20, ts)...)
+	pes := append(header, data...)
+
+	first := true
+	for len(pes) > 0 {
+		p := m.packet[:]
+		p[0] = 0x47
+		p[1] = byte(tsVideoPID >> 8)
+		if first {
+			p[1] |= 0x40 // payload_unit_start_indicator
+		}
+		p[2] = byte(tsVideoPID & 0xFF)
+		p[3] = m.nextContinuity(tsVideoPID)
+
+		// Campo de adaptación: PCR en el primer paquete y relleno en el último
+		var adaptation []byte
+		if first {
+			flags := byte(0x10) // PCR
+			if keyframe {
+				flags |= 0x40 // random_access_indicator
+			}
+			adaptation = append([]byte{flags}, encodePCR(ts)...)
+		}
+
+		space := tsPacketSize - 4
+		if adaptation != nil {
+			space -= 1 + len(adaptation)
+		}
+		if len(pes) < space {
+			// Rellenar con stuffing dentro del campo de adaptación
+			stuffing := space - len(pes)
+			if adaptation == nil {
+				if stuffing == 1 {
+					adaptation = []byte{}
+				} else {
+					adaptation = []byte{0x00}
+					stuffing--
+				}
+				stuffing--
+			}
+			for i := 0; i < stuffing; i++ {
+				adaptation = append(adaptation, 0xFF)
+			}
+			space = len(pes)
+		}
+
+		offset := 4
+		if adaptation != nil {
+			p[3] |= 0x30
+			p[4] = byte(len(adaptation))
+			copy(p[5:], adaptation)
+			offset = 5 + len(adaptation)
+		} else {
+			p[3] |= 0x10
+		}
+		copy(p[offset:], pes[:space])
+		pes = pes[space:]
+
+		if _, err := m.w.Write(p); err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
+
+func (m *tsMuxer) nextContinuity(pid uint16) byte {
+	cc := m.continuity[pid]
+	m.continuity[pid] = (cc + 1) & 0x0F
+	return cc
+}
+
+// ticks90kHz pasa una duración al reloj de 90 kHz de MPEG-TS y RTP. Se
+// divide antes de multiplicar para no desbordar el int64 con streams largos.
+func ticks90kHz(d time.Duration) uint64 {
+	return uint64(d/time.Microsecond) * 9 / 100
+}
+
+// encodePTS codifica un timestamp de 33 bits en 5 bytes con marcadores
+func encodePTS(prefix byte, ts uint64) []byte {
+	return []byte{
+		prefix | byte(ts>>29)&0x0E | 0x01,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 0x01,
+		byte(ts >> 7),
+		byte(ts<<1)&0xFE | 0x01,
+	}
+}
+
+// encodePCR codifica la base del PCR (sin extensión) en 6 bytes
+func encodePCR(ts uint64) []byte {
+	return []byte{
+		byte(ts >> 25),
+		byte(ts >> 17),
+		byte(ts >> 9),
+		byte(ts >> 1),
+		byte(ts<<7) | 0x7E,
+		0x00,
+	}
+}
+
+// crc32MPEG2 calcula el CRC de las tablas PSI (polinomio 0x04C11DB7 sin reflejar)
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTicks90kHz(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want uint64
+	}{
+		{0, 0},
+		{time.Millisecond, 90},
+		{time.Second, 90000},
+		{1500 * time.Millisecond, 135000},
+		// Con pts*90000 el int64 se desbordaba pasadas unas 28 horas
+		{30 * time.Hour, 30 * 3600 * 90000},
+		{365 * 24 * time.Hour, 365 * 24 * 3600 * 90000},
+	}
+	for _, tt := range tests {
+		if got := ticks90kHz(tt.in); got != tt.want {
+			t.Errorf("ticks90kHz(%v) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// decodePTS lee un timestamp de 33 bits codificado por encodePTS
+func decodePTS(b []byte) uint64 {
+	return uint64(b[0]>>1&0x07)<<30 | uint64(b[1])<<22 | uint64(b[2]>>1)<<15 |
+		uint64(b[3])<<7 | uint64(b[4]>>1)
+}
+
+// decodePCR lee la base del PCR codificada por encodePCR
+func decodePCR(b []byte) uint64 {
+	return uint64(b[0])<<25 | uint64(b[1])<<17 | uint64(b[2])<<9 | uint64(b[3])<<1 | uint64(b[4]>>7)
+}
+
+func TestEncodeTimestamps(t *testing.T) {
+	for _, ts := range []uint64{0, 1, 90000, 1<<32 + 12345, 1<<33 - 1} {
+		pts := encodePTS(0x20, ts)
+		if pts[0]&0xF1 != 0x21 || pts[2]&0x01 != 1 || pts[4]&0x01 != 1 {
+			t.Errorf("encodePTS(%d) = % x: bad prefix or markers", ts, pts)
+		}
+		if got := decodePTS(pts); got != ts {
+			t.Errorf("encodePTS(%d) decodes to %d", ts, got)
+		}
+		if got := decodePCR(encodePCR(ts)); got != ts {
+			t.Errorf("encodePCR(%d) decodes to %d", ts, got)
+		}
+	}
+}
+
+// tsPacket es un paquete ya separado en sus campos
+type tsPacket struct {
+	pid        uint16
+	start      bool
+	continuity byte
+	adaptation []byte
+	payload    []byte
+}
+
+func parseTSPackets(t *testing.T, data []byte) []tsPacket {
+	t.Helper()
+	if len(data)%tsPacketSize != 0 {
+		t.Fatalf("output is %d bytes, not a multiple of %d", len(data), tsPacketSize)
+	}
+	var packets []tsPacket
+	for len(data) > 0 {
+		p := data[:tsPacketSize]
+		data = data[tsPacketSize:]
+		if p[0] != 0x47 {
+			t.Fatalf("packet %d: sync byte %#x", len(packets), p[0])
+		}
+		packet := tsPacket{
+			pid:        uint16(p[1]&0x1F)<<8 | uint16(p[2]),
+			start:      p[1]&0x40 != 0,
+			continuity: p[3] & 0x0F,
+		}
+		body := p[4:]
+		if p[3]&0x20 != 0 {
+			n := int(body[0])
+			packet.adaptation = body[1 : 1+n]
+			body = body[1+n:]
+		}
+		if p[3]&0x10 != 0 {
+			packet.payload = body
+		}
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestTSMuxerTables(t *testing.T) {
+	var buf bytes.Buffer
+	m := newTSMuxer(&buf)
+	if err := m.writeTables(); err != nil {
+		t.Fatal(err)
+	}
+	packets := parseTSPackets(t, buf.Bytes())
+	if len(packets) != 2 || packets[0].pid != tsPATPID || packets[1].pid != tsPMTPID {
+		t.Fatalf("expected PAT and PMT packets, got %+v", packets)
+	}
+	for _, p := range packets {
+		if !p.start || p.payload[0] != 0 {
+			t.Errorf("pid %#x: missing payload start or pointer field", p.pid)
+		}
+		section := p.payload[1:]
+		length := int(section[1]&0x0F)<<8 | int(section[2])
+		// El CRC de la sección completa, incluido el suyo, debe dar cero
+		if crc := crc32MPEG2(section[:3+length]); crc != 0 {
+			t.Errorf("pid %#x: CRC check = %#x", p.pid, crc)
+		}
+	}
+}
+
+func TestTSMuxerWriteVideo(t *testing.T) {
+	tests := []struct {
+		name     string
+		pts      time.Duration
+		keyframe bool
+		size     int
+	}{
+		{"small keyframe", 0, true, 10},
+		{"fills packet", time.Second, false, 150},
+		{"multi packet", 40 * time.Hour, true, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nal := append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0xAB}, tt.size)...)
+			var buf bytes.Buffer
+			m := newTSMuxer(&buf)
+			if err := m.writeVideo(tt.pts, tt.keyframe, nal); err != nil {
+				t.Fatal(err)
+			}
+			packets := parseTSPackets(t, buf.Bytes())
+			want := ticks90kHz(tt.pts) + tsTimeOffset
+
+			var pes []byte
+			for i, p := range packets {
+				if p.pid != tsVideoPID {
+					t.Fatalf("packet %d: pid %#x", i, p.pid)
+				}
+				if p.start != (i == 0) {
+					t.Errorf("packet %d: payload_unit_start = %v", i, p.start)
+				}
+				if p.continuity != byte(i) {
+					t.Errorf("packet %d: continuity %d", i, p.continuity)
+				}
+				pes = append(pes, p.payload...)
+			}
+
+			first := packets[0].adaptation
+			if len(first) < 7 || first[0]&0x10 == 0 {
+				t.Fatalf("first packet has no PCR: % x", first)
+			}
+			if rai := first[0]&0x40 != 0; rai != tt.keyframe {
+				t.Errorf("random_access_indicator = %v, want %v", rai, tt.keyframe)
+			}
+			if pcr := decodePCR(first[1:7]); pcr != want&(1<<33-1) {
+				t.Errorf("PCR = %d, want %d", pcr, want)
+			}
+
+			if !bytes.HasPrefix(pes, []byte{0, 0, 1, 0xE0}) || pes[7]&0x80 == 0 {
+				t.Fatalf("bad PES header: % x", pes[:9])
+			}
+			if pts := decodePTS(pes[9:14]); pts != want&(1<<33-1) {
+				t.Errorf("PTS = %d, want %d", pts, want)
+			}
+			aud := []byte{0, 0, 0, 1, h264NALAUD, 0xF0}
+			if got := pes[14:]; !bytes.Equal(got, append(aud, nal...)) {
+				t.Errorf("PES payload is %d bytes, want AUD + %d bytes of NAL", len(got), len(nal))
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// encoderKeyframeInterval es cada cuánto fuerza el codificador un keyframe
+const encoderKeyframeInterval = 2 * time.Second
+
+// VideoEncoder convierte los frames JPEG del bucle de captura en H.264
+// usando ffmpeg, para las fuentes que no entregan video codificado
+type VideoEncoder struct {
+	input *FrameBroker
+	video *FrameBroker
+	fps   int
+
+	mutex sync.Mutex
+	cmd   *exec.Cmd
+	done  chan struct{}
+}
+
+func NewVideoEncoder(input *FrameBroker, fps int) *VideoEncoder {
+	if fps <= 0 {
+		fps = 10
+	}
+	return &VideoEncoder{
+		input: input,
+		video: NewFrameBroker(),
+		fps:   fps,
+	}
+}
+
+// VideoFrames devuelve el broker con los access units H.264 producidos
+func (e *VideoEncoder) VideoFrames() *FrameBroker {
+	return e.video
+}
+
+// Start lanza ffmpeg y las goroutines que lo alimentan y leen su salida
+func (e *VideoEncoder) Start() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.cmd != nil {
+		return nil
+	}
+	if !isFFmpegAvailable() {
+		return fmt.Errorf("ffmpeg not available")
+	}
+
+	gop := strconv.Itoa(e.fps * int(encoderKeyframeInterval/time.Second))
+	cmd := exec.Command("ffmpeg",
+		"-loglevel", "error",
+		"-f", "image2pipe", "-c:v", "mjpeg", "-framerate", strconv.Itoa(e.fps), "-i", "pipe:0",
+		"-c:v", "libx264", "-preset", "ultrafast", "-tune", "zerolatency",
+		"-profile:v", "baseline", "-pix_fmt", "yuv420p",
+		"-g", gop, "-keyint_min", gop,
+		"-x264-params", "aud=1:repeat-headers=1",
+		"-f", "h264", "pipe:1",
+	)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("ffmpeg stdin failed: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("ffmpeg stdout failed: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg start failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	e.cmd = cmd
+	e.done = done
+
+	sub := e.input.Subscribe(2)
+	go e.feed(sub, stdin, done)
+	go e.read(stdout, done)
+
+	log.Printf("🎞️  Codificador H.264 iniciado (%d fps)", e.fps)
+	return nil
+}
+
+// Stop termina ffmpeg y espera a que salga
+func (e *VideoEncoder) Stop() {
+	e.mutex.Lock()
+	cmd := e.cmd
+	done := e.done
+	e.cmd = nil
+	e.done = nil
+	e.mutex.Unlock()
+
+	if cmd == nil {
+		return
+	}
+	close(done)
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+	cmd.Wait()
+	e.video.Reset()
+	log.Println("🎞️  Codificador H.264 detenido")
+}
+
+// feed escribe los JPEG en la entrada de ffmpeg
+func (e *VideoEncoder) feed(sub *FrameSubscriber, stdin io.WriteCloser, done <-chan struct{}) {
+	defer sub.Close()
+	defer stdin.Close()
+
+	for {
+		select {
+		case <-done:
+			return
+		case frame := <-sub.C:
+			if _, err := stdin.Write(frame.Data); err != nil {
+				log.Printf("❌ Error alimentando codificador: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// read separa la salida Annex-B de ffmpeg en access units usando los
+// delimitadores AUD y los publica en el broker de video
+func (e *VideoEncoder) read(stdout io.Reader, done <-chan struct{}) {
+	scanner := newAnnexBScanner(stdout)
+	params := &h264ParameterSets{}
+	frameDuration := time.Second / time.Duration(e.fps)
+
+	var accessUnit [][]byte
+	emit := func() {
+		if len(accessUnit) == 0 {
+			return
+		}
+		data := params.complete(joinAnnexB(accessUnit))
+		e.video.Publish(&Frame{
+			Data:      data,
+			Codec:     codecH264,
+			Keyframe:  isH264Keyframe(data),
+			Timestamp: time.Now(),
+			Duration:  frameDuration,
+		})
+		accessUnit = nil
+	}
+
+	for {
+		nalu, err := scanner.Next()
+		if err != nil {
+			select {
+			case <-done:
+			default:
+				log.Printf("❌ Codificador H.264 terminó: %v", err)
+			}
+			return
+		}
+		if h264NALType(nalu) == h264NALAUD {
+			emit()
+			continue
+		}
+		accessUnit = append(accessUnit, nalu)
+	}
+}
+
+// annexBScanner lee unidades NAL de un stream Annex-B
+type annexBScanner struct {
+	reader *bufio.Reader
+	buf    []byte
+	eof    bool
+}
+
+func newAnnexBScanner(r io.Reader) *annexBScanner {
+	return &annexBScanner{reader: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Next devuelve la siguiente unidad NAL completa sin código de inicio
+func (s *annexBScanner) Next() ([]byte, error) {
+	chunk := make([]byte, 32*1024)
+	for {
+		// Buscar dos códigos de inicio para delimitar una NAL completa
+		if first := findStartCode(s.buf, 0); first >= 0 {
+			begin := first + 3
+			if next := findStartCode(s.buf, begin); next >= 0 {
+				end := next
+				if end > begin && s.buf[end-1] == 0 {
+					end--
+				}
+				nalu := append([]byte(nil), s.buf[begin:end]...)
+				s.buf = s.buf[next:]
+				if len(nalu) == 0 {
+					continue
+				}
+				return nalu, nil
+			}
+		}
+
+		if s.eof {
+			if first := findStartCode(s.buf, 0); first >= 0 && first+3 < len(s.buf) {
+				nalu := append([]byte(nil), s.buf[first+3:]...)
+				s.buf = nil
+				return nalu, nil
+			}
+			return nil, io.EOF
+		}
+
+		n, err := s.reader.Read(chunk)
+		s.buf = append(s.buf, chunk[:n]...)
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			s.eof = true
+		}
+	}
+}
+
+// findStartCode busca 00 00 01 a partir de from y devuelve su posición
+func findStartCode(data []byte, from int) int {
+	if from >= len(data) {
+		return -1
+	}
+	idx := bytes.Index(data[from:], []byte{0, 0, 1})
+	if idx < 0 {
+		return -1
+	}
+	return from + idx
+}
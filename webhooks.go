@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// webhookAttempts es el número máximo de intentos por entrega
+	webhookAttempts = 5
+	// webhookBackoff es la espera tras el primer fallo; se duplica en cada reintento
+	webhookBackoff = 2 * time.Second
+	webhookTimeout = 10 * time.Second
+	// webhookHistory es cuántas entregas se guardan en el registro
+	webhookHistory = 200
+	webhookBuffer  = 64
+	// webhookTestEvent es el tipo del evento que envía POST /api/webhooks/:id/test
+	webhookTestEvent = "webhook.test"
+)
+
+// Estados de una entrega
+const (
+	webhookPending   = "pending"
+	webhookDelivered = "delivered"
+	webhookFailed    = "failed"
+)
+
+// Webhook es un destino HTTP que recibe los eventos de los tipos elegidos
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events son tipos o categorías como en /api/events; vacío recibe todo
+	Events []string `json:"events"`
+	// Secret firma cada envío; solo se muestra al crear el webhook
+	Secret      string    `json:"secret,omitempty"`
+	Signed      bool      `json:"signed"`
+	AttachImage bool      `json:"attachImage"`
+	Enabled     bool      `json:"enabled"`
+	Created     time.Time `json:"created"`
+}
+
+// WebhookRequest es el cuerpo de POST y PUT /api/webhooks; los campos
+// omitidos en PUT se mantienen
+type WebhookRequest struct {
+	URL         *string   `json:"url"`
+	Events      *[]string `json:"events"`
+	Secret      *string   `json:"secret"`
+	AttachImage *bool     `json:"attachImage"`
+	Enabled     *bool     `json:"enabled"`
+}
+
+// WebhookDelivery es una entrada del registro de envíos
+type WebhookDelivery struct {
+	ID         string    `json:"id"`
+	Webhook    string    `json:"webhook"`
+	URL        string    `json:"url"`
+	EventID    uint64    `json:"eventId"`
+	Event      string    `json:"event"`
+	State      string    `json:"state"`
+	Attempts   int       `json:"attempts"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Image      bool      `json:"image"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished,omitempty"`
+}
+
+// WebhookManager envía los eventos del bus a los webhooks configurados.
+// Cada envío es un POST firmado con HMAC-SHA256 que se reintenta con
+// espera exponencial si falla la red o el servidor responde 5xx o 429.
+type WebhookManager struct {
+	path   string
+	bus    *EventBus
+	image  func() ([]byte, error)
+	client *http.Client
+	// backoff es la espera tras el primer fallo de una entrega
+	backoff time.Duration
+
+	mutex      sync.Mutex
+	hooks      []*Webhook
+	deliveries []*WebhookDelivery
+}
+
+// NewWebhookManager carga los webhooks guardados y empieza a escuchar el bus
+func NewWebhookManager(path string, bus *EventBus, image func() ([]byte, error)) *WebhookManager {
+	m := &WebhookManager{
+		path:    path,
+		bus:     bus,
+		image:   image,
+		client:  &http.Client{Timeout: webhookTimeout},
+		backoff: webhookBackoff,
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &m.hooks); err != nil {
+			log.Printf("⚠️  Webhooks dañados en %s: %v", path, err)
+			m.hooks = nil
+		} else if len(m.hooks) > 0 {
+			log.Printf("🪝 %d webhook(s) cargados", len(m.hooks))
+		}
+	}
+	// Suscribirse aquí para no perder los eventos publicados mientras arranca run
+	go m.run(m.bus.Subscribe(webhookBuffer))
+	return m
+}
+
+// run reparte cada evento del bus a los webhooks que lo esperan
+func (m *WebhookManager) run(sub *EventSubscriber) {
+	defer sub.Close()
+	var dropped uint64
+	for event := range sub.C {
+		if n := sub.Dropped(); n > dropped {
+			log.Printf("⚠️  Webhooks: %d evento(s) perdidos por lentitud", n-dropped)
+			dropped = n
+		}
+		m.dispatch(event)
+	}
+}
+
+// dispatch lanza las entregas de los webhooks activos que aceptan el evento
+func (m *WebhookManager) dispatch(event Event) {
+	var withImage []Webhook
+	m.mutex.Lock()
+	for _, hook := range m.hooks {
+		if !hook.Enabled || !matchEventType(hook.Events, event.Type) {
+			continue
+		}
+		if hook.AttachImage {
+			withImage = append(withImage, *hook)
+			continue
+		}
+		go m.deliver(*hook, event, nil, webhookAttempts)
+	}
+	m.mutex.Unlock()
+	if len(withImage) > 0 {
+		// La captura puede tardar: se hace fuera de run para no retrasar los
+		// eventos siguientes, y una sola vez aunque varios webhooks la pidan
+		go m.deliverWithImage(withImage, event)
+	}
+}
+
+// deliverWithImage captura la imagen y lanza las entregas que la adjuntan
+func (m *WebhookManager) deliverWithImage(hooks []Webhook, event Event) {
+	image, err := m.image()
+	if err != nil {
+		log.Printf("⚠️  Webhook sin imagen para %s: %v", event.Type, err)
+	}
+	for _, hook := range hooks {
+		go m.deliver(hook, event, image, webhookAttempts)
+	}
+}
+
+// deliver envía el evento reintentando hasta attempts veces
+func (m *WebhookManager) deliver(hook Webhook, event Event, image []byte, attempts int) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		ID:      newSessionID("dlv"),
+		Webhook: hook.ID,
+		URL:     hook.URL,
+		EventID: event.ID,
+		Event:   event.Type,
+		State:   webhookPending,
+		Image:   image != nil,
+		Started: time.Now(),
+	}
+	m.mutex.Lock()
+	m.deliveries = append(m.deliveries, delivery)
+	if len(m.deliveries) > webhookHistory {
+		m.deliveries = m.deliveries[len(m.deliveries)-webhookHistory:]
+	}
+	m.mutex.Unlock()
+
+	body, contentType, err := webhookBody(event, image)
+	if err != nil {
+		m.finishDelivery(delivery, 0, err)
+		return delivery
+	}
+
+	backoff := m.backoff
+	for attempt := 1; ; attempt++ {
+		status, err := m.post(hook, delivery.ID, event.Type, body, contentType)
+		m.mutex.Lock()
+		delivery.Attempts = attempt
+		delivery.StatusCode = status
+		m.mutex.Unlock()
+
+		if err == nil {
+			m.finishDelivery(delivery, status, nil)
+			return delivery
+		}
+		retry := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retry || attempt >= attempts {
+			m.finishDelivery(delivery, status, err)
+			return delivery
+		}
+		log.Printf("⚠️  Webhook %s: intento %d fallido (%v), reintento en %v", hook.ID, attempt, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (m *WebhookManager) finishDelivery(delivery *WebhookDelivery, status int, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delivery.Finished = time.Now()
+	delivery.StatusCode = status
+	if err != nil {
+		delivery.State = webhookFailed
+		delivery.Error = err.Error()
+		log.Printf("❌ Webhook %s: %s no entregado tras %d intento(s): %v", delivery.Webhook, delivery.Event, delivery.Attempts, err)
+		return
+	}
+	delivery.State = webhookDelivered
+	delivery.Error = ""
+	log.Printf("🪝 Webhook %s: %s entregado (%d)", delivery.Webhook, delivery.Event, status)
+}
+
+// post hace un intento de envío. La firma es HMAC-SHA256 con el secreto
+// sobre "<timestamp>.<cuerpo>", en hexadecimal.
+func (m *WebhookManager) post(hook Webhook, deliveryID, eventType string, body []byte, contentType string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "alien-cam")
+	req.Header.Set("X-AlienCam-Event", eventType)
+	req.Header.Set("X-AlienCam-Delivery", deliveryID)
+	req.Header.Set("X-AlienCam-Timestamp", timestamp)
+	if hook.Secret != "" {
+		req.Header.Set("X-AlienCam-Signature", "sha256="+signWebhook(hook.Secret, timestamp, body))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook calcula la firma que el receptor debe comprobar
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBody es el evento en JSON o, con imagen, un multipart/form-data
+// con la parte "event" (JSON) y la parte "image" (JPEG)
+func webhookBody(event Event, image []byte) ([]byte, string, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+	if image == nil {
+		return payload, "application/json", nil
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="event"`)
+	header.Set("Content-Type", "application/json")
+	part, err := writer.CreatePart(header)
+	if err == nil {
+		_, err = part.Write(payload)
+	}
+	if err == nil {
+		header = make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="image"; filename="%s-%d.jpg"`, event.Type, event.ID))
+		header.Set("Content-Type", "image/jpeg")
+		part, err = writer.CreatePart(header)
+	}
+	if err == nil {
+		_, err = part.Write(image)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
+
+// apply valida la petición sobre una copia del webhook
+func (req WebhookRequest) apply(hook Webhook) (Webhook, error) {
+	if req.URL != nil {
+		hook.URL = *req.URL
+	}
+	if req.Events != nil {
+		hook.Events = *req.Events
+	}
+	if req.Secret != nil {
+		hook.Secret = *req.Secret
+	}
+	if req.AttachImage != nil {
+		hook.AttachImage = *req.AttachImage
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+
+	parsed, err := url.Parse(hook.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return hook, fmt.Errorf("url must be an http or https URL")
+	}
+	for _, t := range hook.Events {
+		if t == "" {
+			return hook, fmt.Errorf("empty event type")
+		}
+	}
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+	hook.Signed = hook.Secret != ""
+	return hook, nil
+}
+
+// Create añade un webhook; sin secreto se genera uno aleatorio
+func (m *WebhookManager) Create(req WebhookRequest) (Webhook, error) {
+	hook := Webhook{ID: newSessionID("hook"), Enabled: true, Created: time.Now()}
+	hook, err := req.apply(hook)
+	if err != nil {
+		return hook, err
+	}
+	if req.Secret == nil {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return hook, err
+		}
+		hook.Secret = hex.EncodeToString(secret)
+		hook.Signed = true
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, &hook)
+	if err := m.saveLocked(); err != nil {
+		m.hooks = m.hooks[:len(m.hooks)-1]
+		return hook, err
+	}
+	log.Printf("🪝 Webhook %s creado: %s %v", hook.ID, hook.URL, hook.Events)
+	return hook, nil
+}
+
+// Update cambia los campos indicados de un webhook
+func (m *WebhookManager) Update(id string, req WebhookRequest) (Webhook, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, hook := range m.hooks {
+		if hook.ID != id {
+			continue
+		}
+		updated, err := req.apply(*hook)
+		if err != nil {
+			return Webhook{}, err
+		}
+		previous := *hook
+		*hook = updated
+		if err := m.saveLocked(); err != nil {
+			*hook = previous
+			return Webhook{}, err
+		}
+		updated.Secret = ""
+		return updated, nil
+	}
+	return Webhook{}, os.ErrNotExist
+}
+
+// Delete borra un webhook
+func (m *WebhookManager) Delete(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, hook := range m.hooks {
+		if hook.ID != id {
+			continue
+		}
+		m.hooks = append(m.hooks[:i], m.hooks[i+1:]...)
+		if err := m.saveLocked(); err != nil {
+			m.hooks = append(m.hooks[:i], append([]*Webhook{hook}, m.hooks[i:]...)...)
+			return err
+		}
+		log.Printf("🪝 Webhook %s borrado", id)
+		return nil
+	}
+	return os.ErrNotExist
+}
+
+// Hooks devuelve los webhooks sin sus secretos
+func (m *WebhookManager) Hooks() []Webhook {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	hooks := make([]Webhook, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		snapshot := *hook
+		snapshot.Secret = ""
+		hooks = append(hooks, snapshot)
+	}
+	return hooks
+}
+
+// hook devuelve una copia del webhook con su secreto
+func (m *WebhookManager) hook(id string) (Webhook, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, hook := range m.hooks {
+		if hook.ID == id {
+			return *hook, true
+		}
+	}
+	return Webhook{}, false
+}
+
+// Deliveries devuelve el registro de envíos, del más nuevo al más viejo
+func (m *WebhookManager) Deliveries(hookID string) []WebhookDelivery {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	deliveries := make([]WebhookDelivery, 0, len(m.deliveries))
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if hookID == "" || m.deliveries[i].Webhook == hookID {
+			deliveries = append(deliveries, *m.deliveries[i])
+		}
+	}
+	return deliveries
+}
+
+// saveLocked escribe el archivo de forma atómica
+func (m *WebhookManager) saveLocked() error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m.hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	// El archivo guarda los secretos: solo lo lee el usuario
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// handleListWebhooks lista los webhooks sin secretos
+func (m *WebhookManager) handleListWebhooks(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"webhooks": m.Hooks()})
+}
+
+// handleCreateWebhook crea un webhook: {"url": "http://...", "events": ["motion", "capture.failed"], "attachImage": true}
+func (m *WebhookManager) handleCreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("JSON inválido: %v", err)})
+		return
+	}
+	hook, err := m.Create(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, hook)
+}
+
+// handleGetWebhook devuelve un webhook sin su secreto
+func (m *WebhookManager) handleGetWebhook(c *gin.Context) {
+	hook, ok := m.hook(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	hook.Secret = ""
+	c.JSON(http.StatusOK, hook)
+}
+
+// handleUpdateWebhook cambia los campos enviados
+func (m *WebhookManager) handleUpdateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("JSON inválido: %v", err)})
+		return
+	}
+	hook, err := m.Update(c.Param("id"), req)
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+// handleDeleteWebhook borra un webhook
+func (m *WebhookManager) handleDeleteWebhook(c *gin.Context) {
+	if err := m.Delete(c.Param("id")); err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleTestWebhook envía un evento de prueba, sin reintentos, y devuelve el resultado
+func (m *WebhookManager) handleTestWebhook(c *gin.Context) {
+	hook, ok := m.hook(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		return
+	}
+	event := Event{Type: webhookTestEvent, Time: time.Now(), Data: gin.H{"webhook": hook.ID}}
+	var image []byte
+	if hook.AttachImage {
+		image, _ = m.image()
+	}
+	delivery := m.deliver(hook, event, image, 1)
+
+	m.mutex.Lock()
+	result := *delivery
+	m.mutex.Unlock()
+	c.JSON(http.StatusOK, result)
+}
+
+// handleWebhookDeliveries devuelve el registro de envíos (?webhook=<id> filtra)
+func (m *WebhookManager) handleWebhookDeliveries(c *gin.Context) {
+	hookID := c.Query("webhook")
+	if id := c.Param("id"); id != "" {
+		hookID = id
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": m.Deliveries(hookID)})
+}
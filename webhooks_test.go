@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignWebhook(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp string
+		body      string
+		want      string
+	}{
+		{"s3cret", "1700000000", `{"id":1}`, "ee0658aa4e37018df69c24227df01e0f680eb3b87c7f1f9bd936e283cfe01d9b"},
+		{"other", "1700000000", `{"id":1}`, "e0cb77fc6d5b2877ec062213c262d236b5dd5a833d29fdc5a058c5fbfa287b47"},
+		{"key", "0", "", "85841b4efc3cd7776c3c8f9b7cca9e281c550e5d19889d78e9e669c6337f000d"},
+	}
+	for _, tt := range tests {
+		if got := signWebhook(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("signWebhook(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+// webhookRequest es lo que recibe el servidor de prueba
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver responde con los códigos de statuses en orden (200 al
+// acabarse) y guarda cada petición
+type webhookReceiver struct {
+	*httptest.Server
+	requests chan webhookRequest
+
+	mutex    sync.Mutex
+	statuses []int
+}
+
+func newWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	r := &webhookReceiver{requests: make(chan webhookRequest, 16), statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.requests <- webhookRequest{header: req.Header, body: body}
+		status := http.StatusOK
+		r.mutex.Lock()
+		if len(r.statuses) > 0 {
+			status, r.statuses = r.statuses[0], r.statuses[1:]
+		}
+		r.mutex.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) next(t *testing.T) webhookRequest {
+	t.Helper()
+	select {
+	case req := <-r.requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not received")
+		return webhookRequest{}
+	}
+}
+
+func newTestWebhookManager(t *testing.T, image func() ([]byte, error)) (*WebhookManager, *EventBus) {
+	bus := NewEventBus()
+	m := NewWebhookManager(filepath.Join(t.TempDir(), "webhooks.json"), bus, image)
+	m.backoff = time.Millisecond
+	return m, bus
+}
+
+func createTestWebhook(t *testing.T, m *WebhookManager, req WebhookRequest) Webhook {
+	t.Helper()
+	hook, err := m.Create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// waitDelivery espera a que la última entrega del webhook termine
+func waitDelivery(t *testing.T, m *WebhookManager, hookID string) WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if deliveries := m.Deliveries(hookID); len(deliveries) > 0 && deliveries[0].State != webhookPending {
+			return deliveries[0]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("delivery for %s did not finish", hookID)
+	return WebhookDelivery{}
+}
+
+func TestWebhookSignedDelivery(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	m, bus := newTestWebhookManager(t, nil)
+	secret := "s3cret"
+	events := []string{"motion"}
+	hook := createTestWebhook(t, m, WebhookRequest{URL: &receiver.URL, Events: &events, Secret: &secret})
+
+	bus.Publish(eventCameraStarted, nil)
+	bus.Publish(eventMotionStart, gin.H{"score": 0.5})
+	req := receiver.next(t)
+
+	if got := req.header.Get("X-AlienCam-Event"); got != eventMotionStart {
+		t.Errorf("X-AlienCam-Event = %q", got)
+	}
+	if got := req.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	timestamp := req.header.Get("X-AlienCam-Timestamp")
+	want := "sha256=" + signWebhook(secret, timestamp, req.body)
+	if got := req.header.Get("X-AlienCam-Signature"); timestamp == "" || got != want {
+		t.Errorf("X-AlienCam-Signature = %q, want %q", got, want)
+	}
+	var event Event
+	if err := json.Unmarshal(req.body, &event); err != nil || event.Type != eventMotionStart || event.ID != 2 {
+		t.Errorf("body = %s (%v)", req.body, err)
+	}
+
+	delivery := waitDelivery(t, m, hook.ID)
+	if delivery.State != webhookDelivered || delivery.Attempts != 1 || delivery.StatusCode != http.StatusOK || delivery.EventID != 2 {
+		t.Errorf("delivery = %+v", delivery)
+	}
+	if got := req.header.Get("X-AlienCam-Delivery"); got != delivery.ID {
+		t.Errorf("X-AlienCam-Delivery = %q, want %q", got, delivery.ID)
+	}
+	// camera.started no casa con el filtro y no deja entrega
+	if n := len(m.Deliveries("")); n != 1 {
+		t.Errorf("%d deliveries, want 1", n)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		state    string
+		attempts int
+		status   int
+	}{
+		{"ok", nil, webhookDelivered, 1, 200},
+		{"server error", []int{500, 503}, webhookDelivered, 3, 200},
+		{"rate limited", []int{429}, webhookDelivered, 2, 200},
+		{"bad request", []int{400}, webhookFailed, 1, 400},
+		{"not found", []int{404}, webhookFailed, 1, 404},
+		{"gives up", []int{500, 500, 500, 500, 500, 500}, webhookFailed, webhookAttempts, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := newWebhookReceiver(t, tt.statuses...)
+			m, _ := newTestWebhookManager(t, nil)
+			hook := Webhook{ID: "hook-test", URL: receiver.URL}
+			delivery := m.deliver(hook, Event{ID: 7, Type: eventMotionStart}, nil, webhookAttempts)
+
+			if delivery.State != tt.state || delivery.Attempts != tt.attempts || delivery.StatusCode != tt.status {
+				t.Errorf("delivery = %s after %d attempt(s) with %d, want %s after %d with %d",
+					delivery.State, delivery.Attempts, delivery.StatusCode, tt.state, tt.attempts, tt.status)
+			}
+			if got := len(receiver.requests); got != tt.attempts {
+				t.Errorf("server got %d request(s), want %d", got, tt.attempts)
+			}
+			if tt.state == webhookFailed && delivery.Error == "" {
+				t.Error("failed delivery without error")
+			}
+			if log := m.Deliveries("hook-test"); len(log) != 1 || log[0].ID != delivery.ID || log[0].State != tt.state {
+				t.Errorf("delivery log = %+v", log)
+			}
+		})
+	}
+}
+
+func TestWebhookUnreachable(t *testing.T) {
+	receiver := newWebhookReceiver(t)
+	url := receiver.URL
+	receiver.Close()
+
+	m, _ := newTestWebhookManager(t, nil)
+	delivery := m.deliver(Webhook{ID: "hook-test", URL: url}, Event{Type: eventMotionStart}, nil, 3)
+	if delivery.State != webhookFailed || delivery.Attempts != 3 || delivery.StatusCode != 0 {
+		t.Errorf("delivery = %+v", delivery)
+	}
+}
+
+func TestWebhookImage(t *testing.T) {
+	release := make(chan struct{})
+	image := func() ([]byte, error) {
+		<-release
+		return []byte("\xff\xd8jpeg"), nil
+	}
+	withImage := newWebhookReceiver(t)
+	plain := newWebhookReceiver(t)
+	m, bus := newTestWebhookManager(t, image)
+	attach := true
+	createTestWebhook(t, m, WebhookRequest{URL: &withImage.URL, AttachImage: &attach})
+	createTestWebhook(t, m, WebhookRequest{URL: &plain.URL})
+
+	// Mientras se captura la imagen los demás envíos siguen saliendo
+	bus.Publish(eventMotionStart, nil)
+	bus.Publish(eventMotionEnd, nil)
+	received := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		received[plain.next(t).header.Get("X-AlienCam-Event")] = true
+	}
+	if !received[eventMotionStart] || !received[eventMotionEnd] {
+		t.Errorf("plain webhook got %v", received)
+	}
+	close(release)
+
+	req := withImage.next(t)
+	mediaType, params, err := mime.ParseMediaType(req.header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("Content-Type = %q", req.header.Get("Content-Type"))
+	}
+	reader := multipart.NewReader(strings.NewReader(string(req.body)), params["boundary"])
+	parts := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(part)
+		parts[part.FormName()] = string(data)
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(parts["event"]), &event); err != nil || event.Type == "" {
+		t.Errorf("event part = %q (%v)", parts["event"], err)
+	}
+	if parts["image"] != "\xff\xd8jpeg" {
+		t.Errorf("image part = %q", parts["image"])
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+	"golang.org/x/image/vp8"
+)
+
+const (
+	// ingestFrameTimeout es lo que espera CaptureFrame por un keyframe nuevo
+	ingestFrameTimeout = 5 * time.Second
+	// ingestKeyframeInterval limita las peticiones de keyframe (PLI) al publicador
+	ingestKeyframeInterval = time.Second
+	// ingestMaxLate es cuántos paquetes RTP espera el samplebuilder por un hueco
+	ingestMaxLate = 128
+)
+
+// WebRTCIngestSource convierte el track de video que publica un navegador
+// en una fuente de cámara. El video codificado se reparte por su propio
+// broker y los snapshots JPEG se obtienen decodificando keyframes.
+type WebRTCIngestSource struct {
+	video *FrameBroker
+
+	mutex           sync.Mutex
+	publisher       string
+	codec           string
+	keyframe        *Frame
+	updated         chan struct{}
+	requestKeyframe func()
+	lastKeyframeReq time.Time
+	lastSnapshotSeq uint64
+	resolution      string
+}
+
+// trackIngest depacketiza el RTP de un track publicado
+type trackIngest struct {
+	source  *WebRTCIngestSource
+	peerID  string
+	codec   string
+	builder *samplebuilder.SampleBuilder
+	params  h264ParameterSets
+}
+
+func NewWebRTCIngestSource() *WebRTCIngestSource {
+	return &WebRTCIngestSource{
+		video:   NewFrameBroker(),
+		updated: make(chan struct{}),
+	}
+}
+
+// VideoFrames devuelve el broker con el video codificado recibido
+func (s *WebRTCIngestSource) VideoFrames() *FrameBroker {
+	return s.video
+}
+
+func (s *WebRTCIngestSource) Open() error {
+	return nil
+}
+
+// CaptureFrame espera un keyframe más nuevo que el último entregado y lo
+// convierte a JPEG (VP8 en Go puro, H.264 mediante ffmpeg)
+func (s *WebRTCIngestSource) CaptureFrame() ([]byte, error) {
+	deadline := time.Now().Add(ingestFrameTimeout)
+
+	for {
+		s.mutex.Lock()
+		keyframe := s.keyframe
+		updated := s.updated
+		hasPublisher := s.publisher != ""
+		if keyframe != nil && keyframe.Seq > s.lastSnapshotSeq {
+			s.lastSnapshotSeq = keyframe.Seq
+			s.mutex.Unlock()
+			return s.snapshot(keyframe)
+		}
+		s.requestKeyframeLocked()
+		s.mutex.Unlock()
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			if !hasPublisher {
+				return nil, fmt.Errorf("no webrtc publisher connected")
+			}
+			return nil, fmt.Errorf("no keyframe received from publisher")
+		}
+
+		select {
+		case <-updated:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// snapshot decodifica un keyframe a JPEG
+func (s *WebRTCIngestSource) snapshot(keyframe *Frame) ([]byte, error) {
+	switch keyframe.Codec {
+	case codecVP8:
+		decoder := vp8.NewDecoder()
+		decoder.Init(bytes.NewReader(keyframe.Data), len(keyframe.Data))
+		header, err := decoder.DecodeFrameHeader()
+		if err != nil {
+			return nil, fmt.Errorf("vp8 header decode failed: %v", err)
+		}
+		img, err := decoder.DecodeFrame()
+		if err != nil {
+			return nil, fmt.Errorf("vp8 decode failed: %v", err)
+		}
+
+		s.mutex.Lock()
+		s.resolution = fmt.Sprintf("%dx%d", header.Width, header.Height)
+		s.mutex.Unlock()
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+			return nil, fmt.Errorf("jpeg encode failed: %v", err)
+		}
+		return buf.Bytes(), nil
+	case codecH264:
+		return decodeH264Snapshot(keyframe.Data)
+	default:
+		return nil, fmt.Errorf("unsupported codec for snapshots: %s", keyframe.Codec)
+	}
+}
+
+// requestKeyframeLocked pide un keyframe al publicador como mucho una vez por
+// ingestKeyframeInterval. Debe llamarse con el mutex tomado.
+func (s *WebRTCIngestSource) requestKeyframeLocked() {
+	if s.requestKeyframe == nil || time.Since(s.lastKeyframeReq) < ingestKeyframeInterval {
+		return
+	}
+	s.lastKeyframeReq = time.Now()
+	go s.requestKeyframe()
+}
+
+// forceKeyframe pide un keyframe al publicador, por ejemplo al unirse un visor
+func (s *WebRTCIngestSource) forceKeyframe() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastKeyframeReq = time.Time{}
+	s.requestKeyframeLocked()
+}
+
+func (s *WebRTCIngestSource) Capabilities() CameraCapabilities {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	caps := CameraCapabilities{
+		ID:         "webrtc",
+		Name:       "WebRTC Ingest",
+		Backend:    "webrtc",
+		Resolution: s.resolution,
+		Formats:    []string{codecJPEG},
+	}
+	if s.publisher != "" {
+		caps.Name = fmt.Sprintf("WebRTC Ingest (%s)", s.publisher)
+	}
+	if s.codec != "" {
+		caps.Formats = append(caps.Formats, s.codec)
+	}
+	return caps
+}
+
+func (s *WebRTCIngestSource) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.lastSnapshotSeq = 0
+	return nil
+}
+
+// newTrackIngest registra un track de video publicado como el activo.
+// requestKeyframe debe enviar un PLI al publicador.
+func (s *WebRTCIngestSource) newTrackIngest(peerID string, codec webrtc.RTPCodecParameters, requestKeyframe func()) (*trackIngest, error) {
+	var depacketizer rtp.Depacketizer
+	var mimeType string
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeVP8):
+		depacketizer = &codecs.VP8Packet{}
+		mimeType = codecVP8
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeH264):
+		depacketizer = &codecs.H264Packet{}
+		mimeType = codecH264
+	default:
+		return nil, fmt.Errorf("unsupported ingest codec: %s", codec.MimeType)
+	}
+
+	s.mutex.Lock()
+	if s.publisher != "" && s.publisher != peerID {
+		log.Printf("🔀 Publicador %s reemplaza a %s", peerID, s.publisher)
+	}
+	s.publisher = peerID
+	s.codec = mimeType
+	s.keyframe = nil
+	s.requestKeyframe = requestKeyframe
+	s.lastKeyframeReq = time.Time{}
+	s.requestKeyframeLocked()
+	s.mutex.Unlock()
+
+	log.Printf("📥 Ingesta WebRTC activa: peer %s (%s)", peerID, mimeType)
+
+	return &trackIngest{
+		source:  s,
+		peerID:  peerID,
+		codec:   mimeType,
+		builder: samplebuilder.New(ingestMaxLate, depacketizer, codec.ClockRate),
+	}, nil
+}
+
+// push entrega un paquete RTP al depacketizador y publica las muestras completas
+func (t *trackIngest) push(packet *rtp.Packet) {
+	t.builder.Push(packet)
+
+	for {
+		sample := t.builder.Pop()
+		if sample == nil {
+			return
+		}
+		if len(sample.Data) == 0 {
+			continue
+		}
+
+		frame := &Frame{
+			Codec:     t.codec,
+			Timestamp: time.Now(),
+			Duration:  sample.Duration,
+		}
+		switch t.codec {
+		case codecVP8:
+			frame.Data = sample.Data
+			frame.Keyframe = isVP8Keyframe(sample.Data)
+		case codecH264:
+			frame.Data = t.params.complete(sample.Data)
+			frame.Keyframe = isH264Keyframe(frame.Data)
+		}
+
+		t.source.publish(t.peerID, frame)
+	}
+}
+
+// close da de baja el track si sigue siendo el publicador activo
+func (t *trackIngest) close() {
+	s := t.source
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.publisher != t.peerID {
+		return
+	}
+	s.publisher = ""
+	s.requestKeyframe = nil
+	log.Printf("📴 Ingesta WebRTC finalizada: peer %s", t.peerID)
+}
+
+func (s *WebRTCIngestSource) publish(peerID string, frame *Frame) {
+	s.mutex.Lock()
+	if s.publisher != peerID {
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+
+	s.video.Publish(frame)
+
+	if frame.Keyframe {
+		s.mutex.Lock()
+		s.keyframe = frame
+		close(s.updated)
+		s.updated = make(chan struct{})
+		s.mutex.Unlock()
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// sessionIdleTimeout es cuánto puede estar un peer sin conectar antes de expirar
+	sessionIdleTimeout = 30 * time.Second
+	// sessionSweepInterval es cada cuánto se revisan las sesiones expiradas
+	sessionSweepInterval = 10 * time.Second
+)
+
+// peerSession guarda el estado de cada peer connection registrada en
+// WebRTCManager.peerConnections (WebSocket, WHIP o WHEP)
+type peerSession struct {
+	ID             string    `json:"id"`
+	CreatedAt      time.Time `json:"createdAt"`
+	State          string    `json:"state"`
+	StateChangedAt time.Time `json:"stateChangedAt"`
+}
+
+// updateSessionState registra un cambio de estado de conexión del peer
+func (w *WebRTCManager) updateSessionState(peerID string, state webrtc.PeerConnectionState) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if session, exists := w.sessions[peerID]; exists {
+		session.State = state.String()
+		session.StateChangedAt = time.Now()
+	}
+}
+
+// expireSessions elimina periódicamente los peers que no llegaron a
+// conectar o que llevan demasiado tiempo desconectados
+func (w *WebRTCManager) expireSessions() {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var expired []string
+
+		w.mutex.RLock()
+		for peerID, session := range w.sessions {
+			if session.State == webrtc.PeerConnectionStateConnected.String() {
+				continue
+			}
+			if time.Since(session.StateChangedAt) > sessionIdleTimeout {
+				expired = append(expired, peerID)
+			}
+		}
+		w.mutex.RUnlock()
+
+		for _, peerID := range expired {
+			log.Printf("⌛ Sesión %s expirada", peerID)
+			w.removePeerConnection(peerID)
+		}
+	}
+}
+
+// listSessions devuelve una copia de las sesiones activas
+func (w *WebRTCManager) listSessions() []peerSession {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	sessions := make([]peerSession, 0, len(w.sessions))
+	for _, session := range w.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+const (
+	// viewerGatherTimeout limita la espera de candidatos ICE antes de enviar el offer
+	viewerGatherTimeout = 5 * time.Second
+	// viewerConnectTimeout es lo que se espera a que el visor conecte
+	viewerConnectTimeout = 30 * time.Second
+	// viewerBuffer es cuántos frames puede acumular un visor lento
+	viewerBuffer = 30
+)
+
+// handleWatch atiende a un navegador que quiere ver la cámara: el servidor
+// crea el offer con el video y espera el answer por el mismo WebSocket
+func (w *WebRTCManager) handleWatch(conn *websocket.Conn, msg SignalingMessage) {
+	peerID := msg.PeerID
+
+	offer, err := w.createViewerOffer(peerID)
+	if err != nil {
+		log.Printf("❌ Error creando visor %s: %v", peerID, err)
+		conn.WriteJSON(SignalingMessage{
+			Type:    "error",
+			PeerID:  peerID,
+			Payload: err.Error(),
+		})
+		return
+	}
+
+	response := SignalingMessage{
+		Type:    "offer",
+		PeerID:  peerID,
+		Payload: offer,
+	}
+	if err := conn.WriteJSON(response); err != nil {
+		log.Printf("❌ Error enviando offer: %v", err)
+	}
+}
+
+// createViewerOffer crea una peer connection de solo envío con el video de
+// la cámara activa y devuelve el offer con los candidatos ICE incluidos
+func (w *WebRTCManager) createViewerOffer(peerID string) (*webrtc.SessionDescription, error) {
+	pc, start, err := w.prepareViewer(peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		w.removePeerConnection(peerID)
+		return nil, fmt.Errorf("failed to create offer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		w.removePeerConnection(peerID)
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+	select {
+	case <-gatherComplete:
+	case <-time.After(viewerGatherTimeout):
+		log.Printf("⚠️  ICE gathering incompleto para visor %s", peerID)
+	}
+
+	go start()
+
+	return pc.LocalDescription(), nil
+}
+
+// prepareViewer crea la peer connection de un visor con el track de video y
+// devuelve la función que empieza a enviarle video una vez negociada.
+// Si hay un navegador publicando y la cámara es WebRTC (o no hay otro video)
+// se reenvía su RTP directamente; si no, se envían las muestras del broker.
+func (w *WebRTCManager) prepareViewer(peerID string) (*webrtc.PeerConnection, func(), error) {
+	var broker *FrameBroker
+	if w.video != nil {
+		broker = w.video()
+	}
+	relay := w.activeRelay()
+	useRelay := relay != nil && (w.ingest != nil || broker == nil)
+
+	var track webrtc.TrackLocal
+	var start func(pc *webrtc.PeerConnection)
+	var requestKeyframe func()
+
+	if useRelay {
+		localTrack, err := webrtc.NewTrackLocalStaticRTP(relay.codec, "video", "alien-cam")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create track: %w", err)
+		}
+		track = localTrack
+		start = func(pc *webrtc.PeerConnection) {
+			w.startRelay(peerID, pc, relay, localTrack)
+		}
+		requestKeyframe = relay.keyframe
+	} else {
+		if broker == nil {
+			return nil, nil, fmt.Errorf("no encoded video available (start the camera; JPEG sources need ffmpeg)")
+		}
+
+		// El códec del track depende de lo que entregue la fuente
+		frame := broker.WaitFrame(time.Hour, viewerGatherTimeout)
+		if frame == nil {
+			return nil, nil, fmt.Errorf("no video frames available")
+		}
+		mimeType := webrtc.MimeTypeH264
+		if frame.Codec == codecVP8 {
+			mimeType = webrtc.MimeTypeVP8
+		}
+
+		localTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: mimeType}, "video", "alien-cam")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create track: %w", err)
+		}
+		track = localTrack
+		start = func(pc *webrtc.PeerConnection) {
+			w.startVideoCapture(peerID, pc, localTrack, broker)
+		}
+		if w.ingest != nil {
+			requestKeyframe = w.ingest.forceKeyframe
+		}
+	}
+
+	pc, err := w.createPeerConnection(peerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	transceiver, err := pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly,
+	})
+	if err != nil {
+		w.removePeerConnection(peerID)
+		return nil, nil, fmt.Errorf("failed to add track: %w", err)
+	}
+
+	// Leer RTCP del visor: hace funcionar los interceptores (NACK, reportes)
+	// y reenvía al publicador las peticiones de keyframe (PLI/FIR)
+	go func() {
+		for {
+			packets, _, err := transceiver.Sender().ReadRTCP()
+			if err != nil {
+				return
+			}
+			for _, packet := range packets {
+				switch packet.(type) {
+				case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+					if requestKeyframe != nil {
+						requestKeyframe()
+					}
+				}
+			}
+		}
+	}()
+
+	return pc, func() { start(pc) }, nil
+}
+
+// waitForViewer espera a que el visor conecte; si no lo hace a tiempo
+// elimina su peer connection y devuelve false
+func (w *WebRTCManager) waitForViewer(peerID string, pc *webrtc.PeerConnection) bool {
+	deadline := time.Now().Add(viewerConnectTimeout)
+	for pc.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		if isPeerFinished(pc) || time.Now().After(deadline) {
+			log.Printf("⚠️  Visor %s no llegó a conectar", peerID)
+			w.removePeerConnection(peerID)
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// startVideoCapture alimenta el track local del visor con el video de la
+// cámara hasta que la peer connection se cierra
+func (w *WebRTCManager) startVideoCapture(peerID string, pc *webrtc.PeerConnection, track *webrtc.TrackLocalStaticSample, broker *FrameBroker) {
+	log.Printf("🎥 Iniciando envío de video para peer %s", peerID)
+
+	// Esperar a que el visor conecte para empezar en un keyframe
+	if !w.waitForViewer(peerID, pc) {
+		return
+	}
+
+	sub := broker.Subscribe(viewerBuffer)
+	defer sub.Close()
+
+	if w.ingest != nil {
+		w.ingest.forceKeyframe()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var last time.Time
+	for {
+		select {
+		case frame := <-sub.C:
+			duration := frame.Duration
+			if !last.IsZero() {
+				duration = frame.Timestamp.Sub(last)
+			}
+			if duration <= 0 {
+				duration = 33 * time.Millisecond
+			}
+			last = frame.Timestamp
+
+			if err := track.WriteSample(media.Sample{Data: frame.Data, Duration: duration}); err != nil && err != io.ErrClosedPipe {
+				log.Printf("❌ Error enviando video a peer %s: %v", peerID, err)
+				return
+			}
+		case <-ticker.C:
+		}
+
+		if isPeerFinished(pc) {
+			log.Printf("⏹️  Envío de video finalizado para peer %s (%d frames descartados)", peerID, sub.Dropped())
+			return
+		}
+	}
+}
+
+// isPeerFinished indica si la peer connection ya no volverá a conectar
+func isPeerFinished(pc *webrtc.PeerConnection) bool {
+	state := pc.ConnectionState()
+	return state == webrtc.PeerConnectionStateClosed || state == webrtc.PeerConnectionStateFailed
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/webrtc/v3"
+)
+
+// handleWHEP recibe el offer SDP de un reproductor, le adjunta el video de
+// la cámara activa y responde con el answer en un único intercambio HTTP
+func (w *WebRTCManager) handleWHEP(c *gin.Context) {
+	setWHIPHeaders(c)
+
+	offerSDP, status, err := readSDPBody(c, "application/sdp")
+	if err != nil {
+		c.String(status, err.Error())
+		return
+	}
+
+	resourceID := newSessionID("whep")
+	log.Printf("📺 Reproducción WHEP solicitada: %s", resourceID)
+
+	pc, start, err := w.prepareViewer(resourceID)
+	if err != nil {
+		log.Printf("❌ Error preparando visor WHEP: %v", err)
+		c.String(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	answer, err := answerWithCandidates(pc, webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offerSDP,
+	}, whipGatherTimeout)
+	if err != nil {
+		log.Printf("❌ Error negociando WHEP %s: %v", resourceID, err)
+		w.removePeerConnection(resourceID)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	go start()
+
+	c.Header("Location", "/whep/"+resourceID)
+	c.Header("ETag", `"`+resourceID+`"`)
+	c.Data(http.StatusCreated, "application/sdp", []byte(answer.SDP))
+}
+
+// handleWHEPPatch añade candidatos ICE enviados por trickle
+func (w *WebRTCManager) handleWHEPPatch(c *gin.Context) {
+	w.patchSession(c, "whep-")
+}
+
+// handleWHEPDelete termina la sesión del reproductor
+func (w *WebRTCManager) handleWHEPDelete(c *gin.Context) {
+	w.deleteSession(c, "whep-")
+}
+
+// handleSessions lista las sesiones WebRTC activas
+func (w *WebRTCManager) handleSessions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": w.listSessions(),
+	})
+}
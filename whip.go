@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// whipGatherTimeout limita la espera de candidatos ICE del servidor
+	whipGatherTimeout = 5 * time.Second
+	// whipMaxSDPSize limita el tamaño del cuerpo SDP aceptado
+	whipMaxSDPSize = 64 * 1024
+)
+
+// newSessionID genera un identificador aleatorio para recursos WHIP/WHEP
+func newSessionID(prefix string) string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	}
+	return prefix + "-" + hex.EncodeToString(buf)
+}
+
+// setWHIPHeaders añade las cabeceras CORS que necesitan los clientes web
+func setWHIPHeaders(c *gin.Context) {
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+	c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+	c.Header("Access-Control-Expose-Headers", "Location, ETag, Link")
+}
+
+// readSDPBody lee el cuerpo de una petición con el content type esperado.
+// Si falla devuelve también el código HTTP con el que responder.
+func readSDPBody(c *gin.Context, contentType string) (string, int, error) {
+	if !strings.HasPrefix(c.GetHeader("Content-Type"), contentType) {
+		return "", http.StatusUnsupportedMediaType, fmt.Errorf("content type must be %s", contentType)
+	}
+	// Se lee un byte de más para distinguir un cuerpo demasiado grande
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, whipMaxSDPSize+1))
+	if err != nil {
+		return "", http.StatusBadRequest, fmt.Errorf("failed to read body: %v", err)
+	}
+	if len(body) > whipMaxSDPSize {
+		return "", http.StatusRequestEntityTooLarge, fmt.Errorf("body larger than %d bytes", whipMaxSDPSize)
+	}
+	if len(body) == 0 {
+		return "", http.StatusBadRequest, fmt.Errorf("empty body")
+	}
+	return string(body), 0, nil
+}
+
+// handleWHIPOptions responde a los preflight CORS
+func (w *WebRTCManager) handleWHIPOptions(c *gin.Context) {
+	setWHIPHeaders(c)
+	c.Header("Accept-Post", "application/sdp")
+	c.Status(http.StatusNoContent)
+}
+
+// handleWHIP recibe el offer SDP de un publicador (OBS, GStreamer...) y
+// responde con el answer, creando un recurso para PATCH y DELETE
+func (w *WebRTCManager) handleWHIP(c *gin.Context) {
+	setWHIPHeaders(c)
+
+	offerSDP, status, err := readSDPBody(c, "application/sdp")
+	if err != nil {
+		c.String(status, err.Error())
+		return
+	}
+
+	resourceID := newSessionID("whip")
+	log.Printf("📥 Publicación WHIP recibida: %s", resourceID)
+
+	pc, err := w.createPeerConnection(resourceID)
+	if err != nil {
+		log.Printf("❌ Error creando peer connection WHIP: %v", err)
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	answer, err := answerWithCandidates(pc, webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offerSDP,
+	}, whipGatherTimeout)
+	if err != nil {
+		log.Printf("❌ Error negociando WHIP %s: %v", resourceID, err)
+		w.removePeerConnection(resourceID)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.Header("Location", "/whip/"+resourceID)
+	c.Header("ETag", `"`+resourceID+`"`)
+	c.Data(http.StatusCreated, "application/sdp", []byte(answer.SDP))
+}
+
+// handleWHIPPatch añade candidatos ICE enviados por trickle
+func (w *WebRTCManager) handleWHIPPatch(c *gin.Context) {
+	w.patchSession(c, "whip-")
+}
+
+// handleWHIPDelete termina la sesión del publicador
+func (w *WebRTCManager) handleWHIPDelete(c *gin.Context) {
+	w.deleteSession(c, "whip-")
+}
+
+// patchSession añade a un recurso WHIP/WHEP los candidatos ICE de un
+// fragmento trickle-ice-sdpfrag
+func (w *WebRTCManager) patchSession(c *gin.Context, prefix string) {
+	setWHIPHeaders(c)
+
+	resourceID := c.Param("id")
+	pc := w.getPeerConnection(resourceID)
+	if pc == nil || !strings.HasPrefix(resourceID, prefix) {
+		c.String(http.StatusNotFound, "resource not found")
+		return
+	}
+
+	fragment, status, err := readSDPBody(c, "application/trickle-ice-sdpfrag")
+	if err != nil {
+		c.String(status, err.Error())
+		return
+	}
+
+	if err := addSDPFragCandidates(pc, fragment); err != nil {
+		log.Printf("❌ Error añadiendo candidatos a %s: %v", resourceID, err)
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// deleteSession termina un recurso WHIP/WHEP
+func (w *WebRTCManager) deleteSession(c *gin.Context, prefix string) {
+	setWHIPHeaders(c)
+
+	resourceID := c.Param("id")
+	if w.getPeerConnection(resourceID) == nil || !strings.HasPrefix(resourceID, prefix) {
+		c.String(http.StatusNotFound, "resource not found")
+		return
+	}
+
+	w.removePeerConnection(resourceID)
+	c.Status(http.StatusOK)
+}
+
+// getPeerConnection busca una peer connection por su ID
+func (w *WebRTCManager) getPeerConnection(peerID string) *webrtc.PeerConnection {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.peerConnections[peerID]
+}
+
+// answerWithCandidates aplica el offer remoto y devuelve el answer local una
+// vez reunidos los candidatos ICE (o agotado el timeout)
+func answerWithCandidates(pc *webrtc.PeerConnection, offer webrtc.SessionDescription, timeout time.Duration) (*webrtc.SessionDescription, error) {
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return nil, fmt.Errorf("failed to set remote description: %w", err)
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create answer: %w", err)
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return nil, fmt.Errorf("failed to set local description: %w", err)
+	}
+	select {
+	case <-gatherComplete:
+	case <-time.After(timeout):
+		log.Printf("⚠️  ICE gathering incompleto, se envía answer parcial")
+	}
+
+	return pc.LocalDescription(), nil
+}
+
+// addSDPFragCandidates interpreta un fragmento trickle-ice-sdpfrag (RFC 8840)
+// y añade sus candidatos a la peer connection
+func addSDPFragCandidates(pc *webrtc.PeerConnection, fragment string) error {
+	var mid *string
+	var mLineIndex uint16
+	mLines := -1
+
+	for _, line := range strings.Split(fragment, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			mLines++
+			mLineIndex = uint16(mLines)
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidate := webrtc.ICECandidateInit{
+				Candidate: strings.TrimPrefix(line, "a="),
+				SDPMid:    mid,
+			}
+			if mLines >= 0 {
+				index := mLineIndex
+				candidate.SDPMLineIndex = &index
+			}
+			if err := pc.AddICECandidate(candidate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}